@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
+	// Sync window time zones must resolve without tzdata in the image
+	_ "time/tzdata"
 
 	"omni-cd/internal/config"
 	"omni-cd/internal/git"
 	"omni-cd/internal/omni"
+	"omni-cd/internal/queue"
 	"omni-cd/internal/reconciler"
+	"omni-cd/internal/redact"
 	"omni-cd/internal/state"
 	"omni-cd/internal/web"
 )
@@ -32,22 +39,51 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Scrub credentials from everything that ends up in logs or the web UI
+	redact.Register(cfg.GitToken, cfg.OmniServiceAccountKey, cfg.WebhookSecret)
+	if cfg.GitToken != "" {
+		// git may echo the header it sends the token in
+		redact.Register(git.BasicAuth(cfg.GitToken))
+	}
+	if cfg.GitSSHKey != "" {
+		// Output may carry single lines of the key rather than all of it
+		redact.Register(cfg.GitSSHKey)
+		redact.Register(strings.Split(cfg.GitSSHKey, "\n")...)
+	}
+
 	// Configure slog with JSON handler and configured log level
 	logLevel := parseLogLevel(cfg.LogLevel)
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	slog.SetDefault(slog.New(redact.NewHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: logLevel,
-	})))
+	}))))
 
 	logInfo("Starting OmniCD")
-	logInfo("Watching repository", "repo", cfg.GitRepo, "branch", cfg.GitBranch)
+	if cfg.GitRefMode == "tag" {
+		logInfo("Watching repository", "repo", cfg.GitRepo, "tags", cfg.GitTagSemver)
+	} else {
+		logInfo("Watching repository", "repo", cfg.GitRepo, "branch", cfg.GitBranch)
+	}
 	logInfo("Machine classes path", "path", cfg.MCPath)
 	logInfo("Cluster templates path", "path", cfg.ClustersPath)
 	logInfo("Cluster sync configuration", "enabled", cfg.ClustersEnabled)
+	logInfo("Prune guard", "max_deletions", cfg.PruneMaxDeletions, "max_percent", cfg.PruneMaxPercent, "require_approval", cfg.PruneRequireApproval)
+	if len(cfg.SyncWindows) > 0 {
+		logInfo("Sync windows", "windows", len(cfg.SyncWindows), "timezone", cfg.SyncWindowsLocation.String())
+	}
+	logInfo("Cluster rollout", "health_timeout", cfg.RolloutHealthTimeout, "wave_from_directory", cfg.RolloutWaveFromDirectory)
+	logInfo("Omni call limits", "max_parallel_syncs", cfg.MaxParallelSyncs, "cluster_sync_timeout", cfg.ClusterSyncTimeout, "omni_timeout", cfg.OmniTimeout)
+	logInfo("Shutdown grace period", "grace_period", cfg.ShutdownGracePeriod)
 	logInfo("Refresh reconcile interval", "interval", cfg.RefreshInterval)
 	logInfo("Sync reconcile interval", "interval", cfg.SyncInterval)
+	logInfo("Git webhooks", "enabled", cfg.WebhookSecret != "")
+
+	omniClient := omni.NewOmnictl(omni.Timeouts{
+		Call: cfg.OmniTimeout,
+		Sync: cfg.ClusterSyncTimeout,
+	}, cfg.MaxParallelSyncs)
 
 	// Verify omnictl connectivity
-	if err := omni.CheckConnectivity(); err != nil {
+	if err := omniClient.CheckConnectivity(context.Background()); err != nil {
 		logInfo("omnictl authentication failed", "error", err)
 		os.Exit(1)
 	}
@@ -59,8 +95,8 @@ func main() {
 	logDebug("State file configured", "path", stateFile)
 
 	// Fetch and store version info
-	omniVersion := omni.GetOmniVersion()
-	omnictlVersion := omni.GetOmnictlVersion()
+	omniVersion := omniClient.GetOmniVersion(context.Background())
+	omnictlVersion := omniClient.GetOmnictlVersion(context.Background())
 	versionMismatch := omni.CompareVersions(omniVersion, omnictlVersion)
 	appState.SetVersions(omniVersion, omnictlVersion, versionMismatch)
 	logDebug("Omni version", "version", omniVersion)
@@ -70,12 +106,12 @@ func main() {
 		logError("Sync disabled due to version mismatch")
 	}
 
-	// Channels for web UI to trigger reconciles
-	triggerHard := make(chan struct{}, 1)
-	triggerSoft := make(chan struct{}, 1)
+	// Reconcile requests from the web UI, webhooks and the timers below
+	reconcileQueue := queue.New()
+	reconcileQueue.OnChange(func() { appState.SetQueue(reconcileQueue.Snapshot()) })
 
 	// Start the web UI server
-	webServer := web.New(appState, triggerHard, triggerSoft, cfg.WebPort, version)
+	webServer := web.New(appState, omniClient, reconcileQueue, cfg.WebPort, version, cfg.GitBranch, cfg.GitRefMode, cfg.WebhookSecret)
 	webServer.Start()
 
 	// Set up graceful shutdown
@@ -83,14 +119,35 @@ func main() {
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
 	gitClient := git.New(cfg, appState)
-	rec := reconciler.New(appState)
+	rec := reconciler.New(appState, omniClient)
+	rec.SetPruneGuard(reconciler.PruneGuard{
+		MaxDeletions:    cfg.PruneMaxDeletions,
+		MaxPercent:      cfg.PruneMaxPercent,
+		RequireApproval: cfg.PruneRequireApproval,
+	})
+	rec.SetSyncWindows(cfg.SyncWindows, cfg.SyncWindowsLocation)
+	rec.SetRollout(reconciler.Rollout{
+		HealthTimeout:     cfg.RolloutHealthTimeout,
+		WaveFromDirectory: cfg.RolloutWaveFromDirectory,
+	})
+	rec.SetLimits(reconciler.Limits{
+		MaxParallel:    cfg.MaxParallelSyncs,
+		ClusterTimeout: cfg.ClusterSyncTimeout,
+	})
+	if cfg.AccessPath != "" {
+		if name := omni.ServiceAccountName(cfg.OmniServiceAccountKey); name != "" {
+			rec.ProtectServiceAccount(name)
+		} else {
+			logWarn("Cannot tell which service account omni-cd uses, service accounts will not be deleted")
+		}
+	}
 
 	// pollClusterStatuses fetches live ready-status for every cluster and
 	// writes it into AppState.  Defined here so it can be called both from
 	// the background ticker AND synchronously at the end of each reconcile
 	// (when s.Clusters is guaranteed to be populated).
 	pollClusterStatuses := func() {
-		statuses, err := omni.GetAllClusterReadyStatuses()
+		statuses, err := omniClient.GetAllClusterReadyStatuses(context.Background())
 		if err == nil {
 			appState.UpdateClusterReadyStatuses(statuses)
 		}
@@ -106,72 +163,149 @@ func main() {
 		}
 	}()
 
-	// Run immediately on start (hard reconcile)
-	doReconcile(gitClient, rec, cfg, true)
-	// Poll right after the initial reconcile so ClusterReady is populated
-	// as soon as the cluster list exists in state (avoids waiting a full
-	// 5-second tick for the first badge to appear).
-	go pollClusterStatuses()
+	// Reconciles run in the background so that shutdown signals are seen
+	// while one is in flight. done is closed when it returns and is nil
+	// while idle; queued requests wait until then.
+	var done chan struct{}
+	startNext := func() {
+		if done != nil {
+			return
+		}
+		req, ok := reconcileQueue.Start()
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			defer cancel()
+			doReconcile(ctx, cancel, gitClient, omniClient, rec, cfg, req)
+		}(done)
+	}
 
-	// Start refresh timer after first reconcile completes
+	// Run immediately on start (hard reconcile)
+	reconcileQueue.Add(queue.Request{Kind: queue.Sync}, "startup")
+	// The refresh timer starts once the first reconcile completes
 	refreshTimer := time.NewTimer(cfg.RefreshInterval)
+	refreshTimer.Stop()
 	syncTicker := time.NewTicker(cfg.SyncInterval)
 	defer refreshTimer.Stop()
 	defer syncTicker.Stop()
 
+	// Sync as soon as the window of a cluster with held-back changes opens
+	// rather than on the next scheduled sync
+	windowTimer := time.NewTimer(time.Hour)
+	windowTimer.Stop()
+	defer windowTimer.Stop()
+	scheduleWindow := func() {
+		windowTimer.Stop()
+		if next := appState.NextClusterWindow(); !next.IsZero() {
+			windowTimer.Reset(time.Until(next))
+		}
+	}
+	// The state loaded from disk may already wait for a window
+	scheduleWindow()
+
 	for {
+		// Timers are only taken while no reconcile is running
+		var refreshC, syncC, windowC <-chan time.Time
+		if done == nil {
+			refreshC, syncC, windowC = refreshTimer.C, syncTicker.C, windowTimer.C
+		}
+
 		select {
-		case <-refreshTimer.C:
-			doReconcile(gitClient, rec, cfg, false)
-			go pollClusterStatuses()
-			refreshTimer.Reset(cfg.RefreshInterval)
-		case <-syncTicker.C:
-			logInfo("Sync reconcile triggered", "trigger", "scheduled")
-			doReconcile(gitClient, rec, cfg, true)
-			go pollClusterStatuses()
-			refreshTimer.Reset(cfg.RefreshInterval)
-		case <-triggerHard:
-			logInfo("Sync reconcile triggered", "trigger", "web UI")
-			doReconcile(gitClient, rec, cfg, true)
-			go pollClusterStatuses()
-			refreshTimer.Reset(cfg.RefreshInterval)
-		case <-triggerSoft:
-			logInfo("Git check triggered", "trigger", "web UI")
-			doReconcile(gitClient, rec, cfg, false)
+		case <-done:
+			done = nil
+			reconcileQueue.Finish()
+			// Poll right after each reconcile so ClusterReady is populated
+			// as soon as the cluster list exists in state (avoids waiting a
+			// full 5-second tick for the first badge to appear).
 			go pollClusterStatuses()
 			refreshTimer.Reset(cfg.RefreshInterval)
+			scheduleWindow()
+			startNext()
+		case <-reconcileQueue.Wake():
+			startNext()
+		case <-refreshC:
+			reconcileQueue.Add(queue.Request{Kind: queue.Refresh}, "scheduled")
+		case <-syncC:
+			reconcileQueue.Add(queue.Request{Kind: queue.Sync}, "scheduled")
+		case <-windowC:
+			reconcileQueue.Add(queue.Request{Kind: queue.Sync}, "sync window")
 		case <-stop:
 			logInfo("Shutting down gracefully")
+			shutdown(done, rec, cfg.ShutdownGracePeriod)
 			return
 		}
 	}
 }
 
-// doReconcile performs a single git sync + reconcile cycle.
-func doReconcile(gitClient *git.Client, rec *reconciler.Reconciler, cfg *config.Config, force bool) {
+// shutdown lets a reconcile in flight finish the omnictl calls it is
+// running, but start no new ones, for up to the grace period. After that
+// the calls are aborted. The state is saved either way.
+func shutdown(done chan struct{}, rec *reconciler.Reconciler, grace time.Duration) {
+	if done != nil {
+		logInfo("Waiting for the running reconcile to stop", "grace_period", grace)
+		rec.Drain()
+		select {
+		case <-done:
+		case <-time.After(grace):
+			logWarn("Reconcile still running after the grace period, aborting it")
+			appState.CancelReconcile()
+			<-done
+		}
+	}
+	appState.Save()
+	logInfo("State saved, exiting")
+}
+
+// doReconcile performs a single git sync + reconcile cycle for a queued
+// request. It stops early when ctx is done; cancel is what CancelReconcile
+// calls to get there.
+func doReconcile(ctx context.Context, cancel context.CancelFunc, gitClient *git.Client, omniClient omni.Client, rec *reconciler.Reconciler, cfg *config.Config, req queue.Request) {
 	// Block everything when version mismatch
 	if appState.Snapshot().VersionMismatch {
 		logError("All operations disabled due to version mismatch")
 		return
 	}
 
+	force := req.Kind != queue.Refresh
+	rec.SetForce(reconciler.Force{
+		Clusters:              req.Clusters,
+		MachineClasses:        req.MachineClasses,
+		ClusterSelectors:      req.ClusterSelectors,
+		MachineClassSelectors: req.MachineClassSelectors,
+		OverrideWindow:        req.OverrideWindow,
+	})
+	attrs := []any{"type", string(req.Kind), "trigger", strings.Join(req.Triggers, ", ")}
+	if req.Kind == queue.Targeted {
+		attrs = append(attrs,
+			"clusters", strings.Join(slices.Concat(req.Clusters, req.ClusterSelectors), "; "),
+			"machine_classes", strings.Join(slices.Concat(req.MachineClasses, req.MachineClassSelectors), "; "))
+	}
+	logInfo("Reconcile started", attrs...)
 	if force {
-		logInfo("Reconcile started", "type", "sync")
-		appState.SetReconcileStarted(state.ReconcileHard)
+		appState.SetReconcileStarted(state.ReconcileHard, cancel)
 	} else {
-		logInfo("Reconcile started", "type", "refresh")
-		appState.SetReconcileStarted(state.ReconcileSoft)
+		appState.SetReconcileStarted(state.ReconcileSoft, cancel)
 	}
 
 	// Check Omni connectivity
-	if err := omni.CheckConnectivity(); err != nil {
+	if err := omniClient.CheckConnectivity(ctx); err != nil {
 		logError("Omni connectivity check failed", "error", err)
-		appState.SetOmniHealth("failed", err.Error())
+		appState.SetOmniHealth("failed", redact.String(err.Error()))
 	} else {
 		appState.SetOmniHealth("healthy", "")
 	}
 
-	changed, err := gitClient.Sync()
+	changed, err := gitClient.Sync(ctx)
+	if ctx.Err() != nil {
+		logWarn("Reconcile cancelled")
+		appState.SetReconcileCancelled()
+		appState.Save()
+		return
+	}
 	if err != nil {
 		logError("Git sync failed", "error", err)
 		appState.SetReconcileFinished(false)
@@ -179,49 +313,90 @@ func doReconcile(gitClient *git.Client, rec *reconciler.Reconciler, cfg *config.
 		return
 	}
 
+	stopped := false
 	if changed || force {
 		repoDir := gitClient.RepoDir()
 
+		// A refresh only processes what the new commit touched; syncs (and
+		// refreshes where the change set is unknown) cover everything, and
+		// targeted syncs the resources they select.
+		rec.SetScope(repoDir, nil)
+		fullSync := req.Kind != queue.Targeted
+		if !force {
+			if files, ok := gitClient.ChangedFiles(); ok {
+				rec.SetScope(repoDir, files)
+				fullSync = false
+				logInfo("Reconciling changed resources only", "changed_files", len(files))
+			}
+		}
+
+		gitInfo := appState.Snapshot().Git
+		appState.RecordCommit(state.CommitRecord{
+			SHA:          gitInfo.SHA,
+			ShortSHA:     gitInfo.ShortSHA,
+			Author:       gitInfo.Author,
+			Message:      gitInfo.CommitMessage,
+			CommitTime:   gitInfo.CommitTime,
+			ReconciledAt: time.Now(),
+			Outcome:      "running",
+		})
+
 		// If version mismatch, do nothing
 		if appState.Snapshot().VersionMismatch {
 			logError("All operations disabled due to version mismatch")
 		} else {
-			// ---- Apply phase (dependencies first) ----
-			// 1. Machine classes must exist before clusters can reference them
-			rec.ApplyMachineClasses(repoDir + "/" + cfg.MCPath)
-
-			// 2. Cluster templates (only if enabled or force sync requested)
-			if appState.GetClustersEnabled() || appState.HasForceClusterID() {
-				rec.ApplyClusters(repoDir + "/" + cfg.ClustersPath)
-			} else {
-				rec.DiffClusters(repoDir + "/" + cfg.ClustersPath)
-			}
-
-			// ---- Delete phase (dependents first) ----
-			// 3. Remove clusters before their machine classes (only if enabled)
-			if appState.GetClustersEnabled() {
-				rec.DeleteClusters(repoDir + "/" + cfg.ClustersPath)
-			} else {
-				logInfo("Cluster sync disabled, skipping cluster delete")
-			}
+			// Kinds are applied dependencies first and pruned dependents
+			// first; see reconciler.Reconcile
+			err := rec.Reconcile(ctx, reconciler.Paths{
+				Machines:       optionalPath(repoDir, cfg.MachinesPath),
+				MachineClasses: repoDir + "/" + cfg.MCPath,
+				Clusters:       repoDir + "/" + cfg.ClustersPath,
+				ConfigPatches:  optionalPath(repoDir, cfg.PatchesPath),
+				Access:         optionalPath(repoDir, cfg.AccessPath),
+			})
+			stopped = errors.Is(err, reconciler.ErrStopped) || ctx.Err() != nil
+		}
 
-			// 4. Machine classes can now be safely removed
-			rec.DeleteMachineClasses(repoDir + "/" + cfg.MCPath)
+		// Record what this commit changed in the history
+		resources, failed := rec.Touched()
+		outcome := "success"
+		if stopped {
+			outcome = "cancelled"
+		} else if failed {
+			outcome = "failed"
+		} else if len(resources) == 0 && !fullSync {
+			outcome = "skipped"
 		}
+		appState.FinishCommit(gitInfo.SHA, outcome, resources, fullSync)
 	} else {
 		// No git change and not a forced reconcile.
 		// Still run cluster diff if sync is disabled so we detect drift.
 		if !appState.GetClustersEnabled() {
-			rec.DiffClusters(gitClient.RepoDir() + "/" + cfg.ClustersPath)
+			rec.DiffClusters(ctx, gitClient.RepoDir()+"/"+cfg.ClustersPath)
 		}
 		logDebug("Repository up to date, no reconciliation needed")
 	}
 
+	if stopped {
+		appState.SetReconcileCancelled()
+		appState.Save()
+		logWarn("Reconcile cancelled")
+		return
+	}
 	appState.SetReconcileFinished(true)
 	appState.Save()
 	logInfo("Reconcile finished")
 }
 
+// optionalPath joins an optional resource path onto the checkout, keeping
+// it empty when the resource kind is not managed.
+func optionalPath(repoDir, path string) string {
+	if path == "" {
+		return ""
+	}
+	return repoDir + "/" + path
+}
+
 func logDebug(msg string, attrs ...any) {
 	// Add component as first attribute
 	allAttrs := append([]any{"component", "Main"}, attrs...)
@@ -246,6 +421,18 @@ func logInfo(msg string, attrs ...any) {
 	}
 }
 
+func logWarn(msg string, attrs ...any) {
+	// Add component as first attribute
+	allAttrs := append([]any{"component", "Main"}, attrs...)
+	slog.Warn(msg, allAttrs...)
+
+	// Only add to web UI if this level is enabled
+	if appState != nil && slog.Default().Enabled(nil, slog.LevelWarn) {
+		displayMsg := formatLogMessage("WARN", msg, allAttrs...)
+		appState.AddLog("WARN", "Main", displayMsg)
+	}
+}
+
 func logError(msg string, attrs ...any) {
 	// Add component as first attribute
 	allAttrs := append([]any{"component", "Main"}, attrs...)
@@ -290,7 +477,7 @@ func formatLogMessage(level, msg string, attrs ...any) string {
 		}
 	}
 
-	return strings.Join(jsonParts, ",") + "}"
+	return redact.String(strings.Join(jsonParts, ",") + "}")
 }
 
 // parseLogLevel converts a string log level to slog.Level
@@ -2,4 +2,8 @@ module omni-cd
 
 go 1.23
 
-require github.com/gorilla/websocket v1.5.3
\ No newline at end of file
+require (
+	github.com/Masterminds/semver/v3 v3.3.1
+	github.com/gorilla/websocket v1.5.3
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -5,6 +5,10 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/Masterminds/semver/v3"
+
+	"omni-cd/internal/syncwindow"
 )
 
 // Config holds all configuration for omni-cd.
@@ -18,6 +22,19 @@ type Config struct {
 	GitBranch string
 	GitToken  string
 
+	// Ref selection: follow GitBranch HEAD ("branch") or the highest tag
+	// matching GitTagSemver ("tag")
+	GitRefMode   string
+	GitTagSemver string
+
+	// SSH authentication for git@ / ssh:// remotes
+	GitSSHKey        string // Private deploy key (from GIT_SSH_KEY or GIT_SSH_KEY_FILE)
+	GitSSHKnownHosts string // known_hosts lines the remote host key is pinned to
+
+	// Commit signature verification
+	GitVerifySignatures bool   // Refuse to reconcile commits without a trusted signature
+	GitTrustedKeysFile  string // PGP public keys and/or SSH allowed_signers lines
+
 	// Sync behaviour
 	RefreshInterval time.Duration // How often to check for new git commits (refresh mode)
 	SyncInterval    time.Duration // How often to force a full reconcile (sync mode)
@@ -25,13 +42,40 @@ type Config struct {
 	// Resource paths within the Git repo
 	MCPath       string
 	ClustersPath string
+	PatchesPath  string // Config patches; empty leaves them unmanaged
+	MachinesPath string // Machine labels and request sets; empty leaves them unmanaged
+	AccessPath   string // Users, service accounts and access policy; empty leaves them unmanaged
 
 	// Feature toggles
 	ClustersEnabled bool
 
+	// Prune guard for clusters and machine classes
+	PruneMaxDeletions    int  // Deletions per kind and reconcile without confirmation; 0 is unlimited
+	PruneMaxPercent      int  // Share of a kind deleted per reconcile without confirmation; 0 is unlimited
+	PruneRequireApproval bool // Every deletion waits for confirmation
+
+	// Sync windows restricting when clusters are synced and deleted
+	SyncWindows         syncwindow.Windows // Global windows; none allows changes at any time
+	SyncWindowsLocation *time.Location     // Time zone the window schedules are evaluated in
+
+	// Progressive rollout of cluster changes in waves
+	RolloutHealthTimeout     time.Duration // How long a wave may take to become healthy
+	RolloutWaveFromDirectory bool          // Clusters without a wave label take it from their directory name
+
+	// Concurrency and timeouts of Omni calls
+	MaxParallelSyncs   int           // Clusters synced, deleted or exported at the same time
+	ClusterSyncTimeout time.Duration // How long syncing or deleting a single cluster may take
+	OmniTimeout        time.Duration // How long any other single omnictl call may take
+
+	// How long shutdown waits for a running reconcile before aborting it
+	ShutdownGracePeriod time.Duration
+
 	// Web UI
 	WebPort string
 
+	// Shared secret for Git provider push webhooks (empty disables them)
+	WebhookSecret string
+
 	// Logging
 	LogLevel string // DEBUG, INFO, WARN, ERROR
 }
@@ -54,23 +98,127 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("GIT_REPO is required")
 	}
 
+	sshKey := os.Getenv("GIT_SSH_KEY")
+	if sshKeyFile := os.Getenv("GIT_SSH_KEY_FILE"); sshKeyFile != "" {
+		if sshKey != "" {
+			return nil, fmt.Errorf("GIT_SSH_KEY and GIT_SSH_KEY_FILE are mutually exclusive")
+		}
+		data, err := os.ReadFile(sshKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GIT_SSH_KEY_FILE: %w", err)
+		}
+		sshKey = string(data)
+	}
+
+	refMode := getEnv("GIT_REF_MODE", "branch")
+	if refMode != "branch" && refMode != "tag" {
+		return nil, fmt.Errorf("GIT_REF_MODE must be \"branch\" or \"tag\", got %q", refMode)
+	}
+	tagSemver := os.Getenv("GIT_TAG_SEMVER")
+	if tagSemver != "" {
+		if _, err := semver.NewConstraint(tagSemver); err != nil {
+			return nil, fmt.Errorf("invalid GIT_TAG_SEMVER: %w", err)
+		}
+	}
+
+	// A typo must not silently turn verification off
+	verifySignatures, err := strconv.ParseBool(getEnv("GIT_VERIFY_SIGNATURES", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("GIT_VERIFY_SIGNATURES must be true or false, got %q", os.Getenv("GIT_VERIFY_SIGNATURES"))
+	}
+	trustedKeysFile := os.Getenv("GIT_TRUSTED_KEYS_FILE")
+	if verifySignatures && trustedKeysFile == "" {
+		return nil, fmt.Errorf("GIT_TRUSTED_KEYS_FILE is required when GIT_VERIFY_SIGNATURES is enabled")
+	}
+
 	refreshSec, _ := strconv.Atoi(getEnv("REFRESH_INTERVAL", "300"))
 	syncSec, _ := strconv.Atoi(getEnv("SYNC_INTERVAL", "3600"))
 	clustersEnabled, _ := strconv.ParseBool(getEnv("CLUSTERS_ENABLED", "true"))
 
+	// A typo in a safety limit must not silently disable it
+	pruneMaxDeletions, err := strconv.Atoi(getEnv("PRUNE_MAX_DELETIONS", "0"))
+	if err != nil || pruneMaxDeletions < 0 {
+		return nil, fmt.Errorf("PRUNE_MAX_DELETIONS must be a non-negative number, got %q", os.Getenv("PRUNE_MAX_DELETIONS"))
+	}
+	pruneMaxPercent, err := strconv.Atoi(getEnv("PRUNE_MAX_PERCENT", "0"))
+	if err != nil || pruneMaxPercent < 0 || pruneMaxPercent > 100 {
+		return nil, fmt.Errorf("PRUNE_MAX_PERCENT must be between 0 and 100, got %q", os.Getenv("PRUNE_MAX_PERCENT"))
+	}
+	pruneRequireApproval, err := strconv.ParseBool(getEnv("PRUNE_REQUIRE_APPROVAL", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("PRUNE_REQUIRE_APPROVAL must be true or false, got %q", os.Getenv("PRUNE_REQUIRE_APPROVAL"))
+	}
+
+	syncWindows, err := syncwindow.Parse(os.Getenv("SYNC_WINDOWS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SYNC_WINDOWS: %w", err)
+	}
+	windowsLocation, err := time.LoadLocation(getEnv("SYNC_WINDOWS_TIMEZONE", "UTC"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SYNC_WINDOWS_TIMEZONE: %w", err)
+	}
+
+	rolloutTimeoutSec, err := strconv.Atoi(getEnv("ROLLOUT_HEALTH_TIMEOUT", "900"))
+	if err != nil || rolloutTimeoutSec <= 0 {
+		return nil, fmt.Errorf("ROLLOUT_HEALTH_TIMEOUT must be a positive number of seconds, got %q", os.Getenv("ROLLOUT_HEALTH_TIMEOUT"))
+	}
+	waveFromDirectory, err := strconv.ParseBool(getEnv("ROLLOUT_WAVE_FROM_DIRECTORY", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("ROLLOUT_WAVE_FROM_DIRECTORY must be true or false, got %q", os.Getenv("ROLLOUT_WAVE_FROM_DIRECTORY"))
+	}
+
+	maxParallel, err := strconv.Atoi(getEnv("MAX_PARALLEL_SYNCS", "4"))
+	if err != nil || maxParallel <= 0 {
+		return nil, fmt.Errorf("MAX_PARALLEL_SYNCS must be a positive number, got %q", os.Getenv("MAX_PARALLEL_SYNCS"))
+	}
+	clusterTimeoutSec, err := strconv.Atoi(getEnv("CLUSTER_SYNC_TIMEOUT", "600"))
+	if err != nil || clusterTimeoutSec <= 0 {
+		return nil, fmt.Errorf("CLUSTER_SYNC_TIMEOUT must be a positive number of seconds, got %q", os.Getenv("CLUSTER_SYNC_TIMEOUT"))
+	}
+	omniTimeoutSec, err := strconv.Atoi(getEnv("OMNI_TIMEOUT", "120"))
+	if err != nil || omniTimeoutSec <= 0 {
+		return nil, fmt.Errorf("OMNI_TIMEOUT must be a positive number of seconds, got %q", os.Getenv("OMNI_TIMEOUT"))
+	}
+
+	graceSec, err := strconv.Atoi(getEnv("SHUTDOWN_GRACE_PERIOD", "25"))
+	if err != nil || graceSec < 0 {
+		return nil, fmt.Errorf("SHUTDOWN_GRACE_PERIOD must be a non-negative number of seconds, got %q", os.Getenv("SHUTDOWN_GRACE_PERIOD"))
+	}
+
 	return &Config{
-		OmniEndpoint:          endpoint,
-		OmniServiceAccountKey: saKey,
-		GitRepo:               gitRepo,
-		GitBranch:             getEnv("GIT_BRANCH", "main"),
-		GitToken:              os.Getenv("GIT_TOKEN"),
-		RefreshInterval:       time.Duration(refreshSec) * time.Second,
-		SyncInterval:          time.Duration(syncSec) * time.Second,
-		MCPath:                getEnv("MC_PATH", "machine-classes"),
-		ClustersPath:          getEnv("CLUSTERS_PATH", "clusters"),
-		ClustersEnabled:       clustersEnabled,
-		WebPort:               getEnv("WEB_PORT", "8080"),
-		LogLevel:              getEnv("LOG_LEVEL", "INFO"),
+		OmniEndpoint:             endpoint,
+		OmniServiceAccountKey:    saKey,
+		GitRepo:                  gitRepo,
+		GitBranch:                getEnv("GIT_BRANCH", "main"),
+		GitToken:                 os.Getenv("GIT_TOKEN"),
+		GitRefMode:               refMode,
+		GitTagSemver:             tagSemver,
+		GitSSHKey:                sshKey,
+		GitSSHKnownHosts:         os.Getenv("GIT_SSH_KNOWN_HOSTS"),
+		GitVerifySignatures:      verifySignatures,
+		GitTrustedKeysFile:       trustedKeysFile,
+		RefreshInterval:          time.Duration(refreshSec) * time.Second,
+		SyncInterval:             time.Duration(syncSec) * time.Second,
+		MCPath:                   getEnv("MC_PATH", "machine-classes"),
+		ClustersPath:             getEnv("CLUSTERS_PATH", "clusters"),
+		PatchesPath:              os.Getenv("PATCHES_PATH"),
+		MachinesPath:             os.Getenv("MACHINES_PATH"),
+		AccessPath:               os.Getenv("ACCESS_PATH"),
+		ClustersEnabled:          clustersEnabled,
+		PruneMaxDeletions:        pruneMaxDeletions,
+		PruneMaxPercent:          pruneMaxPercent,
+		PruneRequireApproval:     pruneRequireApproval,
+		SyncWindows:              syncWindows,
+		SyncWindowsLocation:      windowsLocation,
+		RolloutHealthTimeout:     time.Duration(rolloutTimeoutSec) * time.Second,
+		RolloutWaveFromDirectory: waveFromDirectory,
+		MaxParallelSyncs:         maxParallel,
+		ClusterSyncTimeout:       time.Duration(clusterTimeoutSec) * time.Second,
+		OmniTimeout:              time.Duration(omniTimeoutSec) * time.Second,
+		ShutdownGracePeriod:      time.Duration(graceSec) * time.Second,
+		WebPort:                  getEnv("WEB_PORT", "8080"),
+		WebhookSecret:            os.Getenv("WEBHOOK_SECRET"),
+		LogLevel:                 getEnv("LOG_LEVEL", "INFO"),
 	}, nil
 }
 
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadVerifySignatures(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr string
+	}{
+		{value: "", want: false},
+		{value: "true", want: true},
+		{value: "false", want: false},
+		{value: "ture", wantErr: "GIT_VERIFY_SIGNATURES must be true or false"},
+		{value: "yes", wantErr: "GIT_VERIFY_SIGNATURES must be true or false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("OMNI_ENDPOINT", "https://omni.example.com")
+			t.Setenv("OMNI_SERVICE_ACCOUNT_KEY", "key")
+			t.Setenv("GIT_REPO", "https://git.example.com/infra.git")
+			t.Setenv("GIT_TRUSTED_KEYS_FILE", "/keys")
+			t.Setenv("GIT_VERIFY_SIGNATURES", tt.value)
+
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.GitVerifySignatures != tt.want {
+				t.Errorf("GitVerifySignatures = %v, want %v", cfg.GitVerifySignatures, tt.want)
+			}
+		})
+	}
+}
@@ -1,25 +1,47 @@
 package git
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"omni-cd/internal/config"
+	"omni-cd/internal/redact"
 	"omni-cd/internal/state"
+
+	"github.com/Masterminds/semver/v3"
 )
 
-const workDir = "/tmp/repo"
+const (
+	workDir   = "/tmp/repo"
+	sshDir    = "/tmp/omni-cd-ssh"
+	verifyDir = "/tmp/omni-cd-verify"
+)
 
 // Client handles Git operations for omni-cd.
 type Client struct {
 	cfg     *config.Config
 	state   *state.AppState
 	lastSHA string
+	tag     string // Tag currently checked out (tag mode only)
+
+	// Files changed between the previous and current SHA of the last Sync.
+	// changedKnown is false when the diff could not be computed.
+	changedFiles []string
+	changedKnown bool
+
+	sshCommand string // GIT_SSH_COMMAND for SSH remotes, set up on first sync
+
+	// Commit signature verification, set up on first sync
+	gnupgHome          string
+	allowedSignersFile string
 }
 
 // New creates a new Git client with shared state.
@@ -32,30 +54,56 @@ func (c *Client) RepoDir() string {
 	return workDir
 }
 
-// Sync performs a fresh shallow clone and returns true if the HEAD SHA
-// has changed since the last sync. A fresh clone each cycle avoids
-// issues with shallow fetch/reset on some Git versions.
-func (c *Client) Sync() (bool, error) {
-	repoURL := c.cfg.GitRepo
+// Sync brings the local checkout up to date with the remote branch and
+// returns true if the HEAD SHA has changed since the last sync. An existing
+// checkout is updated in place with fetch + hard reset; a fresh clone is only
+// made when there is no usable checkout yet. A failed update leaves the last
+// good tree in place. Fetches and clones are aborted once ctx is done.
+func (c *Client) Sync(ctx context.Context) (bool, error) {
+	if c.cfg.GitSSHKey != "" && c.sshCommand == "" {
+		if err := c.setupSSH(); err != nil {
+			return false, fmt.Errorf("failed to set up SSH key: %w", err)
+		}
+	}
 
-	// Inject token for private repos
-	if c.cfg.GitToken != "" {
-		repoURL = strings.Replace(repoURL, "https://", "https://token:"+c.cfg.GitToken+"@", 1)
+	// Resolve what to check out: the branch HEAD, or the highest tag that
+	// satisfies the semver constraint
+	ref := c.cfg.GitBranch
+	if c.cfg.GitRefMode == "tag" {
+		tag, err := c.latestTag(ctx)
+		if err != nil {
+			return false, err
+		}
+		if tag != c.tag {
+			c.logInfo("Release tag selected", "tag", tag, "constraint", c.cfg.GitTagSemver)
+		}
+		c.tag = tag
+		ref = tag
 	}
 
-	// Remove old clone and start fresh
-	os.RemoveAll(workDir)
+	if c.hasValidCheckout() {
+		if err := c.update(ctx, ref); err != nil {
+			// Network and auth errors leave the checkout as it was. Only a
+			// checkout that git can no longer operate on is replaced.
+			if ctx.Err() != nil || c.hasValidCheckout() {
+				return false, err
+			}
+			c.logWarn("Local checkout is corrupt, re-cloning", "error", err)
+			if err := c.clone(ctx, ref); err != nil {
+				return false, err
+			}
+		}
+	} else {
+		if err := c.clone(ctx, ref); err != nil {
+			return false, err
+		}
+	}
 
-	// Shallow clone the target branch
-	cmd := exec.Command("git", "clone",
-		"--branch", c.cfg.GitBranch,
-		"--single-branch",
-		"--depth", "1",
-		repoURL, workDir,
-		"--quiet",
-	)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return false, fmt.Errorf("git clone failed: %w\n%s", err, string(out))
+	// A pinned commit overrides the tracked ref until it is unpinned
+	if pin := c.state.GetPinnedSHA(); pin != "" {
+		if err := c.checkoutPin(ctx, ref, pin); err != nil {
+			return false, err
+		}
 	}
 
 	// Get the current HEAD SHA
@@ -64,39 +112,470 @@ func (c *Client) Sync() (bool, error) {
 		return false, fmt.Errorf("failed to get HEAD: %w", err)
 	}
 
+	signature := ""
+	if c.cfg.GitVerifySignatures {
+		if err := c.verifyCommit(current); err != nil {
+			c.rejectCommit(current, err)
+			return false, fmt.Errorf("commit %s failed signature verification: %w", short(current), err)
+		}
+		signature = "verified"
+	}
+
 	msg := c.commitMessage()
+	author, commitTime := c.commitAuthor()
 
 	// Update shared state with git info
 	c.state.UpdateGit(state.GitInfo{
 		SHA:           current,
 		ShortSHA:      short(current),
 		CommitMessage: msg,
-		Branch:        c.cfg.GitBranch,
+		Author:        author,
+		CommitTime:    commitTime,
+		Branch:        c.branch(),
+		Tag:           c.tag,
 		Repo:          c.cfg.GitRepo,
 		LastSync:      time.Now().UTC(),
+		Signature:     signature,
 	})
 
 	previous := c.lastSHA
 	c.lastSHA = current
 
+	c.changedFiles, c.changedKnown = nil, false
+	if previous != "" && current != previous {
+		files, err := c.diffFiles(previous, current)
+		if err != nil {
+			c.logWarn("Could not determine changed files, treating everything as changed", "from", short(previous), "to", short(current), "error", err)
+		} else {
+			c.changedFiles, c.changedKnown = files, true
+			c.logDebug("Changed files", "from", short(previous), "to", short(current), "count", len(files))
+		}
+	}
+
 	// First run — always treat as changed
 	if previous == "" {
-		c.logInfo("Cloned repository", "repo", c.cfg.GitRepo, "branch", c.cfg.GitBranch, "sha", short(current))
+		c.logInfo("Repository ready", "repo", c.cfg.GitRepo, "ref", ref, "sha", short(current))
 		return true, nil
 	}
 
 	// SHA changed — new commit detected
 	if current != previous {
-		c.logInfo("New commit detected", "sha", short(current), "message", msg)
+		c.logInfo("New commit detected", "ref", ref, "sha", short(current), "message", msg)
 		return true, nil
 	}
 
 	return false, nil
 }
 
+// hasValidCheckout reports whether workDir holds a usable checkout of the
+// configured repository. A missing directory, a broken .git or a remote that
+// points elsewhere (e.g. GIT_REPO was changed) all count as invalid.
+func (c *Client) hasValidCheckout() bool {
+	if _, err := os.Stat(filepath.Join(workDir, ".git")); err != nil {
+		return false
+	}
+	if _, err := c.headSHA(); err != nil {
+		return false
+	}
+	out, err := c.gitCommand("-C", workDir, "remote", "get-url", "origin").Output()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(out)) == c.cfg.GitRepo
+}
+
+// update fetches the given branch or tag and hard-resets the working tree to
+// it. The fetch is incremental: only objects missing from the local clone are
+// transferred.
+func (c *Client) update(ctx context.Context, ref string) error {
+	refspec := ref
+	if c.cfg.GitRefMode == "tag" {
+		refspec = "refs/tags/" + ref
+	}
+	fetch := c.gitCommandContext(ctx, "-C", workDir, "fetch",
+		"--quiet",
+		"--no-tags",
+		"origin", refspec,
+	)
+	if out, err := fetch.CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch failed: %w\n%s", err, string(out))
+	}
+
+	reset := c.gitCommand("-C", workDir, "reset", "--hard", "--quiet", "FETCH_HEAD")
+	if out, err := reset.CombinedOutput(); err != nil {
+		return fmt.Errorf("git reset failed: %w\n%s", err, string(out))
+	}
+
+	// Drop untracked leftovers so the tree matches the commit exactly
+	clean := c.gitCommand("-C", workDir, "clean", "-fdx", "--quiet")
+	if out, err := clean.CombinedOutput(); err != nil {
+		return fmt.Errorf("git clean failed: %w\n%s", err, string(out))
+	}
+
+	c.logDebug("Fetched repository", "ref", ref)
+	return nil
+}
+
+// clone makes a fresh shallow clone of the given branch or tag. The clone is
+// written to a temporary directory first and only swapped into workDir once
+// it has succeeded, so a failed clone never removes the previous tree.
+func (c *Client) clone(ctx context.Context, ref string) error {
+	tmpDir := workDir + ".tmp"
+	os.RemoveAll(tmpDir)
+
+	// Shallow clone the target branch or tag
+	cmd := c.gitCommandContext(ctx, "clone",
+		"--branch", ref,
+		"--single-branch",
+		"--depth", "1",
+		c.cfg.GitRepo, tmpDir,
+		"--quiet",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("git clone failed: %w\n%s", err, string(out))
+	}
+
+	if err := os.RemoveAll(workDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("failed to remove old checkout: %w", err)
+	}
+	if err := os.Rename(tmpDir, workDir); err != nil {
+		return fmt.Errorf("failed to move clone into place: %w", err)
+	}
+
+	c.logInfo("Cloned repository", "repo", c.cfg.GitRepo, "ref", ref)
+	return nil
+}
+
+// checkoutPin resets the working tree to the pinned commit. The shallow
+// clone may not contain it yet, so it is fetched directly first and, if the
+// server refuses that, the tracked ref's full history is fetched instead.
+func (c *Client) checkoutPin(ctx context.Context, ref, pin string) error {
+	sha, err := c.resolveCommit(pin)
+	if err != nil {
+		c.gitCommandContext(ctx, "-C", workDir, "fetch", "--quiet", "--no-tags", "origin", pin).Run()
+		sha, err = c.resolveCommit(pin)
+	}
+	if err != nil {
+		refspec := ref
+		if c.cfg.GitRefMode == "tag" {
+			refspec = "refs/tags/" + ref
+		}
+		c.logDebug("Pinned commit not in local clone, fetching full history", "pin", pin)
+		c.gitCommandContext(ctx, "-C", workDir, "fetch", "--quiet", "--no-tags", "--unshallow", "origin", refspec).Run()
+		sha, err = c.resolveCommit(pin)
+	}
+	if err != nil {
+		return fmt.Errorf("pinned commit %s not found in repository", pin)
+	}
+
+	if out, err := c.gitCommand("-C", workDir, "reset", "--hard", "--quiet", sha).CombinedOutput(); err != nil {
+		return fmt.Errorf("git reset to pinned commit failed: %w\n%s", err, string(out))
+	}
+	if out, err := c.gitCommand("-C", workDir, "clean", "-fdx", "--quiet").CombinedOutput(); err != nil {
+		return fmt.Errorf("git clean failed: %w\n%s", err, string(out))
+	}
+
+	c.logDebug("Checked out pinned commit", "sha", short(sha))
+	return nil
+}
+
+// resolveCommit expands a (possibly abbreviated) SHA to a full commit SHA
+// that exists in the local clone.
+func (c *Client) resolveCommit(sha string) (string, error) {
+	out, err := c.gitCommand("-C", workDir, "rev-parse", "--verify", "--quiet", sha+"^{commit}").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// latestTag lists the remote's tags and returns the highest semver tag that
+// satisfies GIT_TAG_SEMVER. Tags that are not valid semver are ignored.
+func (c *Client) latestTag(ctx context.Context) (string, error) {
+	var constraint *semver.Constraints
+	if c.cfg.GitTagSemver != "" {
+		var err error
+		constraint, err = semver.NewConstraint(c.cfg.GitTagSemver)
+		if err != nil {
+			return "", fmt.Errorf("invalid GIT_TAG_SEMVER: %w", err)
+		}
+	}
+
+	out, err := c.gitCommandContext(ctx, "ls-remote", "--tags", "--refs", c.cfg.GitRepo).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote failed: %w\n%s", err, string(out))
+	}
+
+	var best *semver.Version
+	var bestTag string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		tag := strings.TrimPrefix(fields[1], "refs/tags/")
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if constraint != nil && !constraint.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best = v
+			bestTag = tag
+		}
+	}
+
+	if bestTag == "" {
+		if c.cfg.GitTagSemver != "" {
+			return "", fmt.Errorf("no tags match %q", c.cfg.GitTagSemver)
+		}
+		return "", fmt.Errorf("no semver tags found")
+	}
+	return bestTag, nil
+}
+
+// branch returns the tracked branch, or "" when following tags.
+func (c *Client) branch() string {
+	if c.cfg.GitRefMode == "tag" {
+		return ""
+	}
+	return c.cfg.GitBranch
+}
+
+// setupSSH writes the deploy key and pinned host keys to disk and builds the
+// GIT_SSH_COMMAND used for every git invocation. Host key checking is always
+// strict: hosts must be listed in GIT_SSH_KNOWN_HOSTS (or the system
+// known_hosts file when that is unset).
+func (c *Client) setupSSH() error {
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return err
+	}
+
+	// ssh refuses keys that are readable by others and requires a trailing newline
+	keyFile := filepath.Join(sshDir, "id")
+	key := strings.TrimSpace(c.cfg.GitSSHKey) + "\n"
+	if err := os.WriteFile(keyFile, []byte(key), 0600); err != nil {
+		return err
+	}
+
+	args := []string{
+		"ssh",
+		"-i", keyFile,
+		"-o", "IdentitiesOnly=yes",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+	}
+
+	if c.cfg.GitSSHKnownHosts != "" {
+		knownHostsFile := filepath.Join(sshDir, "known_hosts")
+		knownHosts := strings.TrimSpace(c.cfg.GitSSHKnownHosts) + "\n"
+		if err := os.WriteFile(knownHostsFile, []byte(knownHosts), 0600); err != nil {
+			return err
+		}
+		args = append(args, "-o", "UserKnownHostsFile="+knownHostsFile)
+	}
+
+	c.sshCommand = strings.Join(args, " ")
+	c.logDebug("SSH authentication configured", "known_hosts_pinned", c.cfg.GitSSHKnownHosts != "")
+	return nil
+}
+
+// setupVerification prepares an isolated GnuPG home and an SSH allowed
+// signers file from GIT_TRUSTED_KEYS_FILE. The file may contain ASCII-armored
+// PGP public key blocks, allowed_signers lines for SSH signing keys, or both.
+// Only keys from this file are trusted; the system keyring is never used.
+func (c *Client) setupVerification() error {
+	data, err := os.ReadFile(c.cfg.GitTrustedKeysFile)
+	if err != nil {
+		return err
+	}
+
+	os.RemoveAll(verifyDir)
+	gnupgHome := filepath.Join(verifyDir, "gnupg")
+	if err := os.MkdirAll(gnupgHome, 0700); err != nil {
+		return err
+	}
+
+	// Split armored PGP blocks from allowed_signers lines
+	var pgpKeys, sshSigners []string
+	var block []string
+	inBlock := false
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "-----BEGIN PGP PUBLIC KEY BLOCK-----"):
+			inBlock = true
+			block = []string{line}
+		case inBlock:
+			block = append(block, line)
+			if strings.HasPrefix(trimmed, "-----END PGP PUBLIC KEY BLOCK-----") {
+				pgpKeys = append(pgpKeys, strings.Join(block, "\n"))
+				inBlock = false
+			}
+		case trimmed != "" && !strings.HasPrefix(trimmed, "#"):
+			sshSigners = append(sshSigners, trimmed)
+		}
+	}
+	if len(pgpKeys) == 0 && len(sshSigners) == 0 {
+		return fmt.Errorf("no trusted keys found in %s", c.cfg.GitTrustedKeysFile)
+	}
+
+	// Keys in the isolated keyring are trusted by definition
+	if err := os.WriteFile(filepath.Join(gnupgHome, "gpg.conf"), []byte("trust-model always\n"), 0600); err != nil {
+		return err
+	}
+	for _, key := range pgpKeys {
+		cmd := exec.Command("gpg", "--batch", "--quiet", "--import")
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+gnupgHome)
+		cmd.Stdin = strings.NewReader(key + "\n")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("gpg import failed: %w\n%s", err, string(out))
+		}
+	}
+
+	// An empty allowed signers file makes every SSH signature untrusted
+	signersFile := filepath.Join(verifyDir, "allowed_signers")
+	signers := strings.Join(sshSigners, "\n")
+	if signers != "" {
+		signers += "\n"
+	}
+	if err := os.WriteFile(signersFile, []byte(signers), 0600); err != nil {
+		return err
+	}
+
+	c.gnupgHome = gnupgHome
+	c.allowedSignersFile = signersFile
+	c.logInfo("Commit signature verification enabled", "pgp_keys", len(pgpKeys), "ssh_signers", len(sshSigners))
+	return nil
+}
+
+// verifyCommit checks the GPG or SSH signature of the given commit against
+// the trusted keys.
+func (c *Client) verifyCommit(sha string) error {
+	if c.gnupgHome == "" {
+		if err := c.setupVerification(); err != nil {
+			return fmt.Errorf("failed to load trusted keys: %w", err)
+		}
+	}
+	out, err := c.gitCommand("-C", workDir, "verify-commit", sha).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = "commit is not signed"
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	c.logDebug("Commit signature verified", "sha", short(sha))
+	return nil
+}
+
+// rejectCommit handles a commit that failed signature verification. The
+// working tree is reset to the last verified commit so that it stays in
+// effect, and the rejected commit is recorded in the shared git state.
+func (c *Client) rejectCommit(sha string, verifyErr error) {
+	c.logError("Untrusted commit, refusing to reconcile", "sha", short(sha), "error", verifyErr)
+
+	info := state.GitInfo{
+		Branch:         c.branch(),
+		Tag:            c.tag,
+		Repo:           c.cfg.GitRepo,
+		LastSync:       time.Now().UTC(),
+		Signature:      "untrusted",
+		UntrustedSHA:   sha,
+		SignatureError: verifyErr.Error(),
+	}
+
+	if c.lastSHA != "" {
+		if out, err := c.gitCommand("-C", workDir, "reset", "--hard", "--quiet", c.lastSHA).CombinedOutput(); err != nil {
+			c.logError("Failed to restore last verified commit", "sha", short(c.lastSHA), "error", strings.TrimSpace(string(out)))
+		}
+		info.SHA = c.lastSHA
+		info.ShortSHA = short(c.lastSHA)
+		info.CommitMessage = c.commitMessage()
+		info.Author, info.CommitTime = c.commitAuthor()
+	}
+
+	c.state.UpdateGit(info)
+}
+
+// gitCommand builds a git command with the authentication settings applied.
+// Credentials are passed through the environment rather than the remote URL,
+// so they never show up in git's output, error messages or .git/config.
+func (c *Client) gitCommand(args ...string) *exec.Cmd {
+	return c.gitCommandContext(context.Background(), args...)
+}
+
+// gitCommandContext is gitCommand for commands that talk to the remote and
+// are killed once ctx is done.
+func (c *Client) gitCommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	env := os.Environ()
+	if c.sshCommand != "" {
+		env = append(env, "GIT_SSH_COMMAND="+c.sshCommand)
+	}
+	// Extra git config is passed as GIT_CONFIG_KEY_n / GIT_CONFIG_VALUE_n pairs
+	var gitConfig [][2]string
+	if c.cfg.GitToken != "" && strings.HasPrefix(c.cfg.GitRepo, "https://") {
+		// Equivalent to https://token:<GIT_TOKEN>@host/... but sent as a header
+		gitConfig = append(gitConfig, [2]string{"http.extraHeader", "Authorization: Basic " + BasicAuth(c.cfg.GitToken)})
+	}
+	if c.gnupgHome != "" {
+		env = append(env, "GNUPGHOME="+c.gnupgHome)
+		gitConfig = append(gitConfig, [2]string{"gpg.ssh.allowedSignersFile", c.allowedSignersFile})
+	}
+	if len(gitConfig) > 0 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(gitConfig)))
+		for i, kv := range gitConfig {
+			env = append(env,
+				fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]),
+				fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]),
+			)
+		}
+	}
+	// Never fall back to an interactive username/password prompt
+	env = append(env, "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = env
+	return cmd
+}
+
+// BasicAuth returns the encoded credentials of the Authorization header git
+// sends for token, so that they can be redacted like the token itself.
+func BasicAuth(token string) string {
+	return base64.StdEncoding.EncodeToString([]byte("token:" + token))
+}
+
+// ChangedFiles returns the repo-relative paths that changed between the
+// previous and current commit of the last Sync. The second return value is
+// false when the change set is unknown (first sync, or the previous commit is
+// no longer in the local clone), in which case callers should assume that
+// everything changed.
+func (c *Client) ChangedFiles() ([]string, bool) {
+	return c.changedFiles, c.changedKnown
+}
+
+// diffFiles lists the files that differ between two commits. Renames are
+// reported as a delete plus an add so both paths are included.
+func (c *Client) diffFiles(from, to string) ([]string, error) {
+	out, err := c.gitCommand("-C", workDir, "diff", "--name-only", "--no-renames", from, to).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
 // headSHA returns the current HEAD SHA of the cloned repo.
 func (c *Client) headSHA() (string, error) {
-	out, err := exec.Command("git", "-C", workDir, "rev-parse", "HEAD").Output()
+	out, err := c.gitCommand("-C", workDir, "rev-parse", "HEAD").Output()
 	if err != nil {
 		return "", err
 	}
@@ -105,13 +584,24 @@ func (c *Client) headSHA() (string, error) {
 
 // commitMessage returns the commit message of HEAD.
 func (c *Client) commitMessage() string {
-	out, err := exec.Command("git", "-C", workDir, "log", "-1", "--format=%s").Output()
+	out, err := c.gitCommand("-C", workDir, "log", "-1", "--format=%s").Output()
 	if err != nil {
 		return "(unknown)"
 	}
 	return strings.TrimSpace(string(out))
 }
 
+// commitAuthor returns the author name and commit time of HEAD.
+func (c *Client) commitAuthor() (string, time.Time) {
+	out, err := c.gitCommand("-C", workDir, "log", "-1", "--format=%an%n%cI").Output()
+	if err != nil {
+		return "(unknown)", time.Time{}
+	}
+	author, date, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	when, _ := time.Parse(time.RFC3339, date)
+	return author, when.UTC()
+}
+
 // short returns the first 8 characters of a SHA.
 func short(sha string) string {
 	if len(sha) > 8 {
@@ -144,6 +634,30 @@ func (c *Client) logInfo(msg string, attrs ...any) {
 	}
 }
 
+func (c *Client) logWarn(msg string, attrs ...any) {
+	// Add component as first attribute
+	allAttrs := append([]any{"component", "Git"}, attrs...)
+	slog.Warn(msg, allAttrs...)
+
+	// Only add to web UI if this level is enabled
+	if c.state != nil && slog.Default().Enabled(nil, slog.LevelWarn) {
+		displayMsg := formatLogMessage("WARN", msg, allAttrs...)
+		c.state.AddLog("WARN", "Git", displayMsg)
+	}
+}
+
+func (c *Client) logError(msg string, attrs ...any) {
+	// Add component as first attribute
+	allAttrs := append([]any{"component", "Git"}, attrs...)
+	slog.Error(msg, allAttrs...)
+
+	// Only add to web UI if this level is enabled
+	if c.state != nil && slog.Default().Enabled(nil, slog.LevelError) {
+		displayMsg := formatLogMessage("ERROR", msg, allAttrs...)
+		c.state.AddLog("ERROR", "Git", displayMsg)
+	}
+}
+
 // formatLogMessage formats a message with key-value pairs as JSON for display
 func formatLogMessage(level, msg string, attrs ...any) string {
 	// Build a struct to ensure consistent field order
@@ -176,5 +690,5 @@ func formatLogMessage(level, msg string, attrs ...any) string {
 		}
 	}
 
-	return strings.Join(jsonParts, ",") + "}"
+	return redact.String(strings.Join(jsonParts, ",") + "}")
 }
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AccessPolicyType is the Omni resource type of the access policy. Omni has
+// a single access policy with the ID AccessPolicyID.
+const (
+	AccessPolicyType = "AccessPolicies.omni.sidero.dev"
+	AccessPolicyID   = "access-policy"
+)
+
+// Roles lists the Omni roles that can be assigned to users and service
+// accounts, from least to most privileged. InfraProvider is only valid for
+// service accounts.
+var Roles = []string{"None", "Reader", "Operator", "Admin", "InfraProvider"}
+
+// User is a "kind: User" document: a human identity and its role.
+type User struct {
+	Email string `yaml:"email"`
+	Role  string `yaml:"role"`
+}
+
+// ServiceAccount is a "kind: ServiceAccount" document. Its identity in Omni
+// is the name followed by a service account domain.
+type ServiceAccount struct {
+	Name string `yaml:"name"`
+	Role string `yaml:"role"`
+}
+
+// Access is the parsed content of the access directory.
+type Access struct {
+	Users           []User
+	ServiceAccounts []ServiceAccount
+	// AccessPolicy is the raw AccessPolicies.omni.sidero.dev document, or ""
+	// when Git does not define one.
+	AccessPolicy string
+}
+
+// accessDoc is used to tell the document kinds apart.
+type accessDoc struct {
+	Kind     string   `yaml:"kind"`
+	Metadata Metadata `yaml:"metadata"`
+}
+
+// ParseAccess parses multi-document YAML holding User and ServiceAccount
+// documents and at most one AccessPolicy resource. Roles are validated;
+// documents of any other kind are rejected so that typos do not go unnoticed.
+func ParseAccess(content string) (*Access, error) {
+	access := &Access{}
+	for i, doc := range SplitDocuments(content) {
+		var head accessDoc
+		if err := yaml.Unmarshal([]byte(doc), &head); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		switch {
+		case head.Kind == "User":
+			var u User
+			if err := yaml.Unmarshal([]byte(doc), &u); err != nil {
+				return nil, fmt.Errorf("document %d: %w", i+1, err)
+			}
+			if u.Email == "" {
+				return nil, fmt.Errorf("document %d: user has no email", i+1)
+			}
+			if err := validateRole(u.Role, false); err != nil {
+				return nil, fmt.Errorf("user %s: %w", u.Email, err)
+			}
+			access.Users = append(access.Users, u)
+		case head.Kind == "ServiceAccount":
+			var sa ServiceAccount
+			if err := yaml.Unmarshal([]byte(doc), &sa); err != nil {
+				return nil, fmt.Errorf("document %d: %w", i+1, err)
+			}
+			if sa.Name == "" || strings.Contains(sa.Name, "@") {
+				return nil, fmt.Errorf("document %d: service account needs a name without a domain", i+1)
+			}
+			if err := validateRole(sa.Role, true); err != nil {
+				return nil, fmt.Errorf("service account %s: %w", sa.Name, err)
+			}
+			access.ServiceAccounts = append(access.ServiceAccounts, sa)
+		case head.Metadata.Type == AccessPolicyType:
+			if access.AccessPolicy != "" {
+				return nil, fmt.Errorf("document %d: more than one access policy", i+1)
+			}
+			if head.Metadata.ID != AccessPolicyID {
+				return nil, fmt.Errorf("document %d: access policy id must be %q", i+1, AccessPolicyID)
+			}
+			access.AccessPolicy = doc
+		default:
+			return nil, fmt.Errorf("document %d: expected kind User or ServiceAccount, or an access policy", i+1)
+		}
+	}
+	return access, nil
+}
+
+// Merge adds the definitions of other to a.
+func (a *Access) Merge(other *Access) error {
+	if other.AccessPolicy != "" {
+		if a.AccessPolicy != "" {
+			return fmt.Errorf("more than one access policy")
+		}
+		a.AccessPolicy = other.AccessPolicy
+	}
+	a.Users = append(a.Users, other.Users...)
+	a.ServiceAccounts = append(a.ServiceAccounts, other.ServiceAccounts...)
+	return nil
+}
+
+// validateRole checks role against the roles Omni knows.
+func validateRole(role string, serviceAccount bool) error {
+	for _, r := range Roles {
+		if role == r && (serviceAccount || r != "InfraProvider") {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid role %q", role)
+}
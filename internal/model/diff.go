@@ -0,0 +1,122 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serverManagedMetadata lists metadata fields Omni sets itself. They never
+// appear in Git and are ignored when comparing resources.
+var serverManagedMetadata = map[string]bool{
+	"version":    true,
+	"created":    true,
+	"updated":    true,
+	"phase":      true,
+	"owner":      true,
+	"finalizers": true,
+	// Identity, not state: a resource in Git may omit the default namespace
+	"namespace": true,
+	"type":      true,
+	"id":        true,
+}
+
+// Diff compares a desired resource document from Git with the live document
+// from Omni and returns a field-level diff, or "" when they match. Fields
+// only in live are prefixed with "-", fields only in desired with "+", and
+// changed fields get both. Server-managed metadata, null values and empty
+// lists or maps are ignored. An empty live document means the resource does
+// not exist yet, so every desired field is reported as added.
+func Diff(desired, live string) (string, error) {
+	want, err := flattenDocument(desired)
+	if err != nil {
+		return "", fmt.Errorf("desired: %w", err)
+	}
+	have, err := flattenDocument(live)
+	if err != nil {
+		return "", fmt.Errorf("live: %w", err)
+	}
+
+	paths := make(map[string]bool, len(want)+len(have))
+	for p := range want {
+		paths[p] = true
+	}
+	for p := range have {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var lines []string
+	for _, p := range sorted {
+		w, inWant := want[p]
+		h, inHave := have[p]
+		if inWant && inHave && w == h {
+			continue
+		}
+		if inHave {
+			lines = append(lines, "- "+p+": "+h)
+		}
+		if inWant {
+			lines = append(lines, "+ "+p+": "+w)
+		}
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return "--- live\n+++ git\n" + strings.Join(lines, "\n"), nil
+}
+
+// flattenDocument decodes a single YAML document and returns a map of
+// dotted field path -> scalar value for every field that takes part in a
+// comparison.
+func flattenDocument(doc string) (map[string]string, error) {
+	out := make(map[string]string)
+	if strings.TrimSpace(doc) == "" {
+		return out, nil
+	}
+	var root map[string]any
+	if err := yaml.Unmarshal([]byte(doc), &root); err != nil {
+		return nil, err
+	}
+	if meta, ok := root["metadata"].(map[string]any); ok {
+		for k := range meta {
+			if serverManagedMetadata[k] {
+				delete(meta, k)
+			}
+		}
+	}
+	flatten("", root, out)
+	return out, nil
+}
+
+// flatten walks v and records every scalar under its path.
+func flatten(path string, v any, out map[string]string) {
+	switch val := v.(type) {
+	case nil:
+		// Absent and null are the same to Omni
+	case map[string]any:
+		for k, child := range val {
+			flatten(joinPath(path, k), child, out)
+		}
+	case []any:
+		for i, child := range val {
+			flatten(fmt.Sprintf("%s[%d]", path, i), child, out)
+		}
+	default:
+		out[path] = fmt.Sprint(val)
+	}
+}
+
+// joinPath appends key to a dotted path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,196 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Resource types of the machine-level resources omni-cd manages.
+const (
+	MachineLabelsType     = "MachineLabels.omni.sidero.dev"
+	MachineRequestSetType = "MachineRequestSets.omni.sidero.dev"
+)
+
+// MachineRequestSetSpec asks an infrastructure provider for a number of
+// machines.
+type MachineRequestSetSpec struct {
+	ProviderID   string `yaml:"providerid"`
+	MachineCount int    `yaml:"machinecount"`
+	TalosVersion string `yaml:"talosversion"`
+}
+
+// ParseMachineResources parses MachineLabels and MachineRequestSets
+// resources from multi-document YAML. Documents without a metadata.id are
+// skipped; resources of any other type are rejected.
+func ParseMachineResources(data []byte) ([]Resource, error) {
+	resources, err := ParseResources(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resources {
+		if r.Metadata.Type != MachineLabelsType && r.Metadata.Type != MachineRequestSetType {
+			return nil, fmt.Errorf("%s: expected type %s or %s, got %q", r.Metadata.ID, MachineLabelsType, MachineRequestSetType, r.Metadata.Type)
+		}
+	}
+	return resources, nil
+}
+
+// MachineResourceSummary describes a machine resource in a few words: the
+// labels a MachineLabels resource assigns ("rack=r1, zone=a") or what a
+// MachineRequestSet asks for ("3 machines from aws").
+func MachineResourceSummary(r *Resource) string {
+	switch r.Metadata.Type {
+	case MachineLabelsType:
+		pairs := make([]string, 0, len(r.Metadata.Labels))
+		for k, v := range r.Metadata.Labels {
+			if v == "" {
+				pairs = append(pairs, k)
+			} else {
+				pairs = append(pairs, k+"="+v)
+			}
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ", ")
+	case MachineRequestSetType:
+		var spec MachineRequestSetSpec
+		if err := r.DecodeSpec(&spec); err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%d machines from %s", spec.MachineCount, spec.ProviderID)
+	}
+	return ""
+}
+
+// ============================================================
+// Label Selectors
+// ============================================================
+
+// Matches reports whether a machine with the given labels is selected by
+// the machine class. Each matchlabels entry is a query of comma-separated
+// terms that must all hold; the class selects a machine when any query
+// matches. A class without queries selects nothing.
+func (mc *MachineClass) Matches(labels map[string]string) (bool, error) {
+	for _, query := range mc.Spec.MatchLabels {
+		ok, err := matchQuery(query, labels)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MatchSelector reports whether a resource with the given labels is
+// selected by a label selector, written like a matchlabels query.
+func MatchSelector(selector string, labels map[string]string) (bool, error) {
+	return matchQuery(selector, labels)
+}
+
+// ValidateSelector checks that every term of a label selector is well
+// formed, which MatchSelector only does up to the first term that fails.
+func ValidateSelector(selector string) error {
+	terms := splitTerms(selector)
+	if len(terms) == 0 {
+		return fmt.Errorf("empty label query")
+	}
+	for _, term := range terms {
+		if _, err := matchTerm(term, nil); err != nil {
+			return fmt.Errorf("label query %q: %w", selector, err)
+		}
+	}
+	return nil
+}
+
+// matchQuery evaluates a single label query such as
+// "omni.sidero.dev/arch = amd64, zone in (a, b), !gpu".
+func matchQuery(query string, labels map[string]string) (bool, error) {
+	terms := splitTerms(query)
+	if len(terms) == 0 {
+		return false, fmt.Errorf("empty label query")
+	}
+	for _, term := range terms {
+		ok, err := matchTerm(term, labels)
+		if err != nil {
+			return false, fmt.Errorf("label query %q: %w", query, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchTerm evaluates one term of a label query: "key", "!key",
+// "key = value", "key == value", "key != value", "key in (a, b)" or
+// "key notin (a, b)".
+func matchTerm(term string, labels map[string]string) (bool, error) {
+	if strings.HasPrefix(term, "!") && !strings.ContainsAny(term, "=( ") {
+		_, ok := labels[strings.TrimSpace(term[1:])]
+		return !ok, nil
+	}
+	for _, op := range []string{"!=", "==", "="} {
+		if key, value, found := strings.Cut(term, op); found {
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if key == "" {
+				return false, fmt.Errorf("term %q has no key", term)
+			}
+			got, ok := labels[key]
+			if op == "!=" {
+				return !ok || got != value, nil
+			}
+			return ok && got == value, nil
+		}
+	}
+	fields := strings.Fields(term)
+	if len(fields) == 1 {
+		_, ok := labels[fields[0]]
+		return ok, nil
+	}
+	if len(fields) >= 3 && (fields[1] == "in" || fields[1] == "notin") {
+		list := strings.TrimSpace(strings.Join(fields[2:], " "))
+		if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+			return false, fmt.Errorf("term %q: values must be in parentheses", term)
+		}
+		got, ok := labels[fields[0]]
+		in := false
+		for _, v := range strings.Split(list[1:len(list)-1], ",") {
+			if ok && strings.TrimSpace(v) == got {
+				in = true
+			}
+		}
+		if fields[1] == "in" {
+			return in, nil
+		}
+		return !in, nil
+	}
+	return false, fmt.Errorf("unsupported term %q", term)
+}
+
+// splitTerms splits a query at commas that are not inside parentheses.
+func splitTerms(query string) []string {
+	var terms []string
+	depth, start := 0, 0
+	add := func(end int) {
+		if t := strings.TrimSpace(query[start:end]); t != "" {
+			terms = append(terms, t)
+		}
+	}
+	for i, c := range query {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				add(i)
+				start = i + 1
+			}
+		}
+	}
+	add(len(query))
+	return terms
+}
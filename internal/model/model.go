@@ -0,0 +1,298 @@
+// Package model parses the YAML documents omni-cd reads from Git and from
+// omnictl: Omni resources (machine classes, cluster statuses, ...) and
+// cluster templates (Cluster, ControlPlane and Workers documents).
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================
+// Documents
+// ============================================================
+
+// SplitDocuments splits multi-document YAML into the raw text of each
+// document. Empty documents and documents holding only comments are dropped.
+// The text is returned unchanged so it can be shown to users as-is.
+func SplitDocuments(content string) []string {
+	var docs []string
+	var cur []string
+	flush := func() {
+		doc := strings.TrimSpace(strings.Join(cur, "\n"))
+		if doc != "" && !onlyComments(doc) {
+			docs = append(docs, doc)
+		}
+		cur = cur[:0]
+	}
+	for _, line := range strings.Split(content, "\n") {
+		if isDocumentStart(line) {
+			flush()
+			continue
+		}
+		cur = append(cur, line)
+	}
+	flush()
+	return docs
+}
+
+// isDocumentStart reports whether line is a "---" document marker. A marker
+// is always at column 0, so an indented "---" inside a block scalar is not one.
+func isDocumentStart(line string) bool {
+	line = strings.TrimRight(line, " \t\r")
+	return line == "---" || strings.HasPrefix(line, "--- ")
+}
+
+// onlyComments reports whether doc consists solely of comments and blank lines.
+func onlyComments(doc string) bool {
+	for _, line := range strings.Split(doc, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeAll decodes every document in data into a value of type T.
+// Empty documents are skipped.
+func decodeAll[T any](data []byte) ([]T, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var out []T
+	for i := 1; ; i++ {
+		var node yaml.Node
+		if err := dec.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				return out, nil
+			}
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if isEmpty(&node) {
+			continue
+		}
+		var v T
+		if err := node.Decode(&v); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		out = append(out, v)
+	}
+}
+
+// isEmpty reports whether a decoded document has no content (e.g. "---"
+// followed by nothing but comments).
+func isEmpty(node *yaml.Node) bool {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return true
+		}
+		node = node.Content[0]
+	}
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+// ============================================================
+// Omni Resources
+// ============================================================
+
+// Metadata holds the resource metadata fields omni-cd cares about.
+type Metadata struct {
+	Namespace   string            `yaml:"namespace"`
+	Type        string            `yaml:"type"`
+	ID          string            `yaml:"id"`
+	Owner       string            `yaml:"owner"` // Set on resources a controller created
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// Resource is a single Omni resource as printed by `omnictl get -o yaml` and
+// accepted by `omnictl apply`. The spec is kept undecoded; use DecodeSpec to
+// read it into a kind-specific struct.
+type Resource struct {
+	Metadata Metadata  `yaml:"metadata"`
+	Spec     yaml.Node `yaml:"spec"`
+}
+
+// DecodeSpec decodes the resource spec into v. An absent spec leaves v unchanged.
+func (r *Resource) DecodeSpec(v any) error {
+	if r.Spec.Kind == 0 {
+		return nil
+	}
+	return r.Spec.Decode(v)
+}
+
+// ParseResources parses multi-document YAML into resources. Documents
+// without a metadata.id are skipped.
+func ParseResources(data []byte) ([]Resource, error) {
+	docs, err := decodeAll[Resource](data)
+	if err != nil {
+		return nil, err
+	}
+	out := docs[:0]
+	for _, d := range docs {
+		if d.Metadata.ID != "" {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// ResourceIDs returns the metadata.id of every resource in data, in order.
+func ResourceIDs(data []byte) ([]string, error) {
+	resources, err := ParseResources(data)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(resources))
+	for i, r := range resources {
+		ids[i] = r.Metadata.ID
+	}
+	return ids, nil
+}
+
+// DocumentsByID returns metadata.id -> raw document text for every resource
+// in content. Documents without an id are skipped.
+func DocumentsByID(content string) (map[string]string, error) {
+	docs := make(map[string]string)
+	for _, doc := range SplitDocuments(content) {
+		ids, err := ResourceIDs([]byte(doc))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			docs[id] = doc
+		}
+	}
+	return docs, nil
+}
+
+// ============================================================
+// Machine Classes
+// ============================================================
+
+// MachineClass is an Omni MachineClasses.omni.sidero.dev resource.
+type MachineClass struct {
+	Metadata Metadata         `yaml:"metadata"`
+	Spec     MachineClassSpec `yaml:"spec"`
+}
+
+// MachineClassSpec selects machines either by label (manual provisioning)
+// or by asking an infrastructure provider for them (auto provisioning).
+type MachineClassSpec struct {
+	MatchLabels   []string       `yaml:"matchlabels"`
+	AutoProvision *AutoProvision `yaml:"autoprovision"`
+}
+
+// AutoProvision holds the infrastructure provider settings of a machine class.
+type AutoProvision struct {
+	ProviderID string `yaml:"providerid"`
+}
+
+// ProvisionType returns "auto" when the machine class is provisioned by an
+// infrastructure provider and "manual" otherwise.
+func (mc *MachineClass) ProvisionType() string {
+	if mc.Spec.AutoProvision != nil && mc.Spec.AutoProvision.ProviderID != "" {
+		return "auto"
+	}
+	return "manual"
+}
+
+// ParseMachineClasses parses every machine class in multi-document YAML.
+// Documents without a metadata.id are skipped.
+func ParseMachineClasses(data []byte) ([]MachineClass, error) {
+	docs, err := decodeAll[MachineClass](data)
+	if err != nil {
+		return nil, err
+	}
+	out := docs[:0]
+	for _, d := range docs {
+		if d.Metadata.ID != "" {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// ============================================================
+// Config Patches
+// ============================================================
+
+// ConfigPatchType is the Omni resource type of config patches.
+const ConfigPatchType = "ConfigPatches.omni.sidero.dev"
+
+// Labels that select what a config patch applies to.
+const (
+	LabelCluster        = "omni.sidero.dev/cluster"
+	LabelMachineSet     = "omni.sidero.dev/machine-set"
+	LabelClusterMachine = "omni.sidero.dev/cluster-machine"
+	LabelMachine        = "omni.sidero.dev/machine"
+)
+
+// AnnotationClusterTemplateManaged marks resources created by a cluster
+// template sync.
+const AnnotationClusterTemplateManaged = "omni.sidero.dev/managed-by-cluster-templates"
+
+// ConfigPatch is an Omni ConfigPatches.omni.sidero.dev resource.
+type ConfigPatch struct {
+	Metadata Metadata        `yaml:"metadata"`
+	Spec     ConfigPatchSpec `yaml:"spec"`
+}
+
+// ConfigPatchSpec holds the Talos machine config patch as a YAML string.
+type ConfigPatchSpec struct {
+	Data string `yaml:"data"`
+}
+
+// Cluster returns the cluster the patch belongs to, or "" when it targets
+// a machine outside any cluster.
+func (p *ConfigPatch) Cluster() string {
+	return p.Metadata.Labels[LabelCluster]
+}
+
+// Target describes what the patch applies to, most specific first:
+// "machine <id>", "machine set <id>" or "cluster <name>". It is "" when the
+// patch carries no target label.
+func (p *ConfigPatch) Target() string {
+	labels := p.Metadata.Labels
+	switch {
+	case labels[LabelClusterMachine] != "":
+		return "machine " + labels[LabelClusterMachine]
+	case labels[LabelMachine] != "":
+		return "machine " + labels[LabelMachine]
+	case labels[LabelMachineSet] != "":
+		return "machine set " + labels[LabelMachineSet]
+	case labels[LabelCluster] != "":
+		return "cluster " + labels[LabelCluster]
+	}
+	return ""
+}
+
+// ParseConfigPatches parses every config patch in multi-document YAML.
+// Documents without a metadata.id are skipped.
+func ParseConfigPatches(data []byte) ([]ConfigPatch, error) {
+	docs, err := decodeAll[ConfigPatch](data)
+	if err != nil {
+		return nil, err
+	}
+	out := docs[:0]
+	for _, d := range docs {
+		if d.Metadata.ID != "" {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// ============================================================
+// Cluster Status
+// ============================================================
+
+// ClusterStatusSpec holds the readiness fields of a ClusterStatuses.omni.sidero.dev resource.
+type ClusterStatusSpec struct {
+	Ready              bool `yaml:"ready"`
+	KubernetesAPIReady bool `yaml:"kubernetesapiready"`
+}
@@ -0,0 +1,682 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "single document",
+			content: "metadata:\n  id: a\n",
+			want:    []string{"metadata:\n  id: a"},
+		},
+		{
+			name:    "leading separator and empty documents",
+			content: "---\nmetadata:\n  id: a\n---\n\n---\nmetadata:\n  id: b\n---\n",
+			want:    []string{"metadata:\n  id: a", "metadata:\n  id: b"},
+		},
+		{
+			name:    "comment-only document is dropped",
+			content: "# header\n---\nmetadata:\n  id: a\n",
+			want:    []string{"metadata:\n  id: a"},
+		},
+		{
+			name:    "indented separator inside block scalar",
+			content: "spec:\n  data: |\n    ---\n    inner\n",
+			want:    []string{"spec:\n  data: |\n    ---\n    inner"},
+		},
+		{
+			name:    "separator with trailing comment",
+			content: "a: 1\n--- # next\nb: 2\n",
+			want:    []string{"a: 1", "b: 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitDocuments(tt.content)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitDocuments() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMachineClasses(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantIDs   []string
+		wantTypes []string
+		wantErr   bool
+	}{
+		{
+			name: "manual and auto in one file",
+			content: `metadata:
+  namespace: default
+  type: MachineClasses.omni.sidero.dev
+  id: workers
+spec:
+  matchlabels:
+    - omni.sidero.dev/arch = amd64
+  autoprovision: null
+---
+metadata:
+  namespace: default
+  type: MachineClasses.omni.sidero.dev
+  id: kubevirt
+spec:
+  autoprovision:
+    providerid: kubevirt
+    kernelargs: []
+`,
+			wantIDs:   []string{"workers", "kubevirt"},
+			wantTypes: []string{"manual", "auto"},
+		},
+		{
+			name: "commented id and providerid are ignored",
+			content: `metadata:
+  # id: old-name
+  id: new-name
+spec:
+  # autoprovision:
+  #   providerid: kubevirt
+  matchlabels:
+    - env = prod
+`,
+			wantIDs:   []string{"new-name"},
+			wantTypes: []string{"manual"},
+		},
+		{
+			name: "nested id keys are not resource ids",
+			content: `metadata:
+  id: mc
+spec:
+  autoprovision:
+    providerid: aws
+    providerdata: |
+      id: not-a-class
+      name: neither
+`,
+			wantIDs:   []string{"mc"},
+			wantTypes: []string{"auto"},
+		},
+		{
+			name:      "documents without metadata id are skipped",
+			content:   "---\n# only a comment\n---\nspec: {}\n---\nmetadata:\n  id: x\n",
+			wantIDs:   []string{"x"},
+			wantTypes: []string{"manual"},
+		},
+		{
+			name:    "invalid yaml",
+			content: "metadata:\n  id: [unclosed\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMachineClasses([]byte(tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var ids, types []string
+			for _, mc := range got {
+				ids = append(ids, mc.Metadata.ID)
+				types = append(types, mc.ProvisionType())
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if !reflect.DeepEqual(types, tt.wantTypes) {
+				t.Errorf("provision types = %v, want %v", types, tt.wantTypes)
+			}
+		})
+	}
+}
+
+func TestParseResourcesClusterStatus(t *testing.T) {
+	content := `metadata:
+  namespace: default
+  type: ClusterStatuses.omni.sidero.dev
+  id: prod
+  version: 12
+spec:
+  available: true
+  ready: true
+  kubernetesapiready: true
+---
+metadata:
+  namespace: default
+  type: ClusterStatuses.omni.sidero.dev
+  id: staging
+spec:
+  ready: false
+  conditions:
+    - ready: true
+`
+	resources, err := ParseResources([]byte(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		id   string
+		want ClusterStatusSpec
+	}{
+		{id: "prod", want: ClusterStatusSpec{Ready: true, KubernetesAPIReady: true}},
+		{id: "staging", want: ClusterStatusSpec{}},
+	}
+	if len(resources) != len(tests) {
+		t.Fatalf("got %d resources, want %d", len(resources), len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			if resources[i].Metadata.ID != tt.id {
+				t.Fatalf("id = %q, want %q", resources[i].Metadata.ID, tt.id)
+			}
+			var got ClusterStatusSpec
+			if err := resources[i].DecodeSpec(&got); err != nil {
+				t.Fatalf("DecodeSpec: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("spec = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	type workers struct {
+		Name         string
+		Count        int
+		MachineClass string
+	}
+	tests := []struct {
+		name        string
+		content     string
+		wantName    string
+		wantTalos   string
+		wantK8s     string
+		wantCPCount int
+		wantCPClass string
+		wantWorkers []workers
+		wantErr     bool
+	}{
+		{
+			name: "machine classes with patches",
+			content: `kind: Cluster
+name: prod
+kubernetes:
+  version: v1.31.1
+talos:
+  version: v1.8.0
+patches:
+  - name: cni
+    inline:
+      cluster:
+        network:
+          cni:
+            name: none
+---
+kind: ControlPlane
+machineClass:
+  name: cp-class
+  size: 3
+patches:
+  - name: cp-patch
+    file: patches/cp.yaml
+---
+kind: Workers
+name: general
+machineClass:
+  name: worker-class
+  size: 5
+---
+kind: Workers
+name: autoscale
+machineClass:
+  name: burst-class
+  size: unlimited
+`,
+			wantName:    "prod",
+			wantTalos:   "v1.8.0",
+			wantK8s:     "v1.31.1",
+			wantCPCount: 3,
+			wantCPClass: "cp-class",
+			wantWorkers: []workers{
+				{Name: "general", Count: 5, MachineClass: "worker-class"},
+				{Name: "autoscale", Count: 0, MachineClass: "burst-class"},
+			},
+		},
+		{
+			name: "static machines and commented name",
+			content: `# name: commented-out
+kind: Cluster
+name: edge
+talos:
+  version: v1.7.6
+kubernetes:
+  version: v1.30.4
+---
+kind: ControlPlane
+machines:
+  - 430d882a-51a8-48b3-ae00-90c5b0b5b0b0
+---
+kind: Workers
+machines:
+  - 7e0c1a1c-2b0b-4a63-8f1e-111111111111
+  - 7e0c1a1c-2b0b-4a63-8f1e-222222222222
+---
+kind: Machine
+name: 430d882a-51a8-48b3-ae00-90c5b0b5b0b0
+`,
+			wantName:    "edge",
+			wantTalos:   "v1.7.6",
+			wantK8s:     "v1.30.4",
+			wantCPCount: 1,
+			wantWorkers: []workers{
+				{Count: 2},
+			},
+		},
+		{
+			name: "cluster document after node groups",
+			content: `kind: ControlPlane
+machineClass:
+  name: cp
+  size: 1
+---
+kind: Cluster
+name: late
+kubernetesVersion: v1.29.0
+talosVersion: v1.6.0
+`,
+			wantName:    "late",
+			wantTalos:   "v1.6.0",
+			wantK8s:     "v1.29.0",
+			wantCPCount: 1,
+			wantCPClass: "cp",
+		},
+		{
+			name:    "invalid size",
+			content: "kind: Workers\nmachineClass:\n  name: w\n  size: many\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate([]byte(tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := tmpl.ClusterName(); got != tt.wantName {
+				t.Errorf("ClusterName() = %q, want %q", got, tt.wantName)
+			}
+			if got := tmpl.Cluster.TalosVersion(); got != tt.wantTalos {
+				t.Errorf("TalosVersion() = %q, want %q", got, tt.wantTalos)
+			}
+			if got := tmpl.Cluster.KubernetesVersion(); got != tt.wantK8s {
+				t.Errorf("KubernetesVersion() = %q, want %q", got, tt.wantK8s)
+			}
+			if tmpl.ControlPlane == nil {
+				t.Fatal("ControlPlane is nil")
+			}
+			if got := tmpl.ControlPlane.NodeCount(); got != tt.wantCPCount {
+				t.Errorf("control plane count = %d, want %d", got, tt.wantCPCount)
+			}
+			if got := tmpl.ControlPlane.MachineClassName(); got != tt.wantCPClass {
+				t.Errorf("control plane class = %q, want %q", got, tt.wantCPClass)
+			}
+			var gotWorkers []workers
+			for _, w := range tmpl.Workers {
+				gotWorkers = append(gotWorkers, workers{Name: w.Name, Count: w.NodeCount(), MachineClass: w.MachineClassName()})
+			}
+			if !reflect.DeepEqual(gotWorkers, tt.wantWorkers) {
+				t.Errorf("workers = %+v, want %+v", gotWorkers, tt.wantWorkers)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	const desired = `metadata:
+  namespace: default
+  type: MachineClasses.omni.sidero.dev
+  id: workers
+spec:
+  matchlabels:
+    - role = worker
+`
+	tests := []struct {
+		name    string
+		desired string
+		live    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "server-managed metadata is ignored",
+			desired: desired,
+			live: `metadata:
+  namespace: default
+  type: MachineClasses.omni.sidero.dev
+  id: workers
+  version: 7
+  owner:
+  phase: running
+  created: 2024-01-01T00:00:00Z
+  updated: 2024-02-01T00:00:00Z
+spec:
+  matchlabels:
+    - role = worker
+  autoprovision: null
+`,
+		},
+		{
+			name:    "omitted namespace and empty lists match",
+			desired: "metadata:\n  id: workers\nspec:\n  matchlabels:\n    - role = worker\n  kernelargs: []\n",
+			live:    desired,
+		},
+		{
+			name:    "changed and added fields",
+			desired: desired + "    - zone = a\n",
+			live:    "metadata:\n  id: workers\nspec:\n  matchlabels:\n    - role = worker\n",
+			want:    "--- live\n+++ git\n+ spec.matchlabels[1]: zone = a",
+		},
+		{
+			name:    "removed and replaced fields",
+			desired: "metadata:\n  id: mc\nspec:\n  autoprovision:\n    providerid: aws\n",
+			live:    "metadata:\n  id: mc\n  labels:\n    team: a\nspec:\n  autoprovision:\n    providerid: kubevirt\n",
+			want: "--- live\n+++ git\n" +
+				"- metadata.labels.team: a\n" +
+				"- spec.autoprovision.providerid: kubevirt\n" +
+				"+ spec.autoprovision.providerid: aws",
+		},
+		{
+			name:    "missing live resource is all additions",
+			desired: desired,
+			want:    "--- live\n+++ git\n+ spec.matchlabels[0]: role = worker",
+		},
+		{
+			name:    "invalid live yaml",
+			desired: desired,
+			live:    "metadata: [broken\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.desired, tt.live)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigPatchTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels string
+		want   string
+	}{
+		{name: "cluster", labels: "omni.sidero.dev/cluster: prod", want: "cluster prod"},
+		{name: "machine set", labels: "omni.sidero.dev/cluster: prod\n    omni.sidero.dev/machine-set: prod-workers", want: "machine set prod-workers"},
+		{name: "cluster machine", labels: "omni.sidero.dev/cluster: prod\n    omni.sidero.dev/cluster-machine: 1234", want: "machine 1234"},
+		{name: "machine", labels: "omni.sidero.dev/machine: 5678", want: "machine 5678"},
+		{name: "no target", labels: "team: infra", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "metadata:\n  type: ConfigPatches.omni.sidero.dev\n  id: p\n  labels:\n    " + tt.labels + "\nspec:\n  data: \"{}\"\n"
+			patches, err := ParseConfigPatches([]byte(content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(patches) != 1 {
+				t.Fatalf("got %d patches, want 1", len(patches))
+			}
+			if got := patches[0].Target(); got != tt.want {
+				t.Errorf("Target() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAccess(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantUsers   []User
+		wantSAs     []ServiceAccount
+		wantPolicy  bool
+		wantErrText string
+	}{
+		{
+			name: "users, service accounts and policy",
+			content: `kind: User
+email: alice@example.com
+role: Admin
+---
+kind: ServiceAccount
+name: ci
+role: Operator
+---
+metadata:
+  namespace: default
+  type: AccessPolicies.omni.sidero.dev
+  id: access-policy
+spec:
+  rules: []
+`,
+			wantUsers:  []User{{Email: "alice@example.com", Role: "Admin"}},
+			wantSAs:    []ServiceAccount{{Name: "ci", Role: "Operator"}},
+			wantPolicy: true,
+		},
+		{
+			name:        "unknown role",
+			content:     "kind: User\nemail: bob@example.com\nrole: Owner\n",
+			wantErrText: `invalid role "Owner"`,
+		},
+		{
+			name:        "infra provider role is for service accounts only",
+			content:     "kind: User\nemail: bob@example.com\nrole: InfraProvider\n",
+			wantErrText: "invalid role",
+		},
+		{
+			name:        "service account name with domain",
+			content:     "kind: ServiceAccount\nname: ci@serviceaccount.omni.sidero.dev\nrole: Reader\n",
+			wantErrText: "without a domain",
+		},
+		{
+			name:        "unknown kind",
+			content:     "kind: Group\nname: admins\n",
+			wantErrText: "expected kind User or ServiceAccount",
+		},
+		{
+			name:        "wrong access policy id",
+			content:     "metadata:\n  type: AccessPolicies.omni.sidero.dev\n  id: mine\n",
+			wantErrText: "access policy id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAccess(tt.content)
+			if tt.wantErrText != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrText) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErrText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got.Users, tt.wantUsers) {
+				t.Errorf("users = %+v, want %+v", got.Users, tt.wantUsers)
+			}
+			if !reflect.DeepEqual(got.ServiceAccounts, tt.wantSAs) {
+				t.Errorf("service accounts = %+v, want %+v", got.ServiceAccounts, tt.wantSAs)
+			}
+			if (got.AccessPolicy != "") != tt.wantPolicy {
+				t.Errorf("access policy = %q, want present=%v", got.AccessPolicy, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestMachineClassMatches(t *testing.T) {
+	labels := map[string]string{
+		"omni.sidero.dev/arch": "amd64",
+		"zone":                 "a",
+		"gpu":                  "",
+	}
+	tests := []struct {
+		name    string
+		queries []string
+		want    bool
+		wantErr bool
+	}{
+		{name: "equality", queries: []string{"zone = a"}, want: true},
+		{name: "double equals", queries: []string{"zone==a"}, want: true},
+		{name: "all terms must hold", queries: []string{"omni.sidero.dev/arch = amd64, zone = b"}, want: false},
+		{name: "any query may match", queries: []string{"zone = b", "omni.sidero.dev/arch = amd64"}, want: true},
+		{name: "exists", queries: []string{"gpu"}, want: true},
+		{name: "not exists", queries: []string{"!gpu"}, want: false},
+		{name: "not equal to missing label", queries: []string{"rack != r1"}, want: true},
+		{name: "in", queries: []string{"zone in (b, a), gpu"}, want: true},
+		{name: "notin", queries: []string{"zone notin (a, b)"}, want: false},
+		{name: "no queries select nothing", want: false},
+		{name: "unsupported operator", queries: []string{"cores > 4"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := MachineClass{Spec: MachineClassSpec{MatchLabels: tt.queries}}
+			got, err := mc.Matches(labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "region": "eu"}
+	tests := []struct {
+		selector string
+		want     bool
+		wantErr  bool
+	}{
+		{selector: "env = prod", want: true},
+		{selector: "env = prod, region in (us, eu)", want: true},
+		{selector: "env = prod, !region", want: false},
+		{selector: "", wantErr: true},
+		{selector: "env ~ prod", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			got, err := MatchSelector(tt.selector, labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MatchSelector = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A malformed term after one that fails is still reported
+	if err := ValidateSelector("env = dev, cores > 4"); err == nil {
+		t.Error("ValidateSelector accepted an unsupported term")
+	}
+	if err := ValidateSelector("env = dev, zone in (a, b)"); err != nil {
+		t.Errorf("ValidateSelector: %v", err)
+	}
+}
+
+func TestPolicyFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        Policy
+		wantErr     bool
+	}{
+		{name: "no annotations", want: Policy{}},
+		{name: "auto sync", annotations: map[string]string{AnnotationSync: "auto"}, want: Policy{}},
+		{name: "manual sync", annotations: map[string]string{AnnotationSync: "manual"}, want: Policy{Manual: true}},
+		{name: "no prune", annotations: map[string]string{AnnotationPrune: "false"}, want: Policy{NoPrune: true}},
+		{name: "ignore", annotations: map[string]string{AnnotationIgnore: "true", "other": "x"}, want: Policy{Ignore: true}},
+		{name: "unknown sync mode", annotations: map[string]string{AnnotationSync: "manaul"}, wantErr: true},
+		{name: "prune not a bool", annotations: map[string]string{AnnotationPrune: "never"}, wantErr: true},
+		{name: "sync window", annotations: map[string]string{AnnotationSyncWindow: "allow 0 22 * * 6 4h"}, want: Policy{SyncWindow: "allow 0 22 * * 6 4h"}},
+		{name: "invalid sync window", annotations: map[string]string{AnnotationSyncWindow: "allow at night"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PolicyFromAnnotations(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("policy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateWave(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    int
+		wantSet bool
+		wantErr bool
+	}{
+		{name: "no label", doc: "kind: Cluster\nname: dev\n"},
+		{name: "wave", doc: "kind: Cluster\nname: dev\nlabels:\n  omni-cd/wave: \"3\"\n", want: 3, wantSet: true},
+		{name: "not a number", doc: "kind: Cluster\nname: dev\nlabels:\n  omni-cd/wave: first\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, set, err := tmpl.Wave()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || set != tt.wantSet {
+				t.Errorf("wave = %d, %v; want %d, %v", got, set, tt.want, tt.wantSet)
+			}
+		})
+	}
+}
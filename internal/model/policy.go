@@ -0,0 +1,99 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+
+	"omni-cd/internal/syncwindow"
+)
+
+// Annotations that set how omni-cd treats a single cluster or machine class.
+// On a machine class they go in metadata.annotations, on a cluster in the
+// annotations of the template's Cluster document. Omni keeps them on the
+// live resource, which is where they are read from once the resource has
+// been removed from Git.
+const (
+	AnnotationSync   = "omni-cd/sync"   // "auto" (default) or "manual"
+	AnnotationPrune  = "omni-cd/prune"  // "false" keeps the resource when it leaves Git
+	AnnotationIgnore = "omni-cd/ignore" // "true" hides the resource from omni-cd
+	// Sync windows of a cluster, in addition to the global ones; see
+	// syncwindow.Parse for the format
+	AnnotationSyncWindow = "omni-cd/sync-window"
+)
+
+// Policy is the per-resource sync policy declared through annotations.
+type Policy struct {
+	Manual  bool // Only diffed; changes are applied by a force sync
+	NoPrune bool // Not deleted when removed from Git
+	Ignore  bool // Neither applied, diffed nor deleted
+	// SyncWindow holds the cluster's own sync windows, already checked
+	// to parse
+	SyncWindow string
+}
+
+// PolicyFromAnnotations reads the omni-cd policy annotations. Unknown values
+// are an error rather than falling back to a default, since a typo in
+// "omni-cd/sync: manaul" must not make a production cluster auto-sync.
+func PolicyFromAnnotations(annotations map[string]string) (Policy, error) {
+	var p Policy
+	switch v := annotations[AnnotationSync]; v {
+	case "", "auto":
+	case "manual":
+		p.Manual = true
+	default:
+		return Policy{}, fmt.Errorf("%s must be auto or manual, got %q", AnnotationSync, v)
+	}
+	if v, ok := annotations[AnnotationPrune]; ok {
+		prune, err := strconv.ParseBool(v)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s must be true or false, got %q", AnnotationPrune, v)
+		}
+		p.NoPrune = !prune
+	}
+	if v, ok := annotations[AnnotationIgnore]; ok {
+		ignore, err := strconv.ParseBool(v)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s must be true or false, got %q", AnnotationIgnore, v)
+		}
+		p.Ignore = ignore
+	}
+	if v := annotations[AnnotationSyncWindow]; v != "" {
+		if _, err := syncwindow.Parse(v); err != nil {
+			return Policy{}, fmt.Errorf("%s: %w", AnnotationSyncWindow, err)
+		}
+		p.SyncWindow = v
+	}
+	return p, nil
+}
+
+// Labels returns short descriptions of the non-default parts of the policy,
+// for display.
+func (p Policy) Labels() []string {
+	if p.Ignore {
+		return []string{"ignored"}
+	}
+	var out []string
+	if p.Manual {
+		out = append(out, "manual sync")
+	}
+	if p.NoPrune {
+		out = append(out, "no prune")
+	}
+	if p.SyncWindow != "" {
+		out = append(out, "sync window")
+	}
+	return out
+}
+
+// Policy returns the sync policy of the machine class.
+func (mc *MachineClass) Policy() (Policy, error) {
+	return PolicyFromAnnotations(mc.Metadata.Annotations)
+}
+
+// Policy returns the sync policy declared on the Cluster document.
+func (t *Template) Policy() (Policy, error) {
+	if t.Cluster == nil {
+		return Policy{}, nil
+	}
+	return PolicyFromAnnotations(t.Cluster.Annotations)
+}
@@ -0,0 +1,188 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template is a parsed Omni cluster template: one Cluster document, one
+// ControlPlane document and any number of Workers documents. Other document
+// kinds (e.g. Machine) are ignored.
+type Template struct {
+	Cluster      *Cluster
+	ControlPlane *MachineSet
+	Workers      []MachineSet
+}
+
+// Cluster is the "kind: Cluster" document of a cluster template.
+type Cluster struct {
+	Name        string            `yaml:"name"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	Kubernetes  Component         `yaml:"kubernetes"`
+	Talos       Component         `yaml:"talos"`
+
+	// Flat version fields accepted for compatibility with older templates.
+	FlatKubernetesVersion string `yaml:"kubernetesVersion"`
+	FlatTalosVersion      string `yaml:"talosVersion"`
+}
+
+// Component holds the version of Talos or Kubernetes in a Cluster document.
+type Component struct {
+	Version string `yaml:"version"`
+}
+
+// TalosVersion returns the Talos version of the cluster.
+func (c *Cluster) TalosVersion() string {
+	if c.Talos.Version != "" {
+		return c.Talos.Version
+	}
+	return c.FlatTalosVersion
+}
+
+// KubernetesVersion returns the Kubernetes version of the cluster.
+func (c *Cluster) KubernetesVersion() string {
+	if c.Kubernetes.Version != "" {
+		return c.Kubernetes.Version
+	}
+	return c.FlatKubernetesVersion
+}
+
+// MachineSet is a "kind: ControlPlane" or "kind: Workers" document. Nodes are
+// either listed explicitly in Machines or taken from a machine class.
+type MachineSet struct {
+	Kind         string           `yaml:"kind"`
+	Name         string           `yaml:"name"`
+	Machines     []string         `yaml:"machines"`
+	MachineClass *MachineClassRef `yaml:"machineClass"`
+}
+
+// MachineClassRef allocates Size machines from the named machine class.
+type MachineClassRef struct {
+	Name string `yaml:"name"`
+	Size Size   `yaml:"size"`
+}
+
+// Size is the number of machines allocated from a machine class. Worker
+// pools may also use "unlimited", in which case Count is 0.
+type Size struct {
+	Count     int
+	Unlimited bool
+}
+
+// UnmarshalYAML accepts either an integer or "unlimited".
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	if value.Value == "unlimited" {
+		*s = Size{Unlimited: true}
+		return nil
+	}
+	n, err := strconv.Atoi(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid machine class size %q", value.Line, value.Value)
+	}
+	*s = Size{Count: n}
+	return nil
+}
+
+// NodeCount returns the number of nodes in the machine set: the machine
+// class size when a class is used, otherwise the number of listed machines.
+func (m *MachineSet) NodeCount() int {
+	if m.MachineClass != nil && m.MachineClass.Name != "" {
+		return m.MachineClass.Size.Count
+	}
+	return len(m.Machines)
+}
+
+// MachineClassName returns the machine class the set allocates from, or ""
+// for static machines.
+func (m *MachineSet) MachineClassName() string {
+	if m.MachineClass == nil {
+		return ""
+	}
+	return m.MachineClass.Name
+}
+
+// templateDoc is used to read the kind of a template document before
+// decoding it into its concrete type.
+type templateDoc struct {
+	Kind string
+	node yaml.Node
+}
+
+// UnmarshalYAML keeps the node so it can be decoded again once the kind is known.
+func (d *templateDoc) UnmarshalYAML(value *yaml.Node) error {
+	var head struct {
+		Kind string `yaml:"kind"`
+	}
+	if err := value.Decode(&head); err != nil {
+		return err
+	}
+	d.Kind = head.Kind
+	d.node = *value
+	return nil
+}
+
+// ParseTemplate parses a multi-document cluster template.
+func ParseTemplate(data []byte) (*Template, error) {
+	docs, err := decodeAll[templateDoc](data)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &Template{}
+	for _, d := range docs {
+		switch d.Kind {
+		case "Cluster":
+			var c Cluster
+			if err := d.node.Decode(&c); err != nil {
+				return nil, fmt.Errorf("cluster document: %w", err)
+			}
+			tmpl.Cluster = &c
+		case "ControlPlane":
+			var cp MachineSet
+			if err := d.node.Decode(&cp); err != nil {
+				return nil, fmt.Errorf("control plane document: %w", err)
+			}
+			tmpl.ControlPlane = &cp
+		case "Workers":
+			var w MachineSet
+			if err := d.node.Decode(&w); err != nil {
+				return nil, fmt.Errorf("workers document: %w", err)
+			}
+			tmpl.Workers = append(tmpl.Workers, w)
+		}
+	}
+	return tmpl, nil
+}
+
+// ClusterName returns the name from the Cluster document, or "" when the
+// template has none.
+func (t *Template) ClusterName() string {
+	if t.Cluster == nil {
+		return ""
+	}
+	return t.Cluster.Name
+}
+
+// LabelWave is the Cluster document label that puts a cluster in a rollout
+// wave. Waves are synced in ascending order.
+const LabelWave = "omni-cd/wave"
+
+// Wave returns the rollout wave set with the omni-cd/wave label, and
+// whether the label is set.
+func (t *Template) Wave() (int, bool, error) {
+	if t.Cluster == nil {
+		return 0, false, nil
+	}
+	v, ok := t.Cluster.Labels[LabelWave]
+	if !ok {
+		return 0, false, nil
+	}
+	wave, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s must be a number, got %q", LabelWave, v)
+	}
+	return wave, true, nil
+}
@@ -0,0 +1,123 @@
+package omni
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Client is the set of Omni operations omni-cd needs. Resources and cluster
+// templates are passed around as file paths and YAML, the same form they
+// have in Git. Every call gives up when its context is done.
+type Client interface {
+	// CheckConnectivity verifies that the Omni API is reachable and authenticated.
+	CheckConnectivity(ctx context.Context) error
+	// GetOmniVersion returns the Omni server version, or "unknown".
+	GetOmniVersion(ctx context.Context) string
+	// GetOmnictlVersion returns the client version, or "unknown".
+	GetOmnictlVersion(ctx context.Context) string
+
+	// Apply creates or updates every resource in a YAML file.
+	Apply(ctx context.Context, file string) error
+	// MachineClassValidate validates a machine class file without applying it.
+	MachineClassValidate(ctx context.Context, file string) error
+	// GetMachineClassIDs lists the IDs of all machine classes.
+	GetMachineClassIDs(ctx context.Context) ([]string, error)
+	// GetLiveMachineClass returns the YAML of a single machine class.
+	GetLiveMachineClass(ctx context.Context, id string) (string, error)
+	// GetAllLiveMachineClasses returns machine class ID -> YAML.
+	GetAllLiveMachineClasses(ctx context.Context) (map[string]string, error)
+	// DeleteMachineClass deletes a machine class. The returned output lets
+	// callers recognise "still in use" failures.
+	DeleteMachineClass(ctx context.Context, id string) (string, error)
+
+	// ConfigPatchValidate validates a config patch file without applying it.
+	ConfigPatchValidate(ctx context.Context, file string) error
+	// GetAllLiveConfigPatches returns config patch ID -> YAML.
+	GetAllLiveConfigPatches(ctx context.Context) (map[string]string, error)
+	// DeleteConfigPatch deletes a config patch.
+	DeleteConfigPatch(ctx context.Context, id string) error
+
+	// MachineResourceValidate validates a MachineLabels / MachineRequestSets
+	// file without applying it.
+	MachineResourceValidate(ctx context.Context, file string) error
+	// GetAllLiveMachineResources returns MachineLabels and MachineRequestSets
+	// ID -> YAML.
+	GetAllLiveMachineResources(ctx context.Context) (map[string]string, error)
+	// DeleteMachineResource deletes a MachineLabels or MachineRequestSets resource.
+	DeleteMachineResource(ctx context.Context, typ, id string) error
+	// GetMachines lists every machine with its labels.
+	GetMachines(ctx context.Context) ([]Machine, error)
+	// GetInfraProviderIDs lists the registered infrastructure providers.
+	GetInfraProviderIDs(ctx context.Context) ([]string, error)
+
+	// GetAccounts lists every user and service account identity with its role.
+	GetAccounts(ctx context.Context) ([]Account, error)
+	// CreateUser creates a user with a role.
+	CreateUser(ctx context.Context, email, role string) error
+	// SetRole changes the role of a user or service account identity.
+	SetRole(ctx context.Context, identity, role string) error
+	// DeleteUser deletes a user.
+	DeleteUser(ctx context.Context, email string) error
+	// DeleteServiceAccount destroys a service account.
+	DeleteServiceAccount(ctx context.Context, name string) error
+	// GetLiveAccessPolicy returns the access policy YAML, or "" when none is set.
+	GetLiveAccessPolicy(ctx context.Context) (string, error)
+
+	// ClusterTemplateValidate validates a cluster template file.
+	ClusterTemplateValidate(ctx context.Context, file string) error
+	// ClusterTemplateSync creates or updates the cluster described by a template.
+	ClusterTemplateSync(ctx context.Context, file string) error
+	// ClusterTemplateDiff returns the changes syncing a template would make.
+	ClusterTemplateDiff(ctx context.Context, file string) (string, error)
+
+	// GetClusterIDs lists the IDs of all clusters.
+	GetClusterIDs(ctx context.Context) ([]string, error)
+	// GetAllClusterReadyStatuses returns cluster ID -> readiness.
+	GetAllClusterReadyStatuses(ctx context.Context) (map[string]ClusterStatus, error)
+	// DeleteCluster deletes a cluster.
+	DeleteCluster(ctx context.Context, id string) error
+	// ExportCluster exports a cluster as cluster template YAML.
+	ExportCluster(ctx context.Context, id string) (string, error)
+	// GetLiveCluster returns the live cluster as cluster template YAML.
+	GetLiveCluster(ctx context.Context, id string) (string, error)
+	// GetAllLiveClusters returns cluster ID -> cluster template YAML. The
+	// clusters whose export failed are missing from a map returned with an
+	// error.
+	GetAllLiveClusters(ctx context.Context) (map[string]string, error)
+	// IsClusterTemplateManaged reports whether a cluster was created from a
+	// cluster template (and may therefore be deleted by omni-cd).
+	IsClusterTemplateManaged(ctx context.Context, id string) bool
+}
+
+// Omnictl implements Client by running the bundled omnictl binary, which
+// picks up OMNI_ENDPOINT and OMNI_SERVICE_ACCOUNT_KEY from the environment.
+type Omnictl struct {
+	timeouts    Timeouts
+	maxParallel int
+}
+
+// Timeouts bound how long a single omnictl call may run, on top of the
+// deadline of its context. Zero means no limit.
+type Timeouts struct {
+	// Call applies to reads, validations, diffs and resource changes.
+	Call time.Duration
+	// Sync applies to cluster template syncs and cluster deletions, which
+	// take as long as Omni needs to act on the cluster.
+	Sync time.Duration
+}
+
+// NewOmnictl returns a Client backed by omnictl. At most maxParallel
+// omnictl processes are started at a time when fetching all clusters; 0
+// means no limit.
+func NewOmnictl(timeouts Timeouts, maxParallel int) *Omnictl {
+	return &Omnictl{timeouts: timeouts, maxParallel: maxParallel}
+}
+
+var _ Client = (*Omnictl)(nil)
+
+// IsTimeout reports whether err comes from a call that ran out of time,
+// either its own timeout or the deadline of its context.
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
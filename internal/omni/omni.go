@@ -1,12 +1,18 @@
 package omni
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
+
+	"omni-cd/internal/model"
 )
 
 // ============================================================
@@ -14,8 +20,8 @@ import (
 // ============================================================
 
 // CheckConnectivity verifies that omnictl can communicate with the Omni instance.
-func CheckConnectivity() error {
-	return run("omnictl", "get", "sysversion")
+func (c *Omnictl) CheckConnectivity(ctx context.Context) error {
+	return run(ctx, c.timeouts.Call, "omnictl", "get", "sysversion")
 }
 
 // ============================================================
@@ -23,8 +29,9 @@ func CheckConnectivity() error {
 // ============================================================
 
 // GetOmnictlVersion returns the omnictl client version string.
-func GetOmnictlVersion() string {
-	cmd := exec.Command("omnictl", "--version")
+func (c *Omnictl) GetOmnictlVersion(ctx context.Context) string {
+	cmd, _, cancel := command(ctx, c.timeouts.Call, "omnictl", "--version")
+	defer cancel()
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return "unknown"
@@ -43,22 +50,22 @@ func GetOmnictlVersion() string {
 }
 
 // GetOmniVersion returns the Omni server version string.
-func GetOmniVersion() string {
-	cmd := exec.Command("omnictl", "get", "sysversion", "-o", "yaml")
-	out, err := cmd.CombinedOutput()
+func (c *Omnictl) GetOmniVersion(ctx context.Context) string {
+	out, err := output(ctx, c.timeouts.Call, "omnictl", "get", "sysversion", "-o", "yaml")
 	if err != nil {
 		return "unknown"
 	}
-	for _, line := range strings.Split(string(out), "\n") {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "backendversion:") {
-			parts := strings.Fields(trimmed)
-			if len(parts) >= 2 {
-				return parts[1]
-			}
-		}
+	resources, err := model.ParseResources(out)
+	if err != nil || len(resources) == 0 {
+		return "unknown"
+	}
+	var spec struct {
+		BackendVersion string `yaml:"backendversion"`
 	}
-	return "unknown"
+	if err := resources[0].DecodeSpec(&spec); err != nil || spec.BackendVersion == "" {
+		return "unknown"
+	}
+	return spec.BackendVersion
 }
 
 // CompareVersions checks if the Omni server version is higher than omnictl.
@@ -94,136 +101,195 @@ func normalizeVersion(v string) string {
 
 // Apply applies a YAML file to Omni using omnictl apply.
 // Supports multi-document YAML files separated by ---.
-func Apply(file string) error {
-	return run("omnictl", "apply", "-f", file)
+func (c *Omnictl) Apply(ctx context.Context, file string) error {
+	return run(ctx, c.timeouts.Call, "omnictl", "apply", "-f", file)
 }
 
-// MachineClassDryRun runs a dry-run apply and returns the diff output.
-// For machine classes, we compare the file spec with live state since omnictl
-// doesn't provide a proper diff command for machine classes.
-func MachineClassDryRun(file string) (string, error) {
-	// First, try to run dry-run to validate the file
-	cmd := exec.Command("omnictl", "apply", "-f", file, "--dry-run")
-	out, err := cmd.CombinedOutput()
-	output := string(out)
-
-	// If there's an error, it's likely a validation error
-	if err != nil {
-		// Return the error, not as diff content
-		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(output))
-	}
-
-	// Check if output indicates no changes
-	if strings.Contains(output, "no changes") || strings.TrimSpace(output) == "" {
-		return "", nil
-	}
-
-	// Check if it's creating a new resource
-	if strings.Contains(output, "Creating resource") {
-		// New resource - extract and return the spec
-		lines := strings.Split(output, "\n")
-		resourceLines := []string{}
-		inResource := false
-
-		for _, line := range lines {
-			trimmed := strings.TrimSpace(line)
-
-			// Skip status messages
-			if strings.HasPrefix(trimmed, "Processing ") ||
-				strings.HasPrefix(trimmed, "Syncing resources from:") ||
-				strings.HasPrefix(trimmed, "Creating resource ") {
-				continue
-			}
-
-			// Once we see metadata: or spec:, we're in the resource
-			if strings.HasPrefix(trimmed, "metadata:") || strings.HasPrefix(trimmed, "spec:") {
-				inResource = true
-			}
-
-			if inResource {
-				// Skip metadata fields that always change
-				if strings.HasPrefix(trimmed, "version:") ||
-					strings.HasPrefix(trimmed, "created:") ||
-					strings.HasPrefix(trimmed, "updated:") {
-					continue
-				}
-				resourceLines = append(resourceLines, line)
-			}
-		}
-
-		return strings.TrimSpace(strings.Join(resourceLines, "\n")), nil
-	}
-
-	// For updates, omnictl shows the full resource even when nothing changed
-	// So we always return empty to prevent unnecessary applies
-	// The real diff will be detected when user actually changes the file
-	return "", nil
+// MachineClassValidate runs a dry-run apply so Omni validates the file
+// without changing anything. Whether an apply is needed is decided by
+// comparing the file with the live machine classes, since the dry-run output
+// shows the full resource even when nothing changed.
+func (c *Omnictl) MachineClassValidate(ctx context.Context, file string) error {
+	_, err := output(ctx, c.timeouts.Call, "omnictl", "apply", "-f", file, "--dry-run")
+	return err
 }
 
 // GetMachineClassIDs returns all machine class IDs currently registered in Omni.
-func GetMachineClassIDs() ([]string, error) {
-	return getResourceIDs("omnictl", "get", "machineclasses", "-o", "yaml")
+func (c *Omnictl) GetMachineClassIDs(ctx context.Context) ([]string, error) {
+	return getResourceIDs(ctx, c.timeouts.Call, "omnictl", "get", "machineclasses", "-o", "yaml")
 }
 
 // GetLiveMachineClass gets the live machine class state from Omni.
 // Returns the YAML content of the current machine class configuration.
-func GetLiveMachineClass(id string) (string, error) {
-	cmd := exec.Command("omnictl", "get", "machineclass", id, "-o", "yaml")
-	out, err := cmd.CombinedOutput()
+func (c *Omnictl) GetLiveMachineClass(ctx context.Context, id string) (string, error) {
+	out, err := output(ctx, c.timeouts.Call, "omnictl", "get", "machineclass", id, "-o", "yaml")
 	if err != nil {
-		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		return "", err
 	}
 	return string(out), nil
 }
 
 // GetAllLiveMachineClasses fetches all machine classes in one call.
 // Returns a map of machine class ID -> YAML content.
-func GetAllLiveMachineClasses() (map[string]string, error) {
-	cmd := exec.Command("omnictl", "get", "machineclasses", "-o", "yaml")
-	out, err := cmd.CombinedOutput()
+func (c *Omnictl) GetAllLiveMachineClasses(ctx context.Context) (map[string]string, error) {
+	out, err := output(ctx, c.timeouts.Call, "omnictl", "get", "machineclasses", "-o", "yaml")
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		return nil, err
 	}
-	return parseMultiDocYAML(string(out)), nil
+	return parseMultiDocYAML(string(out))
 }
 
 // DeleteMachineClass deletes a machine class from Omni by id.
 // Returns the command output so callers can check for "still in use" errors.
-func DeleteMachineClass(id string) (string, error) {
-	cmd := exec.Command("omnictl", "delete", "machineclasses", id)
+func (c *Omnictl) DeleteMachineClass(ctx context.Context, id string) (string, error) {
+	cmd, ctx, cancel := command(ctx, c.timeouts.Call, "omnictl", "delete", "machineclasses", id)
+	defer cancel()
 	out, err := cmd.CombinedOutput()
 	output := strings.TrimSpace(string(out))
 	if err != nil {
-		return output, fmt.Errorf("%w: %s", err, output)
+		return output, commandError(ctx, err, output)
 	}
 	return output, nil
 }
 
+// ============================================================
+// Config Patches
+// ============================================================
+
+// ConfigPatchValidate runs a dry-run apply so Omni validates the file
+// without changing anything.
+func (c *Omnictl) ConfigPatchValidate(ctx context.Context, file string) error {
+	_, err := output(ctx, c.timeouts.Call, "omnictl", "apply", "-f", file, "--dry-run")
+	return err
+}
+
+// GetAllLiveConfigPatches fetches all config patches in one call.
+// Returns a map of config patch ID -> YAML content.
+func (c *Omnictl) GetAllLiveConfigPatches(ctx context.Context) (map[string]string, error) {
+	out, err := output(ctx, c.timeouts.Call, "omnictl", "get", "configpatches", "-o", "yaml")
+	if err != nil {
+		return nil, err
+	}
+	return parseMultiDocYAML(string(out))
+}
+
+// DeleteConfigPatch deletes a config patch from Omni by id.
+func (c *Omnictl) DeleteConfigPatch(ctx context.Context, id string) error {
+	return run(ctx, c.timeouts.Call, "omnictl", "delete", "configpatches", id)
+}
+
+// ============================================================
+// Machines
+// ============================================================
+
+// machineResourceKinds maps the machine resource types omni-cd manages to
+// the names omnictl knows them by.
+var machineResourceKinds = map[string]string{
+	model.MachineLabelsType:     "machinelabels",
+	model.MachineRequestSetType: "machinerequestsets",
+}
+
+// MachineResourceValidate runs a dry-run apply so Omni validates the file
+// without changing anything.
+func (c *Omnictl) MachineResourceValidate(ctx context.Context, file string) error {
+	_, err := output(ctx, c.timeouts.Call, "omnictl", "apply", "-f", file, "--dry-run")
+	return err
+}
+
+// GetAllLiveMachineResources fetches all MachineLabels and MachineRequestSets.
+// Returns a map of resource ID -> YAML content.
+func (c *Omnictl) GetAllLiveMachineResources(ctx context.Context) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, kind := range []string{"machinelabels", "machinerequestsets"} {
+		out, err := output(ctx, c.timeouts.Call, "omnictl", "get", kind, "-o", "yaml")
+		if err != nil {
+			return nil, err
+		}
+		docs, err := parseMultiDocYAML(string(out))
+		if err != nil {
+			return nil, err
+		}
+		for id, doc := range docs {
+			result[id] = doc
+		}
+	}
+	return result, nil
+}
+
+// DeleteMachineResource deletes a MachineLabels or MachineRequestSets resource.
+func (c *Omnictl) DeleteMachineResource(ctx context.Context, typ, id string) error {
+	kind, ok := machineResourceKinds[typ]
+	if !ok {
+		return fmt.Errorf("unsupported machine resource type %q", typ)
+	}
+	return run(ctx, c.timeouts.Call, "omnictl", "delete", kind, id)
+}
+
+// Machine is a machine registered in Omni with all of its labels, both
+// the ones Omni derives from the hardware and the ones users assign.
+type Machine struct {
+	ID     string
+	Labels map[string]string
+}
+
+// Cluster returns the cluster the machine is allocated to, or "".
+func (m Machine) Cluster() string {
+	return m.Labels[model.LabelCluster]
+}
+
+// GetMachines lists every machine with its labels.
+func (c *Omnictl) GetMachines(ctx context.Context) ([]Machine, error) {
+	out, err := output(ctx, c.timeouts.Call, "omnictl", "get", "machinestatus", "-o", "yaml")
+	if err != nil {
+		return nil, err
+	}
+	resources, err := model.ParseResources(out)
+	if err != nil {
+		return nil, err
+	}
+	machines := make([]Machine, 0, len(resources))
+	for _, r := range resources {
+		machines = append(machines, Machine{ID: r.Metadata.ID, Labels: r.Metadata.Labels})
+	}
+	return machines, nil
+}
+
+// GetInfraProviderIDs lists the infrastructure providers that have
+// registered with Omni.
+func (c *Omnictl) GetInfraProviderIDs(ctx context.Context) ([]string, error) {
+	return getResourceIDs(ctx, c.timeouts.Call, "omnictl", "get", "infraproviderstatuses", "-o", "yaml")
+}
+
 // ============================================================
 // Cluster Templates
 // ============================================================
 
 // ClusterTemplateValidate validates a cluster template file
 // before syncing to prevent broken configs from being applied.
-func ClusterTemplateValidate(file string) error {
-	return runInDir(filepath.Dir(file), "omnictl", "cluster", "template", "validate", "-f", filepath.Base(file))
+func (c *Omnictl) ClusterTemplateValidate(ctx context.Context, file string) error {
+	return runInDir(ctx, c.timeouts.Call, filepath.Dir(file), "omnictl", "cluster", "template", "validate", "-f", filepath.Base(file))
 }
 
 // ClusterTemplateSync syncs a cluster template to Omni.
 // Handles both creating new clusters and updating existing ones.
-func ClusterTemplateSync(file string) error {
-	return runInDir(filepath.Dir(file), "omnictl", "cluster", "template", "sync", "-f", filepath.Base(file))
+func (c *Omnictl) ClusterTemplateSync(ctx context.Context, file string) error {
+	return runInDir(ctx, c.timeouts.Sync, filepath.Dir(file), "omnictl", "cluster", "template", "sync", "-f", filepath.Base(file))
 }
 
 // ClusterTemplateDiff returns the diff output for a cluster template.
 // Returns empty string if there are no changes.
-func ClusterTemplateDiff(file string) (string, error) {
-	cmd := exec.Command("omnictl", "cluster", "template", "diff", "-f", filepath.Base(file))
+func (c *Omnictl) ClusterTemplateDiff(ctx context.Context, file string) (string, error) {
+	cmd, ctx, cancel := command(ctx, c.timeouts.Call, "omnictl", "cluster", "template", "diff", "-f", filepath.Base(file))
+	defer cancel()
 	cmd.Dir = filepath.Dir(file)
 	out, err := cmd.CombinedOutput()
 	output := strings.TrimSpace(string(out))
 	if err != nil {
-		// diff may return non-zero when there are differences, that's expected
+		// diff may return non-zero when there are differences, that's
+		// expected; a diff that never finished is not
+		if ctx.Err() != nil {
+			return "", commandError(ctx, err, output)
+		}
 		return output, nil
 	}
 	return output, nil
@@ -234,8 +300,8 @@ func ClusterTemplateDiff(file string) (string, error) {
 // ============================================================
 
 // GetClusterIDs returns all cluster IDs currently registered in Omni.
-func GetClusterIDs() ([]string, error) {
-	return getResourceIDs("omnictl", "get", "clusters", "-o", "yaml")
+func (c *Omnictl) GetClusterIDs(ctx context.Context) ([]string, error) {
+	return getResourceIDs(ctx, c.timeouts.Call, "omnictl", "get", "clusters", "-o", "yaml")
 }
 
 // ClusterStatus holds relevant status fields from omnictl get clusterstatus.
@@ -246,84 +312,63 @@ type ClusterStatus struct {
 
 // GetAllClusterReadyStatuses fetches status fields for every cluster in one call.
 // Returns a map of cluster ID -> ClusterStatus.
-func GetAllClusterReadyStatuses() (map[string]ClusterStatus, error) {
-	cmd := exec.Command("omnictl", "get", "clusterstatus", "-o", "yaml")
-	out, err := cmd.CombinedOutput()
+func (c *Omnictl) GetAllClusterReadyStatuses(ctx context.Context) (map[string]ClusterStatus, error) {
+	out, err := output(ctx, c.timeouts.Call, "omnictl", "get", "clusterstatus", "-o", "yaml")
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		return nil, err
 	}
-	return parseClusterStatuses(string(out)), nil
+	return parseClusterStatuses(out)
 }
 
 // parseClusterStatuses parses multi-doc YAML from omnictl get clusterstatus.
-func parseClusterStatuses(yamlContent string) map[string]ClusterStatus {
-	result := make(map[string]ClusterStatus)
-	docs := strings.Split(yamlContent, "\n---\n")
-	for _, doc := range docs {
-		doc = strings.TrimSpace(doc)
-		if doc == "" || doc == "---" {
-			continue
-		}
-		var id string
-		var st ClusterStatus
-		inSpec := false
-		for _, line := range strings.Split(doc, "\n") {
-			trimmed := strings.TrimSpace(line)
-			switch {
-			case trimmed == "metadata:":
-				inSpec = false
-			case trimmed == "spec:":
-				inSpec = true
-			case !inSpec && strings.HasPrefix(trimmed, "id:"):
-				parts := strings.Fields(trimmed)
-				if len(parts) >= 2 {
-					id = parts[1]
-				}
-			case inSpec && strings.HasPrefix(trimmed, "ready:"):
-				parts := strings.Fields(trimmed)
-				if len(parts) >= 2 {
-					st.Ready = parts[1] == "true"
-				}
-			case inSpec && strings.HasPrefix(trimmed, "kubernetesapiready:"):
-				parts := strings.Fields(trimmed)
-				if len(parts) >= 2 {
-					st.KubernetesAPIReady = parts[1] == "true"
-				}
-			}
+func parseClusterStatuses(data []byte) (map[string]ClusterStatus, error) {
+	resources, err := model.ParseResources(data)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]ClusterStatus, len(resources))
+	for _, res := range resources {
+		var spec model.ClusterStatusSpec
+		if err := res.DecodeSpec(&spec); err != nil {
+			return nil, fmt.Errorf("cluster status %s: %w", res.Metadata.ID, err)
 		}
-		if id != "" {
-			result[id] = st
+		result[res.Metadata.ID] = ClusterStatus{
+			Ready:              spec.Ready,
+			KubernetesAPIReady: spec.KubernetesAPIReady,
 		}
 	}
-	return result
+	return result, nil
 }
 
 // DeleteCluster deletes a cluster from Omni by id.
-func DeleteCluster(id string) error {
-	return run("omnictl", "cluster", "delete", id)
+func (c *Omnictl) DeleteCluster(ctx context.Context, id string) error {
+	return run(ctx, c.timeouts.Sync, "omnictl", "cluster", "delete", id)
 }
 
 // ExportCluster exports a cluster configuration as a cluster template YAML.
 // Returns the YAML content that can be used as a cluster template.
-func ExportCluster(id string) (string, error) {
-	cmd := exec.Command("omnictl", "cluster", "template", "export", "-c", id)
+func (c *Omnictl) ExportCluster(ctx context.Context, id string) (string, error) {
+	cmd, ctx, cancel := command(ctx, c.timeouts.Call, "omnictl", "cluster", "template", "export", "-c", id)
+	defer cancel()
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		return "", commandError(ctx, err, strings.TrimSpace(string(out)))
 	}
 	return string(out), nil
 }
 
 // GetLiveCluster gets the live cluster state from Omni (same as ExportCluster).
 // Returns the YAML content of the current cluster configuration.
-func GetLiveCluster(id string) (string, error) {
-	return ExportCluster(id)
+func (c *Omnictl) GetLiveCluster(ctx context.Context, id string) (string, error) {
+	return c.ExportCluster(ctx, id)
 }
 
-// GetAllLiveClusters fetches all cluster templates in parallel.
-// Returns a map of cluster name -> YAML content.
-func GetAllLiveClusters() (map[string]string, error) {
-	ids, err := GetClusterIDs()
+// GetAllLiveClusters fetches all cluster templates in parallel, running at
+// most maxParallel exports at a time.
+// Returns a map of cluster name -> YAML content. Clusters whose export fails
+// are left out of the map and their errors are returned with it.
+func (c *Omnictl) GetAllLiveClusters(ctx context.Context) (map[string]string, error) {
+	ids, err := c.GetClusterIDs(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -336,38 +381,177 @@ func GetAllLiveClusters() (map[string]string, error) {
 	type result struct {
 		id      string
 		content string
+		err     error
 	}
 
+	workers := c.maxParallel
+	if workers <= 0 || workers > len(ids) {
+		workers = len(ids)
+	}
+	sem := make(chan struct{}, workers)
 	resultChan := make(chan result, len(ids))
 	for _, id := range ids {
 		go func(clusterID string) {
-			content, _ := ExportCluster(clusterID)
-			resultChan <- result{id: clusterID, content: content}
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			content, err := c.ExportCluster(ctx, clusterID)
+			resultChan <- result{id: clusterID, content: content, err: err}
 		}(id)
 	}
 
 	// Collect results - use the cluster ID from GetClusterIDs, not from YAML parsing
 	resultMap := make(map[string]string)
+	var errs []error
 	for i := 0; i < len(ids); i++ {
 		r := <-resultChan
-		if r.content != "" {
+		switch {
+		case r.err != nil:
+			errs = append(errs, fmt.Errorf("cluster %s: %w", r.id, r.err))
+		case r.content != "":
 			resultMap[r.id] = r.content
 		}
 	}
 
-	return resultMap, nil
+	// A partial list would make missing clusters look deleted
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return resultMap, errors.Join(errs...)
 }
 
 // IsClusterTemplateManaged checks if a cluster has the
 // omni.sidero.dev/managed-by-cluster-templates annotation.
 // This annotation is only visible when querying individual clusters.
-func IsClusterTemplateManaged(id string) bool {
-	cmd := exec.Command("omnictl", "get", "cluster", id, "-o", "yaml")
-	out, err := cmd.CombinedOutput()
+func (c *Omnictl) IsClusterTemplateManaged(ctx context.Context, id string) bool {
+	out, err := output(ctx, c.timeouts.Call, "omnictl", "get", "cluster", id, "-o", "yaml")
 	if err != nil {
 		return false
 	}
-	return strings.Contains(string(out), "omni.sidero.dev/managed-by-cluster-templates")
+	resources, err := model.ParseResources(out)
+	if err != nil || len(resources) == 0 {
+		return false
+	}
+	_, managed := resources[0].Metadata.Annotations[model.AnnotationClusterTemplateManaged]
+	return managed
+}
+
+// ============================================================
+// Access
+// ============================================================
+
+// Account is an Omni identity (a user's email or a service account) and the
+// role of the user behind it.
+type Account struct {
+	Identity       string
+	Role           string
+	ServiceAccount bool
+}
+
+// serviceAccountLabel marks identities that belong to service accounts.
+const serviceAccountLabel = "omni.sidero.dev/service-account"
+
+// GetAccounts lists every identity with its role.
+func (c *Omnictl) GetAccounts(ctx context.Context) ([]Account, error) {
+	identities, err := runWithRetry(ctx, c.timeouts.Call, "omnictl", "get", "identities", "-o", "yaml")
+	if err != nil {
+		return nil, err
+	}
+	users, err := runWithRetry(ctx, c.timeouts.Call, "omnictl", "get", "users", "-o", "yaml")
+	if err != nil {
+		return nil, err
+	}
+	return parseAccounts(identities, users)
+}
+
+// parseAccounts joins Identity resources with the User resources holding
+// their roles.
+func parseAccounts(identities, users []byte) ([]Account, error) {
+	userResources, err := model.ParseResources(users)
+	if err != nil {
+		return nil, fmt.Errorf("users: %w", err)
+	}
+	roles := make(map[string]string, len(userResources))
+	for _, u := range userResources {
+		var spec struct {
+			Role string `yaml:"role"`
+		}
+		if err := u.DecodeSpec(&spec); err != nil {
+			return nil, fmt.Errorf("user %s: %w", u.Metadata.ID, err)
+		}
+		roles[u.Metadata.ID] = spec.Role
+	}
+
+	identityResources, err := model.ParseResources(identities)
+	if err != nil {
+		return nil, fmt.Errorf("identities: %w", err)
+	}
+	accounts := make([]Account, 0, len(identityResources))
+	for _, id := range identityResources {
+		var spec struct {
+			UserID string `yaml:"userid"`
+		}
+		if err := id.DecodeSpec(&spec); err != nil {
+			return nil, fmt.Errorf("identity %s: %w", id.Metadata.ID, err)
+		}
+		_, sa := id.Metadata.Labels[serviceAccountLabel]
+		accounts = append(accounts, Account{
+			Identity:       id.Metadata.ID,
+			Role:           roles[spec.UserID],
+			ServiceAccount: sa,
+		})
+	}
+	return accounts, nil
+}
+
+// CreateUser creates a user with the given role.
+func (c *Omnictl) CreateUser(ctx context.Context, email, role string) error {
+	return run(ctx, c.timeouts.Call, "omnictl", "user", "create", email, "--role", role)
+}
+
+// SetRole changes the role of a user or service account identity.
+func (c *Omnictl) SetRole(ctx context.Context, identity, role string) error {
+	return run(ctx, c.timeouts.Call, "omnictl", "user", "set-role", identity, "--role", role)
+}
+
+// DeleteUser deletes a user by email.
+func (c *Omnictl) DeleteUser(ctx context.Context, email string) error {
+	return run(ctx, c.timeouts.Call, "omnictl", "user", "delete", email)
+}
+
+// DeleteServiceAccount destroys a service account by name.
+func (c *Omnictl) DeleteServiceAccount(ctx context.Context, name string) error {
+	return run(ctx, c.timeouts.Call, "omnictl", "serviceaccount", "destroy", name)
+}
+
+// GetLiveAccessPolicy returns the YAML of the access policy, or "" when
+// none is defined.
+func (c *Omnictl) GetLiveAccessPolicy(ctx context.Context) (string, error) {
+	out, err := output(ctx, c.timeouts.Call, "omnictl", "get", "accesspolicies", "-o", "yaml")
+	if err != nil {
+		return "", err
+	}
+	docs, err := parseMultiDocYAML(string(out))
+	if err != nil {
+		return "", err
+	}
+	return docs[model.AccessPolicyID], nil
+}
+
+// ServiceAccountName returns the service account name encoded in an
+// OMNI_SERVICE_ACCOUNT_KEY, or "" when the key cannot be decoded.
+func ServiceAccountName(key string) string {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return ""
+	}
+	var sa struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return ""
+	}
+	name, _, _ := strings.Cut(sa.Name, "@")
+	return name
 }
 
 // ============================================================
@@ -395,115 +579,25 @@ type ClusterTemplateInfo struct {
 func ParseClusterTemplate(yamlContent string) ClusterTemplateInfo {
 	var info ClusterTemplateInfo
 
-	// Scan the full content for version fields — they are unique across all docs.
-	// Handles both flat (talosVersion: v1.x) and nested (talos:\n  version: v1.x) formats.
-	var inTalos, inKubernetes bool
-	for _, line := range strings.Split(yamlContent, "\n") {
-		trimmed := strings.TrimSpace(line)
-		// Flat format
-		if info.TalosVersion == "" && strings.HasPrefix(trimmed, "talosVersion:") {
-			info.TalosVersion = strings.TrimSpace(strings.TrimPrefix(trimmed, "talosVersion:"))
-		}
-		if info.KubernetesVersion == "" && strings.HasPrefix(trimmed, "kubernetesVersion:") {
-			info.KubernetesVersion = strings.TrimSpace(strings.TrimPrefix(trimmed, "kubernetesVersion:"))
-		}
-		// Nested format: talos: / kubernetes: followed by version:
-		if trimmed == "talos:" {
-			inTalos = true
-			inKubernetes = false
-			continue
-		}
-		if trimmed == "kubernetes:" {
-			inKubernetes = true
-			inTalos = false
-			continue
-		}
-		if strings.HasPrefix(trimmed, "version:") {
-			v := strings.TrimSpace(strings.TrimPrefix(trimmed, "version:"))
-			if inTalos && info.TalosVersion == "" {
-				info.TalosVersion = v
-			}
-			if inKubernetes && info.KubernetesVersion == "" {
-				info.KubernetesVersion = v
-			}
-			inTalos = false
-			inKubernetes = false
-			continue
-		}
-		// Any other non-empty, non-comment line resets nested state
-		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
-			inTalos = false
-			inKubernetes = false
-		}
+	tmpl, err := model.ParseTemplate([]byte(yamlContent))
+	if err != nil {
+		return info
 	}
 
-	// Parse per-document for node group information.
-	docs := strings.Split(yamlContent, "\n---")
-	for _, doc := range docs {
-		doc = strings.TrimSpace(doc)
-		if doc == "" {
-			continue
-		}
-
-		var kind, docName string
-		var inMachines, inMachineClass bool
-		var machineCount, machineClassSize int
-		var machineClassName string
-
-		for _, line := range strings.Split(doc, "\n") {
-			trimmed := strings.TrimSpace(line)
-
-			if strings.HasPrefix(trimmed, "kind:") {
-				kind = strings.TrimSpace(strings.TrimPrefix(trimmed, "kind:"))
-				inMachines, inMachineClass = false, false
-				continue
-			}
-
-			// Capture top-level name (not indented, not inside machineClass block)
-			if !inMachineClass && len(line) > 0 && line[0] != ' ' && line[0] != '\t' && strings.HasPrefix(trimmed, "name:") {
-				docName = strings.TrimSpace(strings.TrimPrefix(trimmed, "name:"))
-				continue
-			}
-
-			switch kind {
-			case "ControlPlane", "Workers":
-				if strings.HasPrefix(trimmed, "machines:") {
-					inMachines = true
-					inMachineClass = false
-				} else if strings.HasPrefix(trimmed, "machineClass:") {
-					inMachineClass = true
-					inMachines = false
-				} else if inMachines && strings.HasPrefix(trimmed, "- ") {
-					machineCount++
-				} else if inMachineClass && strings.HasPrefix(trimmed, "name:") {
-					machineClassName = strings.TrimSpace(strings.TrimPrefix(trimmed, "name:"))
-				} else if strings.HasPrefix(trimmed, "size:") {
-					parts := strings.Fields(trimmed)
-					if len(parts) >= 2 {
-						machineClassSize, _ = strconv.Atoi(parts[1])
-					}
-				}
-			}
-		}
-
-		switch kind {
-		case "ControlPlane":
-			if machineClassName != "" {
-				info.ControlPlaneMachineClass = machineClassName
-				info.ControlPlaneCount = machineClassSize
-			} else {
-				info.ControlPlaneCount = machineCount
-			}
-		case "Workers":
-			wg := WorkerGroup{Name: docName}
-			if machineClassName != "" {
-				wg.MachineClass = machineClassName
-				wg.Count = machineClassSize
-			} else {
-				wg.Count = machineCount
-			}
-			info.WorkerGroups = append(info.WorkerGroups, wg)
-		}
+	if tmpl.Cluster != nil {
+		info.TalosVersion = tmpl.Cluster.TalosVersion()
+		info.KubernetesVersion = tmpl.Cluster.KubernetesVersion()
+	}
+	if tmpl.ControlPlane != nil {
+		info.ControlPlaneCount = tmpl.ControlPlane.NodeCount()
+		info.ControlPlaneMachineClass = tmpl.ControlPlane.MachineClassName()
+	}
+	for _, w := range tmpl.Workers {
+		info.WorkerGroups = append(info.WorkerGroups, WorkerGroup{
+			Name:         w.Name,
+			Count:        w.NodeCount(),
+			MachineClass: w.MachineClassName(),
+		})
 	}
 
 	return info
@@ -513,128 +607,126 @@ func ParseClusterTemplate(yamlContent string) ClusterTemplateInfo {
 // Helpers
 // ============================================================
 
-// run executes a command and returns an error with output if it fails.
-func run(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+// command prepares a command that is killed when ctx is done or, if
+// timeout is non-zero, once it has run that long. The returned context
+// tells which of the two stopped it.
+func command(ctx context.Context, timeout time.Duration, name string, args ...string) (*exec.Cmd, context.Context, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
 	}
-	return nil
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Don't wait on output pipes that children of a killed omnictl still hold
+	cmd.WaitDelay = time.Second
+	return cmd, ctx, cancel
+}
+
+// commandError describes a failed command. A command stopped by its
+// context reports the context's error instead, so callers can tell a
+// timeout from a failure.
+func commandError(ctx context.Context, err error, output string) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	if output == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, output)
+}
+
+// run executes a command and returns an error with output if it fails.
+func run(ctx context.Context, timeout time.Duration, name string, args ...string) error {
+	return runInDir(ctx, timeout, "", name, args...)
 }
 
 // runInDir executes a command in a specific directory and returns an error with output if it fails.
-func runInDir(dir, name string, args ...string) error {
-	cmd := exec.Command(name, args...)
+func runInDir(ctx context.Context, timeout time.Duration, dir, name string, args ...string) error {
+	cmd, ctx, cancel := command(ctx, timeout, name, args...)
+	defer cancel()
 	cmd.Dir = dir
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		return commandError(ctx, err, strings.TrimSpace(string(out)))
 	}
 	return nil
 }
 
+// output executes a command and returns its stdout. Stderr only ends up in
+// the returned error, so warnings printed by omnictl never mix into YAML that
+// is about to be parsed.
+func output(ctx context.Context, timeout time.Duration, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd, ctx, cancel := command(ctx, timeout, name, args...)
+	defer cancel()
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, commandError(ctx, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
 // runWithRetry executes a command with retry logic for transient errors.
-func runWithRetry(name string, args ...string) ([]byte, error) {
+// Only stdout is returned on success. The timeout applies to each attempt.
+func runWithRetry(ctx context.Context, timeout time.Duration, name string, args ...string) ([]byte, error) {
 	maxRetries := 3
 	baseDelay := 500 * time.Millisecond
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		cmd := exec.Command(name, args...)
-		out, err := cmd.CombinedOutput()
+		var stderr bytes.Buffer
+		cmd, cmdCtx, cancel := command(ctx, timeout, name, args...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		cancel()
 
 		if err == nil {
 			return out, nil
 		}
 
 		// Check if it's a transient error (missing content-type, connection issues)
-		output := strings.TrimSpace(string(out))
+		output := strings.TrimSpace(string(out) + stderr.String())
 		isTransient := strings.Contains(output, "missing HTTP content-type") ||
 			strings.Contains(output, "connection refused") ||
 			strings.Contains(output, "connection reset") ||
 			strings.Contains(output, "timeout")
 
-		if !isTransient || attempt == maxRetries-1 {
-			return out, fmt.Errorf("%w: %s", err, output)
+		if !isTransient || attempt == maxRetries-1 || cmdCtx.Err() != nil {
+			return out, commandError(cmdCtx, err, output)
 		}
 
 		// Wait before retrying with exponential backoff
 		delay := baseDelay * time.Duration(1<<uint(attempt))
-		time.Sleep(delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
 
 	return nil, fmt.Errorf("max retries exceeded")
 }
 
 // getResourceIDs executes a command and parses YAML output for id fields.
-func getResourceIDs(name string, args ...string) ([]string, error) {
-	out, err := runWithRetry(name, args...)
+func getResourceIDs(ctx context.Context, timeout time.Duration, name string, args ...string) ([]string, error) {
+	out, err := runWithRetry(ctx, timeout, name, args...)
 	if err != nil {
 		return nil, err
 	}
-	return parseIDs(string(out)), nil
+	return model.ResourceIDs(out)
 }
 
 // parseMultiDocYAML splits YAML output by document separator and extracts individual resources.
 // Returns a map of resource ID -> full YAML document.
-// For cluster templates, uses "name:" field instead of "id:".
-func parseMultiDocYAML(yamlContent string) map[string]string {
+func parseMultiDocYAML(yamlContent string) (map[string]string, error) {
 	result := make(map[string]string)
-
-	// Split by YAML document separator
-	docs := strings.Split(yamlContent, "\n---\n")
-
-	for _, doc := range docs {
-		doc = strings.TrimSpace(doc)
-		if doc == "" || doc == "---" {
-			continue
-		}
-
-		// Extract ID from the document (try both "id:" and "name:")
-		id := extractIDFromYAML(doc)
-		if id != "" {
-			result[id] = doc
+	for _, doc := range model.SplitDocuments(yamlContent) {
+		ids, err := model.ResourceIDs([]byte(doc))
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	return result
-}
-
-// extractIDFromYAML extracts the resource ID from a YAML document.
-// Looks for "id: <value>" or "name: <value>" patterns (for cluster templates).
-func extractIDFromYAML(yamlDoc string) string {
-	lines := strings.Split(yamlDoc, "\n")
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		// Check for id: first (machine classes)
-		if strings.HasPrefix(trimmed, "id:") {
-			parts := strings.Fields(trimmed)
-			if len(parts) >= 2 {
-				return parts[1]
-			}
-		}
-		// Check for name: (cluster templates)
-		if strings.HasPrefix(trimmed, "name:") {
-			parts := strings.Fields(trimmed)
-			if len(parts) >= 2 {
-				return parts[1]
-			}
-		}
-	}
-	return ""
-}
-
-// parseIDs extracts all id values from YAML output lines like "  id: some-id".
-func parseIDs(yaml string) []string {
-	var ids []string
-	for _, line := range strings.Split(yaml, "\n") {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "id:") {
-			parts := strings.Fields(trimmed)
-			if len(parts) >= 2 {
-				ids = append(ids, parts[1])
-			}
+		if len(ids) == 1 {
+			result[ids[0]] = doc
 		}
 	}
-	return ids
+	return result, nil
 }
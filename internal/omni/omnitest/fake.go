@@ -0,0 +1,768 @@
+// Package omnitest provides an in-memory omni.Client for tests.
+package omnitest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"omni-cd/internal/model"
+	"omni-cd/internal/omni"
+)
+
+// Fake is a stateful in-memory Omni. Machine classes and config patches are
+// stored as the YAML they were applied with plus server-managed metadata
+// (version, created, updated); clusters as the template they were synced
+// with, so diffs report a change exactly when the file content differs from
+// what Omni holds.
+// Deleting a machine class that a cluster still allocates from fails with a
+// "still in use" error, as it does in Omni. Labels assigned through a
+// MachineLabels resource show up on the machine of the same ID.
+type Fake struct {
+	mu             sync.Mutex
+	machineClasses map[string]string
+	configPatches  map[string]string
+	machineRes     map[string]string
+	machines       map[string]map[string]string
+	providers      []string
+	accessPolicies map[string]string
+	accounts       map[string]omni.Account
+	clusters       map[string]*cluster
+	unhealthy      map[string]bool
+	errors         map[string]error
+	hangs          map[string]bool
+	calls          []string
+	version        int
+}
+
+// cluster is a cluster held by the fake.
+type cluster struct {
+	template string
+	managed  bool
+}
+
+// machineClassType is the resource type of machine classes.
+const machineClassType = "MachineClasses.omni.sidero.dev"
+
+// New returns an empty fake.
+func New() *Fake {
+	return &Fake{
+		machineClasses: make(map[string]string),
+		configPatches:  make(map[string]string),
+		machineRes:     make(map[string]string),
+		machines:       make(map[string]map[string]string),
+		accessPolicies: make(map[string]string),
+		accounts:       make(map[string]omni.Account),
+		clusters:       make(map[string]*cluster),
+		unhealthy:      make(map[string]bool),
+		errors:         make(map[string]error),
+		hangs:          make(map[string]bool),
+	}
+}
+
+var _ omni.Client = (*Fake)(nil)
+
+// AddCluster registers a cluster directly. Clusters that are not managed
+// behave like clusters created by hand in the Omni UI.
+func (f *Fake) AddCluster(id, template string, managed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clusters[id] = &cluster{template: strings.TrimSpace(template), managed: managed}
+}
+
+// AddConfigPatch stores a config patch document as-is, e.g. one carrying an
+// owner or the cluster template annotation that omni-cd must leave alone.
+func (f *Fake) AddConfigPatch(doc string) error {
+	ids, err := model.ResourceIDs([]byte(doc))
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		f.configPatches[id] = doc
+	}
+	return nil
+}
+
+// AddMachine registers a machine with the labels Omni reports for it, e.g.
+// its hardware labels and omni.sidero.dev/cluster when it is allocated.
+func (f *Fake) AddMachine(id string, labels map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.machines[id] = labels
+}
+
+// AddInfraProvider registers a connected infrastructure provider.
+func (f *Fake) AddInfraProvider(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.providers = append(f.providers, id)
+}
+
+// AddMachineResource stores a MachineLabels or MachineRequestSets document as-is.
+func (f *Fake) AddMachineResource(doc string) error {
+	ids, err := model.ResourceIDs([]byte(doc))
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		f.machineRes[id] = doc
+	}
+	return nil
+}
+
+// MachineResourceIDs returns the IDs of all stored MachineLabels and
+// MachineRequestSets, sorted.
+func (f *Fake) MachineResourceIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedKeys(f.machineRes)
+}
+
+// AddAccount registers a user or, with serviceAccount set, a service account
+// identity such as "ci@serviceaccount.omni.sidero.dev".
+func (f *Fake) AddAccount(identity, role string, serviceAccount bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.accounts[identity] = omni.Account{Identity: identity, Role: role, ServiceAccount: serviceAccount}
+}
+
+// Accounts returns "identity=role" for every stored account, sorted.
+func (f *Fake) Accounts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, id := range sortedKeys(f.accounts) {
+		out = append(out, id+"="+f.accounts[id].Role)
+	}
+	return out
+}
+
+// FailOn makes the named operation fail for a resource, e.g.
+// FailOn("ClusterTemplateSync", "prod", err). Passing a nil error clears it.
+func (f *Fake) FailOn(op, id string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errors, op+"/"+id)
+		return
+	}
+	f.errors[op+"/"+id] = err
+}
+
+// HangOn makes the named operation block for a resource until its context
+// is done, like an omnictl call against a cluster that never answers, e.g.
+// HangOn("ClusterTemplateSync", "prod"). Supported for cluster template
+// syncs and diffs and cluster deletions.
+func (f *Fake) HangOn(op, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hangs[op+"/"+id] = true
+}
+
+// hang blocks until ctx is done when the operation is set to hang.
+func (f *Fake) hang(ctx context.Context, op, id string) error {
+	f.mu.Lock()
+	hangs := f.hangs[op+"/"+id]
+	f.mu.Unlock()
+	if !hangs {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Calls returns the mutating operations performed so far as "Op/id".
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// ResetCalls clears the recorded operations.
+func (f *Fake) ResetCalls() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+// MachineClassIDs returns the IDs of all stored machine classes, sorted.
+func (f *Fake) MachineClassIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedKeys(f.machineClasses)
+}
+
+// ConfigPatchIDs returns the IDs of all stored config patches, sorted.
+func (f *Fake) ConfigPatchIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedKeys(f.configPatches)
+}
+
+// ClusterIDs returns the IDs of all stored clusters, sorted.
+func (f *Fake) ClusterIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedKeys(f.clusters)
+}
+
+// ============================================================
+// omni.Client
+// ============================================================
+
+// CheckConnectivity always succeeds.
+func (f *Fake) CheckConnectivity(ctx context.Context) error { return nil }
+
+// GetOmniVersion returns a fixed version.
+func (f *Fake) GetOmniVersion(ctx context.Context) string { return "v1.0.0" }
+
+// GetOmnictlVersion returns a fixed version.
+func (f *Fake) GetOmnictlVersion(ctx context.Context) string { return "v1.0.0" }
+
+// Apply stores every resource in file by its metadata.type, stamped with
+// server-managed metadata the way Omni returns it.
+func (f *Fake) Apply(ctx context.Context, file string) error {
+	byType, err := resourceDocs(file)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, docs := range byType {
+		for id := range docs {
+			if err := f.errors["Apply/"+id]; err != nil {
+				return err
+			}
+		}
+	}
+	for _, typ := range sortedKeys(byType) {
+		docs := byType[typ]
+		into := f.store(typ)
+		for _, id := range sortedKeys(docs) {
+			f.version++
+			live, err := withServerMetadata(docs[id], f.version)
+			if err != nil {
+				return err
+			}
+			into[id] = live
+			f.calls = append(f.calls, "Apply/"+id)
+		}
+	}
+	return nil
+}
+
+// store returns the map holding resources of an Omni resource type.
+func (f *Fake) store(typ string) map[string]string {
+	switch typ {
+	case model.ConfigPatchType:
+		return f.configPatches
+	case model.AccessPolicyType:
+		return f.accessPolicies
+	case model.MachineLabelsType, model.MachineRequestSetType:
+		return f.machineRes
+	}
+	return f.machineClasses
+}
+
+// MachineClassValidate checks that file parses as machine classes.
+func (f *Fake) MachineClassValidate(ctx context.Context, file string) error {
+	_, err := resourceDocs(file)
+	return err
+}
+
+// GetMachineClassIDs lists the stored machine classes.
+func (f *Fake) GetMachineClassIDs(ctx context.Context) ([]string, error) {
+	return f.MachineClassIDs(), nil
+}
+
+// GetLiveMachineClass returns a stored machine class.
+func (f *Fake) GetLiveMachineClass(ctx context.Context, id string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	doc, ok := f.machineClasses[id]
+	if !ok {
+		return "", fmt.Errorf("machine class %q not found", id)
+	}
+	return doc, nil
+}
+
+// GetAllLiveMachineClasses returns every stored machine class.
+func (f *Fake) GetAllLiveMachineClasses(ctx context.Context) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]string, len(f.machineClasses))
+	for id, doc := range f.machineClasses {
+		out[id] = doc
+	}
+	return out, nil
+}
+
+// DeleteMachineClass removes a machine class unless a cluster allocates from it.
+func (f *Fake) DeleteMachineClass(ctx context.Context, id string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["DeleteMachineClass/"+id]; err != nil {
+		return err.Error(), err
+	}
+	if _, ok := f.machineClasses[id]; !ok {
+		out := fmt.Sprintf("machine class %q not found", id)
+		return out, errors.New(out)
+	}
+	for _, name := range sortedKeys(f.clusters) {
+		if usesMachineClass(f.clusters[name].template, id) {
+			out := fmt.Sprintf("machine class %q is still in use by cluster %q", id, name)
+			return out, errors.New(out)
+		}
+	}
+	delete(f.machineClasses, id)
+	f.calls = append(f.calls, "DeleteMachineClass/"+id)
+	return "", nil
+}
+
+// ConfigPatchValidate requires every document to be a config patch whose
+// data is valid YAML.
+func (f *Fake) ConfigPatchValidate(ctx context.Context, file string) error {
+	byType, err := resourceDocs(file)
+	if err != nil {
+		return err
+	}
+	patches := byType[model.ConfigPatchType]
+	if len(patches) != len(model.SplitDocuments(readFile(file))) {
+		return fmt.Errorf("%s: every document must be a config patch", filepath.Base(file))
+	}
+	for _, id := range sortedKeys(patches) {
+		parsed, err := model.ParseConfigPatches([]byte(patches[id]))
+		if err != nil {
+			return err
+		}
+		var data map[string]any
+		if err := yaml.Unmarshal([]byte(parsed[0].Spec.Data), &data); err != nil {
+			return fmt.Errorf("config patch %q: invalid data: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// GetAllLiveConfigPatches returns every stored config patch.
+func (f *Fake) GetAllLiveConfigPatches(ctx context.Context) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]string, len(f.configPatches))
+	for id, doc := range f.configPatches {
+		out[id] = doc
+	}
+	return out, nil
+}
+
+// DeleteConfigPatch removes a config patch.
+func (f *Fake) DeleteConfigPatch(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["DeleteConfigPatch/"+id]; err != nil {
+		return err
+	}
+	if _, ok := f.configPatches[id]; !ok {
+		return fmt.Errorf("config patch %q not found", id)
+	}
+	delete(f.configPatches, id)
+	f.calls = append(f.calls, "DeleteConfigPatch/"+id)
+	return nil
+}
+
+// MachineResourceValidate requires every document to be a MachineLabels or
+// MachineRequestSets resource.
+func (f *Fake) MachineResourceValidate(ctx context.Context, file string) error {
+	_, err := model.ParseMachineResources([]byte(readFile(file)))
+	return err
+}
+
+// GetAllLiveMachineResources returns every stored MachineLabels and
+// MachineRequestSets resource.
+func (f *Fake) GetAllLiveMachineResources(ctx context.Context) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]string, len(f.machineRes))
+	for id, doc := range f.machineRes {
+		out[id] = doc
+	}
+	return out, nil
+}
+
+// DeleteMachineResource removes a stored MachineLabels or MachineRequestSets
+// resource of the given type.
+func (f *Fake) DeleteMachineResource(ctx context.Context, typ, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["DeleteMachineResource/"+id]; err != nil {
+		return err
+	}
+	doc, ok := f.machineRes[id]
+	if !ok {
+		return fmt.Errorf("%s %q not found", typ, id)
+	}
+	resources, err := model.ParseResources([]byte(doc))
+	if err != nil || len(resources) == 0 || resources[0].Metadata.Type != typ {
+		return fmt.Errorf("%s %q not found", typ, id)
+	}
+	delete(f.machineRes, id)
+	f.calls = append(f.calls, "DeleteMachineResource/"+id)
+	return nil
+}
+
+// GetMachines returns every registered machine. Labels from a MachineLabels
+// resource with the machine's ID are added to the ones it was registered with.
+func (f *Fake) GetMachines(ctx context.Context) ([]omni.Machine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	machines := make([]omni.Machine, 0, len(f.machines))
+	for _, id := range sortedKeys(f.machines) {
+		labels := make(map[string]string)
+		for k, v := range f.machines[id] {
+			labels[k] = v
+		}
+		if doc, ok := f.machineRes[id]; ok {
+			if resources, err := model.ParseResources([]byte(doc)); err == nil && len(resources) > 0 && resources[0].Metadata.Type == model.MachineLabelsType {
+				for k, v := range resources[0].Metadata.Labels {
+					labels[k] = v
+				}
+			}
+		}
+		machines = append(machines, omni.Machine{ID: id, Labels: labels})
+	}
+	return machines, nil
+}
+
+// GetInfraProviderIDs returns the registered infrastructure providers.
+func (f *Fake) GetInfraProviderIDs(ctx context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.providers...), nil
+}
+
+// GetAccounts returns every stored account, sorted by identity.
+func (f *Fake) GetAccounts(ctx context.Context) ([]omni.Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	accounts := make([]omni.Account, 0, len(f.accounts))
+	for _, id := range sortedKeys(f.accounts) {
+		accounts = append(accounts, f.accounts[id])
+	}
+	return accounts, nil
+}
+
+// CreateUser adds a user account.
+func (f *Fake) CreateUser(ctx context.Context, email, role string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["CreateUser/"+email]; err != nil {
+		return err
+	}
+	if _, ok := f.accounts[email]; ok {
+		return fmt.Errorf("identity %q already exists", email)
+	}
+	f.accounts[email] = omni.Account{Identity: email, Role: role}
+	f.calls = append(f.calls, "CreateUser/"+email)
+	return nil
+}
+
+// SetRole changes the role of an account.
+func (f *Fake) SetRole(ctx context.Context, identity, role string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.accounts[identity]
+	if !ok {
+		return fmt.Errorf("identity %q not found", identity)
+	}
+	a.Role = role
+	f.accounts[identity] = a
+	f.calls = append(f.calls, "SetRole/"+identity)
+	return nil
+}
+
+// DeleteUser removes a user account.
+func (f *Fake) DeleteUser(ctx context.Context, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if a, ok := f.accounts[email]; !ok || a.ServiceAccount {
+		return fmt.Errorf("user %q not found", email)
+	}
+	delete(f.accounts, email)
+	f.calls = append(f.calls, "DeleteUser/"+email)
+	return nil
+}
+
+// DeleteServiceAccount removes a service account by name.
+func (f *Fake) DeleteServiceAccount(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, a := range f.accounts {
+		if a.ServiceAccount && strings.HasPrefix(id, name+"@") {
+			delete(f.accounts, id)
+			f.calls = append(f.calls, "DeleteServiceAccount/"+name)
+			return nil
+		}
+	}
+	return fmt.Errorf("service account %q not found", name)
+}
+
+// GetLiveAccessPolicy returns the stored access policy, if any.
+func (f *Fake) GetLiveAccessPolicy(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accessPolicies[model.AccessPolicyID], nil
+}
+
+// ClusterTemplateValidate requires a named Cluster document and a ControlPlane.
+func (f *Fake) ClusterTemplateValidate(ctx context.Context, file string) error {
+	_, _, err := readTemplate(file)
+	return err
+}
+
+// ClusterTemplateSync creates or updates the cluster and marks it managed.
+func (f *Fake) ClusterTemplateSync(ctx context.Context, file string) error {
+	name, content, err := readTemplate(file)
+	if err != nil {
+		return err
+	}
+	if err := f.hang(ctx, "ClusterTemplateSync", name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["ClusterTemplateSync/"+name]; err != nil {
+		return err
+	}
+	f.clusters[name] = &cluster{template: content, managed: true}
+	f.calls = append(f.calls, "ClusterTemplateSync/"+name)
+	return nil
+}
+
+// ClusterTemplateDiff returns "" when the template matches the stored
+// cluster, otherwise a minimal description of the change.
+func (f *Fake) ClusterTemplateDiff(ctx context.Context, file string) (string, error) {
+	name, content, err := readTemplate(file)
+	if err != nil {
+		return "", err
+	}
+	if err := f.hang(ctx, "ClusterTemplateDiff", name); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.clusters[name]
+	switch {
+	case !ok:
+		return "+++ " + name + " (new cluster)", nil
+	case c.template != content:
+		return "--- " + name + " (live)\n+++ " + name + " (template)", nil
+	}
+	return "", nil
+}
+
+// GetClusterIDs lists the stored clusters.
+func (f *Fake) GetClusterIDs(ctx context.Context) ([]string, error) {
+	return f.ClusterIDs(), nil
+}
+
+// SetClusterHealthy sets whether a cluster reports Ready and
+// KubernetesAPIReady. Clusters are healthy unless set otherwise.
+func (f *Fake) SetClusterHealthy(id string, healthy bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unhealthy[id] = !healthy
+}
+
+// GetAllClusterReadyStatuses reports the health of every cluster.
+func (f *Fake) GetAllClusterReadyStatuses(ctx context.Context) (map[string]omni.ClusterStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]omni.ClusterStatus, len(f.clusters))
+	for id := range f.clusters {
+		out[id] = omni.ClusterStatus{Ready: !f.unhealthy[id], KubernetesAPIReady: !f.unhealthy[id]}
+	}
+	return out, nil
+}
+
+// DeleteCluster removes a cluster.
+func (f *Fake) DeleteCluster(ctx context.Context, id string) error {
+	if err := f.hang(ctx, "DeleteCluster", id); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["DeleteCluster/"+id]; err != nil {
+		return err
+	}
+	if _, ok := f.clusters[id]; !ok {
+		return fmt.Errorf("cluster %q not found", id)
+	}
+	delete(f.clusters, id)
+	f.calls = append(f.calls, "DeleteCluster/"+id)
+	return nil
+}
+
+// ExportCluster returns the template a cluster was synced with.
+func (f *Fake) ExportCluster(ctx context.Context, id string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.clusters[id]
+	if !ok {
+		return "", fmt.Errorf("cluster %q not found", id)
+	}
+	if err := f.errors["ExportCluster/"+id]; err != nil {
+		return "", err
+	}
+	return c.template, nil
+}
+
+// GetLiveCluster is the same as ExportCluster.
+func (f *Fake) GetLiveCluster(ctx context.Context, id string) (string, error) {
+	return f.ExportCluster(ctx, id)
+}
+
+// GetAllLiveClusters returns the template of every cluster, leaving out
+// those whose export fails.
+func (f *Fake) GetAllLiveClusters(ctx context.Context) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]string, len(f.clusters))
+	var errs []error
+	for id, c := range f.clusters {
+		if err := f.errors["ExportCluster/"+id]; err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", id, err))
+			continue
+		}
+		out[id] = c.template
+	}
+	return out, errors.Join(errs...)
+}
+
+// IsClusterTemplateManaged reports whether the cluster was created by a template sync.
+func (f *Fake) IsClusterTemplateManaged(ctx context.Context, id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.clusters[id]
+	return ok && c.managed
+}
+
+// ============================================================
+// Helpers
+// ============================================================
+
+// resourceDocs returns metadata.type -> ID -> document for a YAML file.
+// Documents without a type are treated as machine classes.
+func resourceDocs(file string) (map[string]map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	byType := make(map[string]map[string]string)
+	for _, doc := range model.SplitDocuments(string(data)) {
+		resources, err := model.ParseResources([]byte(doc))
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range resources {
+			typ := res.Metadata.Type
+			if typ == "" {
+				typ = machineClassType
+			}
+			if byType[typ] == nil {
+				byType[typ] = make(map[string]string)
+			}
+			byType[typ][res.Metadata.ID] = doc
+		}
+	}
+	return byType, nil
+}
+
+// readFile returns the content of file, or "" when it cannot be read.
+func readFile(file string) string {
+	data, _ := os.ReadFile(file)
+	return string(data)
+}
+
+// withServerMetadata returns doc with the metadata fields Omni adds to every
+// stored resource.
+func withServerMetadata(doc string, version int) (string, error) {
+	var res map[string]any
+	if err := yaml.Unmarshal([]byte(doc), &res); err != nil {
+		return "", err
+	}
+	meta, _ := res["metadata"].(map[string]any)
+	if meta == nil {
+		meta = make(map[string]any)
+		res["metadata"] = meta
+	}
+	if _, ok := meta["namespace"]; !ok {
+		meta["namespace"] = "default"
+	}
+	meta["version"] = version
+	meta["owner"] = ""
+	meta["phase"] = "running"
+	meta["created"] = "2024-01-01T00:00:00Z"
+	meta["updated"] = fmt.Sprintf("2024-01-01T00:00:%02dZ", version%60)
+	out, err := yaml.Marshal(res)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// readTemplate validates a cluster template file and returns the cluster
+// name and trimmed content.
+func readTemplate(file string) (string, string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl, err := model.ParseTemplate(data)
+	if err != nil {
+		return "", "", err
+	}
+	if tmpl.ClusterName() == "" {
+		return "", "", fmt.Errorf("template has no Cluster document with a name")
+	}
+	if tmpl.ControlPlane == nil {
+		return "", "", fmt.Errorf("template has no ControlPlane document")
+	}
+	return tmpl.ClusterName(), strings.TrimSpace(string(data)), nil
+}
+
+// usesMachineClass reports whether a cluster template allocates from a machine class.
+func usesMachineClass(template, id string) bool {
+	tmpl, err := model.ParseTemplate([]byte(template))
+	if err != nil {
+		return false
+	}
+	if tmpl.ControlPlane != nil && tmpl.ControlPlane.MachineClassName() == id {
+		return true
+	}
+	for _, w := range tmpl.Workers {
+		if w.MachineClassName() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,202 @@
+// Package queue holds the reconcile requests waiting to run. Requests run
+// one at a time in the order they were queued. A request that a pending one
+// already covers is merged into it rather than queued twice, so nothing
+// asked for is dropped and nothing runs twice.
+package queue
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kind is what a reconcile request does.
+type Kind string
+
+const (
+	// Refresh pulls Git and reconciles what the new commit changed.
+	Refresh Kind = "refresh"
+	// Sync pulls Git and reconciles everything.
+	Sync Kind = "sync"
+	// Targeted syncs only the selected clusters and machine classes, even
+	// without changes or with a manual sync policy, and leaves everything
+	// else alone.
+	Targeted Kind = "targeted"
+)
+
+// Request is a queued reconcile.
+type Request struct {
+	ID             int      `json:"id"`
+	Kind           Kind     `json:"kind"`
+	Clusters       []string `json:"clusters,omitempty"`
+	MachineClasses []string `json:"machineClasses,omitempty"`
+	// Label selectors picking further clusters and machine classes by the
+	// labels they carry in Git
+	ClusterSelectors      []string `json:"clusterSelectors,omitempty"`
+	MachineClassSelectors []string `json:"machineClassSelectors,omitempty"`
+	// OverrideWindow lets the targeted clusters sync outside their sync
+	// windows.
+	OverrideWindow bool `json:"overrideWindow,omitempty"`
+	// Triggers lists what asked for the request, e.g. "web UI" or
+	// "scheduled", including the requests merged into it.
+	Triggers  []string   `json:"triggers"`
+	QueuedAt  time.Time  `json:"queuedAt"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+}
+
+// Snapshot is the request running and those waiting, in the order they run.
+type Snapshot struct {
+	Running *Request  `json:"running,omitempty"`
+	Pending []Request `json:"pending"`
+}
+
+// Queue is a reconcile request queue that is safe for concurrent use.
+type Queue struct {
+	mu       sync.Mutex
+	nextID   int
+	running  *Request
+	pending  []Request
+	wake     chan struct{}
+	onChange func()
+}
+
+// New returns an empty queue.
+func New() *Queue {
+	return &Queue{nextID: 1, wake: make(chan struct{}, 1)}
+}
+
+// OnChange registers fn to be called after every change to the queue.
+func (q *Queue) OnChange(fn func()) {
+	q.mu.Lock()
+	q.onChange = fn
+	q.mu.Unlock()
+}
+
+// Add queues a request on behalf of trigger and returns the pending request
+// it ended up in: a new one, or the one it was merged into.
+func (q *Queue) Add(r Request, trigger string) Request {
+	q.mu.Lock()
+	r.Triggers = union(nil, []string{trigger})
+	var queued Request
+	merged := false
+	for i := range q.pending {
+		if absorb(&q.pending[i], r) {
+			queued, merged = q.pending[i], true
+			break
+		}
+	}
+	if !merged {
+		r.ID = q.nextID
+		q.nextID++
+		r.Clusters = union(nil, r.Clusters)
+		r.MachineClasses = union(nil, r.MachineClasses)
+		r.ClusterSelectors = union(nil, r.ClusterSelectors)
+		r.MachineClassSelectors = union(nil, r.MachineClassSelectors)
+		r.QueuedAt = time.Now().UTC()
+		r.StartedAt = nil
+		q.pending = append(q.pending, r)
+		queued = r
+	}
+	onChange := q.onChange
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	if onChange != nil {
+		onChange()
+	}
+	return queued
+}
+
+// Wake receives a value after requests were added. Callers then Start
+// the next request once they are idle.
+func (q *Queue) Wake() <-chan struct{} {
+	return q.wake
+}
+
+// Start takes the next pending request and marks it running. It returns
+// false when nothing is pending or a request is still running.
+func (q *Queue) Start() (Request, bool) {
+	q.mu.Lock()
+	if q.running != nil || len(q.pending) == 0 {
+		q.mu.Unlock()
+		return Request{}, false
+	}
+	r := q.pending[0]
+	q.pending = q.pending[1:]
+	now := time.Now().UTC()
+	r.StartedAt = &now
+	q.running = &r
+	onChange := q.onChange
+	q.mu.Unlock()
+
+	if onChange != nil {
+		onChange()
+	}
+	return r, true
+}
+
+// Finish marks the running request as done.
+func (q *Queue) Finish() {
+	q.mu.Lock()
+	q.running = nil
+	onChange := q.onChange
+	q.mu.Unlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// Snapshot returns a copy of the queue.
+func (q *Queue) Snapshot() Snapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := Snapshot{Pending: make([]Request, len(q.pending))}
+	copy(s.Pending, q.pending)
+	if q.running != nil {
+		r := *q.running
+		s.Running = &r
+	}
+	return s
+}
+
+// absorb merges r into the pending request p and reports whether p now
+// covers everything r asks for. A refresh is covered by a pending refresh or
+// sync, and a sync turns a pending refresh into a sync. Targeted requests
+// leave everything they do not select alone, so they only absorb each other,
+// as long as they agree on overriding the sync windows.
+func absorb(p *Request, r Request) bool {
+	switch {
+	case r.Kind == Targeted:
+		if p.Kind != Targeted || p.OverrideWindow != r.OverrideWindow {
+			return false
+		}
+		p.Clusters = union(p.Clusters, r.Clusters)
+		p.MachineClasses = union(p.MachineClasses, r.MachineClasses)
+		p.ClusterSelectors = union(p.ClusterSelectors, r.ClusterSelectors)
+		p.MachineClassSelectors = union(p.MachineClassSelectors, r.MachineClassSelectors)
+	case p.Kind == Targeted:
+		return false
+	case r.Kind == Sync:
+		p.Kind = Sync
+	}
+	p.Triggers = union(p.Triggers, r.Triggers)
+	return true
+}
+
+// union returns the sorted, de-duplicated values of a and b.
+func union(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var out []string
+	for _, v := range append(append([]string(nil), a...), b...) {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
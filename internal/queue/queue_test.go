@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddMergesCoveredRequests(t *testing.T) {
+	q := New()
+	first := q.Add(Request{Kind: Refresh}, "scheduled")
+	q.Add(Request{Kind: Refresh}, "webhook github")
+	q.Add(Request{Kind: Sync}, "web UI")
+
+	s := q.Snapshot()
+	if len(s.Pending) != 1 {
+		t.Fatalf("got %d pending requests, want 1", len(s.Pending))
+	}
+	got := s.Pending[0]
+	if got.ID != first.ID || got.Kind != Sync {
+		t.Errorf("got request %d of kind %q, want %d upgraded to %q", got.ID, got.Kind, first.ID, Sync)
+	}
+	if want := []string{"scheduled", "web UI", "webhook github"}; !reflect.DeepEqual(got.Triggers, want) {
+		t.Errorf("triggers = %v, want %v", got.Triggers, want)
+	}
+}
+
+func TestAddMergesTargets(t *testing.T) {
+	q := New()
+	q.Add(Request{Kind: Targeted, Clusters: []string{"prod"}}, "web UI")
+	q.Add(Request{Kind: Targeted, Clusters: []string{"dev", "prod"}}, "web UI")
+	q.Add(Request{Kind: Targeted, MachineClasses: []string{"workers"}}, "web UI")
+	q.Add(Request{Kind: Targeted, Clusters: []string{"stage"}, OverrideWindow: true}, "web UI")
+	q.Add(Request{Kind: Sync}, "scheduled")
+
+	s := q.Snapshot()
+	if len(s.Pending) != 3 {
+		t.Fatalf("got %d pending requests, want 3", len(s.Pending))
+	}
+	if want := []string{"dev", "prod"}; !reflect.DeepEqual(s.Pending[0].Clusters, want) {
+		t.Errorf("clusters = %v, want %v", s.Pending[0].Clusters, want)
+	}
+	if want := []string{"workers"}; !reflect.DeepEqual(s.Pending[0].MachineClasses, want) {
+		t.Errorf("machine classes = %v, want %v", s.Pending[0].MachineClasses, want)
+	}
+	if !s.Pending[1].OverrideWindow || !reflect.DeepEqual(s.Pending[1].Clusters, []string{"stage"}) {
+		t.Errorf("window override request = %+v, want stage alone", s.Pending[1])
+	}
+	// A targeted request leaves the other clusters alone, so it does not
+	// cover a full sync
+	if s.Pending[2].Kind != Sync {
+		t.Errorf("last request = %+v, want the sync", s.Pending[2])
+	}
+}
+
+func TestRunningRequestIsNotMergedInto(t *testing.T) {
+	q := New()
+	q.Add(Request{Kind: Sync}, "web UI")
+	running, ok := q.Start()
+	if !ok {
+		t.Fatal("Start found nothing pending")
+	}
+	if _, ok := q.Start(); ok {
+		t.Error("Start ran a second request while one was running")
+	}
+
+	// Git may have changed since the running request pulled it
+	q.Add(Request{Kind: Refresh}, "webhook github")
+	s := q.Snapshot()
+	if s.Running == nil || s.Running.ID != running.ID {
+		t.Fatalf("running = %+v, want request %d", s.Running, running.ID)
+	}
+	if len(s.Pending) != 1 || s.Pending[0].ID == running.ID {
+		t.Fatalf("pending = %+v, want a new request", s.Pending)
+	}
+
+	q.Finish()
+	next, ok := q.Start()
+	if !ok || next.Kind != Refresh {
+		t.Errorf("next = %+v, %v, want the refresh", next, ok)
+	}
+}
+
+func TestAddWakes(t *testing.T) {
+	q := New()
+	changes := 0
+	q.OnChange(func() { changes++ })
+	q.Add(Request{Kind: Refresh}, "scheduled")
+	q.Add(Request{Kind: Refresh}, "scheduled")
+
+	select {
+	case <-q.Wake():
+	default:
+		t.Fatal("Add did not wake the queue")
+	}
+	if changes != 2 {
+		t.Errorf("OnChange called %d times, want 2", changes)
+	}
+}
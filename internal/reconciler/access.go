@@ -0,0 +1,370 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"omni-cd/internal/model"
+	"omni-cd/internal/omni"
+	"omni-cd/internal/state"
+)
+
+// ============================================================
+// Access — Apply
+// ============================================================
+
+// ApplyAccess reconciles users, service account roles and the access policy
+// from the YAML files in the given directory. Missing users are created and
+// roles are corrected. Service accounts cannot be created from Git because
+// their key would have nowhere to go, so a missing one is reported as out of
+// sync. The directory is always processed as a whole.
+func (r *Reconciler) ApplyAccess(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No access changes, skipping apply", "component", "Access")
+		return
+	}
+
+	desired, err := loadAccess(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.logWarn("Directory not found, skipping", "component", "Access", "path", dir)
+			r.state.SetAccess([]state.ResourceInfo{})
+			return
+		}
+		r.logError("Failed to parse access files, skipping", "component", "Access", "error", err)
+		return
+	}
+
+	accounts, err := r.client.GetAccounts(ctx)
+	if err != nil {
+		r.logError("Failed to list users and service accounts", "component", "Access", "error", err)
+		return
+	}
+	users, serviceAccounts := indexAccounts(accounts)
+
+	r.logInfo("Syncing access", "component", "Access", "users", len(desired.Users), "service_accounts", len(desired.ServiceAccounts))
+
+	var resources []state.ResourceInfo
+	synced, failed := 0, 0
+	seen := make(map[string]bool)
+
+	for _, u := range desired.Users {
+		key := "User/" + u.Email
+		if seen[key] {
+			r.logError("Duplicate user found, skipping", "component", "Access", "user", u.Email)
+			r.touch("User", u.Email, false)
+			resources = append(resources, state.ResourceInfo{ID: u.Email, Type: "User", Status: "outofsync", Role: u.Role, Error: "User is defined more than once"})
+			failed++
+			continue
+		}
+		seen[key] = true
+
+		res := state.ResourceInfo{ID: u.Email, Type: "User", Status: "success", Role: u.Role}
+		live, exists := users[u.Email]
+		switch {
+		case !exists:
+			res.Diff = roleDiff("", u.Role)
+			if err := r.client.CreateUser(ctx, u.Email, u.Role); err != nil {
+				r.logError("User create failed", "component", "Access", "user", u.Email, "error", err)
+				r.touch("User", u.Email, false)
+				res.Status, res.Error = "failed", err.Error()
+				failed++
+			} else {
+				r.logInfo("User created", "component", "Access", "user", u.Email, "role", u.Role)
+				r.touch("User", u.Email, true)
+				synced++
+			}
+		case live.Role != u.Role:
+			res.Diff = roleDiff(live.Role, u.Role)
+			res.LiveContent = "role: " + live.Role
+			if err := r.client.SetRole(ctx, u.Email, u.Role); err != nil {
+				r.logError("User role change failed", "component", "Access", "user", u.Email, "error", err)
+				r.touch("User", u.Email, false)
+				res.Status, res.Error = "failed", err.Error()
+				failed++
+			} else {
+				r.logInfo("User role changed", "component", "Access", "user", u.Email, "from", live.Role, "to", u.Role)
+				r.touch("User", u.Email, true)
+				synced++
+			}
+		default:
+			res.LiveContent = "role: " + live.Role
+			synced++
+		}
+		resources = append(resources, res)
+	}
+
+	for _, sa := range desired.ServiceAccounts {
+		key := "ServiceAccount/" + sa.Name
+		if seen[key] {
+			r.logError("Duplicate service account found, skipping", "component", "Access", "service_account", sa.Name)
+			r.touch("ServiceAccount", sa.Name, false)
+			resources = append(resources, state.ResourceInfo{ID: sa.Name, Type: "ServiceAccount", Status: "outofsync", Role: sa.Role, Error: "Service account is defined more than once"})
+			failed++
+			continue
+		}
+		seen[key] = true
+
+		res := state.ResourceInfo{ID: sa.Name, Type: "ServiceAccount", Status: "success", Role: sa.Role}
+		live, exists := serviceAccounts[sa.Name]
+		switch {
+		case !exists:
+			r.logWarn("Service account does not exist", "component", "Access", "service_account", sa.Name)
+			res.Status = "outofsync"
+			res.Diff = roleDiff("", sa.Role)
+			res.Error = fmt.Sprintf("Service account does not exist. Create it with: omnictl serviceaccount create %s --role %s", sa.Name, sa.Role)
+		case live.Role != sa.Role && sa.Name == r.selfServiceAccount:
+			r.logError("Refusing to change the role of omni-cd's own service account", "component", "Access", "service_account", sa.Name)
+			r.touch("ServiceAccount", sa.Name, false)
+			res.Status = "failed"
+			res.Diff = roleDiff(live.Role, sa.Role)
+			res.LiveContent = "role: " + live.Role
+			res.Error = "This is the service account omni-cd uses; change its role by hand"
+			failed++
+		case live.Role != sa.Role:
+			res.Diff = roleDiff(live.Role, sa.Role)
+			res.LiveContent = "role: " + live.Role
+			if err := r.client.SetRole(ctx, live.Identity, sa.Role); err != nil {
+				r.logError("Service account role change failed", "component", "Access", "service_account", sa.Name, "error", err)
+				r.touch("ServiceAccount", sa.Name, false)
+				res.Status, res.Error = "failed", err.Error()
+				failed++
+			} else {
+				r.logInfo("Service account role changed", "component", "Access", "service_account", sa.Name, "from", live.Role, "to", sa.Role)
+				r.touch("ServiceAccount", sa.Name, true)
+				synced++
+			}
+		default:
+			res.LiveContent = "role: " + live.Role
+			synced++
+		}
+		resources = append(resources, res)
+	}
+
+	if desired.AccessPolicy != "" {
+		res := r.applyAccessPolicy(ctx, desired.AccessPolicy)
+		if res.Status == "success" {
+			synced++
+		} else {
+			failed++
+		}
+		resources = append(resources, res)
+	}
+
+	r.state.SetAccess(resources)
+	r.logInfo("Access result", "component", "Access", "synced", synced, "failed", failed)
+}
+
+// applyAccessPolicy applies the access policy when it differs from the live one.
+func (r *Reconciler) applyAccessPolicy(ctx context.Context, doc string) state.ResourceInfo {
+	res := state.ResourceInfo{ID: model.AccessPolicyID, Type: "AccessPolicy", Status: "success", FileContent: doc}
+
+	live, err := r.client.GetLiveAccessPolicy(ctx)
+	if err != nil {
+		r.logError("Failed to fetch access policy", "component", "Access", "error", err)
+		res.Status, res.Error = "failed", err.Error()
+		return res
+	}
+	res.LiveContent = live
+
+	diff, err := model.Diff(doc, live)
+	if err != nil {
+		r.logError("Failed to diff access policy", "component", "Access", "error", err)
+		res.Status, res.Error = "failed", err.Error()
+		return res
+	}
+	if diff == "" {
+		r.logDebug("Access policy up to date", "component", "Access")
+		return res
+	}
+	res.Diff = diff
+
+	// The policy may share its file with users, so apply it on its own
+	f, err := os.CreateTemp("", "omni-cd-access-policy-*.yaml")
+	if err == nil {
+		_, err = f.WriteString(doc + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		defer os.Remove(f.Name())
+	}
+	if err == nil {
+		err = r.client.Apply(ctx, f.Name())
+	}
+	if err != nil {
+		r.logError("Access policy apply failed", "component", "Access", "error", err)
+		r.touch("AccessPolicy", model.AccessPolicyID, false)
+		res.Status, res.Error = "failed", err.Error()
+		return res
+	}
+	r.logInfo("Access policy applied", "component", "Access")
+	r.touch("AccessPolicy", model.AccessPolicyID, true)
+	return res
+}
+
+// ============================================================
+// Access — Delete
+// ============================================================
+
+// DeleteAccess deletes users and service accounts that are not in Git.
+// Users are only deleted when Git defines at least one. The service account
+// omni-cd uses is never deleted, and no service account is deleted while it
+// is unknown. Service accounts with the InfraProvider
+// role belong to infrastructure providers and are left alone unless listed.
+// The access policy is never deleted; removing it from Git leaves it as is.
+func (r *Reconciler) DeleteAccess(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No access changes, skipping delete", "component", "Access")
+		return
+	}
+
+	desired, err := loadAccess(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.logDebug("Directory not found, skipping delete", "component", "Access", "path", dir)
+			return
+		}
+		// A file we cannot parse would make its users look removed
+		r.logError("Failed to parse access files, skipping delete", "component", "Access", "error", err)
+		return
+	}
+
+	accounts, err := r.client.GetAccounts(ctx)
+	if err != nil {
+		r.logError("Failed to list users and service accounts", "component", "Access", "error", err)
+		return
+	}
+
+	wantUsers := make(map[string]bool, len(desired.Users))
+	for _, u := range desired.Users {
+		wantUsers[u.Email] = true
+	}
+	wantServiceAccounts := make(map[string]bool, len(desired.ServiceAccounts))
+	for _, sa := range desired.ServiceAccounts {
+		wantServiceAccounts[sa.Name] = true
+	}
+
+	r.logInfo("Checking for users and service accounts to delete", "component", "Access")
+
+	// An empty list is far more likely a mistake than a wish to lock
+	// every person out of Omni
+	pruneUsers := len(desired.Users) > 0
+	if !pruneUsers {
+		r.logWarn("No users defined in Git, not deleting any users", "component", "Access")
+	}
+
+	deleted, failed := 0, 0
+	for _, a := range accounts {
+		if !a.ServiceAccount {
+			if wantUsers[a.Identity] || !pruneUsers {
+				continue
+			}
+			r.logWarn("User not in Git, deleting", "component", "Access", "user", a.Identity)
+			if err := r.client.DeleteUser(ctx, a.Identity); err != nil {
+				r.logError("User delete failed", "component", "Access", "user", a.Identity, "error", err)
+				r.touch("User", a.Identity, false)
+				failed++
+				continue
+			}
+			r.logInfo("User deleted", "component", "Access", "user", a.Identity)
+			r.touch("User", a.Identity, true)
+			deleted++
+			continue
+		}
+
+		name := serviceAccountName(a.Identity)
+		switch {
+		case wantServiceAccounts[name]:
+			continue
+		case r.selfServiceAccount == "":
+			r.logWarn("Own service account unknown, not deleting service accounts", "component", "Access", "service_account", name)
+			continue
+		case name == r.selfServiceAccount:
+			r.logDebug("Never deleting omni-cd's own service account", "component", "Access", "service_account", name)
+			continue
+		case a.Role == "InfraProvider":
+			r.logDebug("Infrastructure provider service account not in Git, keeping", "component", "Access", "service_account", name)
+			continue
+		}
+		r.logWarn("Service account not in Git, deleting", "component", "Access", "service_account", name)
+		if err := r.client.DeleteServiceAccount(ctx, name); err != nil {
+			r.logError("Service account delete failed", "component", "Access", "service_account", name, "error", err)
+			r.touch("ServiceAccount", name, false)
+			failed++
+			continue
+		}
+		r.logInfo("Service account deleted", "component", "Access", "service_account", name)
+		r.touch("ServiceAccount", name, true)
+		deleted++
+	}
+
+	if deleted == 0 && failed == 0 {
+		r.logInfo("No users or service accounts to delete", "component", "Access")
+	} else {
+		r.logInfo("Access delete result", "component", "Access", "deleted", deleted, "failed", failed)
+	}
+}
+
+// ============================================================
+// Access — Helpers
+// ============================================================
+
+// loadAccess parses and merges every YAML file in dir. It returns an
+// os.IsNotExist error when dir does not exist.
+func loadAccess(dir string) (*model.Access, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	files, err := findYAMLFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	access := &model.Access{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := model.ParseAccess(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		if err := access.Merge(parsed); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+	}
+	return access, nil
+}
+
+// indexAccounts splits live accounts into users by email and service
+// accounts by name.
+func indexAccounts(accounts []omni.Account) (users, serviceAccounts map[string]omni.Account) {
+	users = make(map[string]omni.Account)
+	serviceAccounts = make(map[string]omni.Account)
+	for _, a := range accounts {
+		if a.ServiceAccount {
+			serviceAccounts[serviceAccountName(a.Identity)] = a
+		} else {
+			users[a.Identity] = a
+		}
+	}
+	return users, serviceAccounts
+}
+
+// serviceAccountName strips the service account domain from an identity.
+func serviceAccountName(identity string) string {
+	name, _, _ := strings.Cut(identity, "@")
+	return name
+}
+
+// roleDiff formats a role change the same way as a resource diff.
+func roleDiff(live, desired string) string {
+	diff := "--- live\n+++ git\n"
+	if live != "" {
+		diff += "- role: " + live + "\n"
+	}
+	return diff + "+ role: " + desired
+}
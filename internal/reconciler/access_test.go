@@ -0,0 +1,184 @@
+package reconciler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const accessUsers = `kind: User
+email: alice@example.com
+role: Admin
+---
+kind: User
+email: bob@example.com
+role: Reader
+`
+
+const accessPolicy = `metadata:
+  namespace: default
+  type: AccessPolicies.omni.sidero.dev
+  id: access-policy
+spec:
+  rules:
+    - users:
+        - bob@example.com
+      clusters:
+        - prod
+      role: Operator
+`
+
+func TestApplyAccessUsers(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.fake.AddAccount("alice@example.com", "Reader", false)
+	env.write("access/users.yaml", accessUsers)
+
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"SetRole/alice@example.com", "CreateUser/bob@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	if got, want := env.fake.Accounts(), []string{"alice@example.com=Admin", "bob@example.com=Reader"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("accounts = %v, want %v", got, want)
+	}
+	if got := statusOf(env.state.GetAccess(), "alice@example.com"); got != "success" {
+		t.Errorf("alice status = %q, want success", got)
+	}
+
+	env.fake.ResetCalls()
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("unchanged users were touched again: %v", calls)
+	}
+}
+
+func TestApplyAccessServiceAccounts(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.ProtectServiceAccount("omni-cd")
+	env.fake.AddAccount("omni-cd@serviceaccount.omni.sidero.dev", "Admin", true)
+	env.fake.AddAccount("ci@serviceaccount.omni.sidero.dev", "Reader", true)
+	env.write("access/service-accounts.yaml", `kind: ServiceAccount
+name: omni-cd
+role: Operator
+---
+kind: ServiceAccount
+name: ci
+role: Operator
+---
+kind: ServiceAccount
+name: backup
+role: Reader
+`)
+
+	env.reconcile()
+	if got, want := env.fake.Accounts(), []string{
+		"ci@serviceaccount.omni.sidero.dev=Operator",
+		"omni-cd@serviceaccount.omni.sidero.dev=Admin",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("accounts = %v, want %v", got, want)
+	}
+
+	access := env.state.GetAccess()
+	if got := statusOf(access, "omni-cd"); got != "failed" {
+		t.Errorf("own service account status = %q, want failed", got)
+	}
+	if got := statusOf(access, "backup"); got != "outofsync" {
+		t.Errorf("missing service account status = %q, want outofsync", got)
+	}
+	for _, res := range access {
+		if res.ID == "backup" && !strings.Contains(res.Error, "omnictl serviceaccount create backup") {
+			t.Errorf("missing service account error = %q, want a create hint", res.Error)
+		}
+	}
+}
+
+func TestPruneAccess(t *testing.T) {
+	tests := []struct {
+		name         string
+		self         string
+		files        map[string]string
+		wantAccounts []string
+	}{
+		{
+			name:  "accounts not in git are deleted",
+			self:  "omni-cd",
+			files: map[string]string{"access/users.yaml": accessUsers},
+			wantAccounts: []string{
+				"alice@example.com=Admin",
+				"aws@serviceaccount.omni.sidero.dev=InfraProvider",
+				"bob@example.com=Reader",
+				"omni-cd@serviceaccount.omni.sidero.dev=Admin",
+			},
+		},
+		{
+			name:  "service accounts are kept while omni-cd's own is unknown",
+			files: map[string]string{"access/users.yaml": accessUsers},
+			wantAccounts: []string{
+				"alice@example.com=Admin",
+				"aws@serviceaccount.omni.sidero.dev=InfraProvider",
+				"bob@example.com=Reader",
+				"ci@serviceaccount.omni.sidero.dev=Reader",
+				"omni-cd@serviceaccount.omni.sidero.dev=Admin",
+			},
+		},
+		{
+			name:  "users are kept when git defines none",
+			self:  "omni-cd",
+			files: map[string]string{"access/policy.yaml": accessPolicy},
+			wantAccounts: []string{
+				"alice@example.com=Admin",
+				"aws@serviceaccount.omni.sidero.dev=InfraProvider",
+				"carol@example.com=Operator",
+				"omni-cd@serviceaccount.omni.sidero.dev=Admin",
+			},
+		},
+		{
+			name: "nothing is deleted without the directory",
+			self: "omni-cd",
+			wantAccounts: []string{
+				"alice@example.com=Admin",
+				"aws@serviceaccount.omni.sidero.dev=InfraProvider",
+				"carol@example.com=Operator",
+				"ci@serviceaccount.omni.sidero.dev=Reader",
+				"omni-cd@serviceaccount.omni.sidero.dev=Admin",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, true)
+			env.rec.ProtectServiceAccount(tt.self)
+			env.fake.AddAccount("alice@example.com", "Admin", false)
+			env.fake.AddAccount("carol@example.com", "Operator", false)
+			env.fake.AddAccount("omni-cd@serviceaccount.omni.sidero.dev", "Admin", true)
+			env.fake.AddAccount("ci@serviceaccount.omni.sidero.dev", "Reader", true)
+			env.fake.AddAccount("aws@serviceaccount.omni.sidero.dev", "InfraProvider", true)
+			for rel, content := range tt.files {
+				env.write(rel, content)
+			}
+
+			env.reconcile()
+			if got := env.fake.Accounts(); !reflect.DeepEqual(got, tt.wantAccounts) {
+				t.Errorf("accounts = %v, want %v", got, tt.wantAccounts)
+			}
+		})
+	}
+}
+
+func TestApplyAccessPolicy(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("access/policy.yaml", accessPolicy)
+
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"Apply/access-policy"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+
+	env.fake.ResetCalls()
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("unchanged access policy was applied again: %v", calls)
+	}
+	if got := statusOf(env.state.GetAccess(), "access-policy"); got != "success" {
+		t.Errorf("access policy status = %q, want success", got)
+	}
+}
@@ -0,0 +1,26 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+)
+
+// ============================================================
+// Cancellation
+// ============================================================
+
+// ErrStopped is returned by Reconcile when it was cancelled or drained
+// before every resource kind was reconciled.
+var ErrStopped = errors.New("reconcile stopped before it finished")
+
+// Drain makes the running reconcile finish the Omni calls in flight but
+// start no new ones, e.g. on shutdown. Cancelling its context instead also
+// aborts the calls in flight.
+func (r *Reconciler) Drain() {
+	r.draining.Store(true)
+}
+
+// stopped reports whether the reconcile must not start any more work.
+func (r *Reconciler) stopped(ctx context.Context) bool {
+	return ctx.Err() != nil || r.draining.Load()
+}
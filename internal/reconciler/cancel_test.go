@@ -0,0 +1,65 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReconcileCancelled(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.write("clusters/prod/cluster.yaml", staticCluster("prod"))
+	env.fake.AddCluster("old", staticCluster("old"), true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env.rec.SetScope(env.repo, nil)
+	err := env.rec.Reconcile(ctx, Paths{MachineClasses: env.mcDir(), Clusters: env.clustersDir()})
+	if !errors.Is(err, ErrStopped) {
+		t.Errorf("Reconcile() = %v, want ErrStopped", err)
+	}
+	if got := env.fake.Calls(); len(got) != 0 {
+		t.Errorf("calls = %v, want none", got)
+	}
+}
+
+func TestCancelStopsRollout(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.healthInterval = time.Millisecond
+	env.write("clusters/canary/cluster.yaml", waveCluster("canary", "0"))
+	env.write("clusters/prod/cluster.yaml", waveCluster("prod", "1"))
+	env.fake.AddCluster("old", staticCluster("old"), true)
+	env.fake.HangOn("ClusterTemplateSync", "canary")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	env.rec.SetScope(env.repo, nil)
+	if err := env.rec.Reconcile(ctx, Paths{MachineClasses: env.mcDir(), Clusters: env.clustersDir()}); !errors.Is(err, ErrStopped) {
+		t.Errorf("Reconcile() = %v, want ErrStopped", err)
+	}
+
+	// Neither the next wave nor the prune ran
+	if got := env.fake.Calls(); len(got) != 0 {
+		t.Errorf("calls = %v, want none", got)
+	}
+	if got := statusOf(env.state.GetClusters(), "prod"); got == "blocked" {
+		t.Error("prod is blocked, want it left for the next sync")
+	}
+}
+
+func TestDrainStartsNoNewWork(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("clusters/prod/cluster.yaml", staticCluster("prod"))
+	env.fake.AddCluster("old", staticCluster("old"), true)
+	env.rec.Drain()
+
+	env.rec.SetScope(env.repo, nil)
+	if err := env.rec.Reconcile(context.Background(), Paths{MachineClasses: env.mcDir(), Clusters: env.clustersDir()}); !errors.Is(err, ErrStopped) {
+		t.Errorf("Reconcile() = %v, want ErrStopped", err)
+	}
+	if got := env.fake.ClusterIDs(); len(got) != 1 || got[0] != "old" {
+		t.Errorf("clusters = %v, want [old]", got)
+	}
+}
@@ -0,0 +1,336 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"omni-cd/internal/model"
+	"omni-cd/internal/state"
+)
+
+// ============================================================
+// Config Patches — Apply
+// ============================================================
+
+// ApplyConfigPatches applies all config patch YAML files from the given
+// directory. Like machine classes, a patch is only applied when it differs
+// from the live resource. Files can contain multiple patches separated by ---.
+func (r *Reconciler) ApplyConfigPatches(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No config patch changes, skipping apply", "component", "ConfigPatches")
+		return
+	}
+
+	files, err := findYAMLFiles(dir)
+	if err != nil {
+		r.logWarn("Directory not found, skipping", "component", "ConfigPatches", "path", dir)
+		r.state.SetConfigPatches([]state.ResourceInfo{})
+		return
+	}
+	if len(files) == 0 {
+		r.logWarn("No YAML files found", "component", "ConfigPatches", "path", dir)
+	}
+
+	// Detect duplicate IDs across files
+	idToFiles := make(map[string][]string)
+	idCount := 0
+	for _, f := range files {
+		ids, _ := configPatchIDs(f)
+		for _, id := range ids {
+			idToFiles[id] = append(idToFiles[id], f)
+		}
+		if r.inScope(f) {
+			idCount += len(ids)
+		}
+	}
+
+	r.logInfo("Syncing config patches", "component", "ConfigPatches", "count", idCount)
+
+	duplicateIDs := make(map[string]bool)
+	repoRoot := filepath.Dir(dir)
+	var resources []state.ResourceInfo
+	applied, failed := 0, 0
+	for id, idFiles := range idToFiles {
+		if len(idFiles) > 1 {
+			duplicateIDs[id] = true
+			relFiles := make([]string, len(idFiles))
+			for i, f := range idFiles {
+				relFiles[i] = strings.TrimPrefix(f, repoRoot)
+			}
+			r.logError("Duplicate config patch ID found, skipping sync", "component", "ConfigPatches", "id", id, "files", strings.Join(relFiles, ", "))
+			r.touch("ConfigPatch", id, false)
+			resources = append(resources, state.ResourceInfo{
+				ID:     id,
+				Type:   "ConfigPatch",
+				Status: "outofsync",
+				Error:  fmt.Sprintf("Conflicting config patch files: %s", strings.Join(relFiles, ", ")),
+			})
+			failed++
+		}
+	}
+
+	// Batch fetch all live config patches once. Without them every patch
+	// looks new, which only costs an idempotent apply.
+	allLiveStates, err := r.client.GetAllLiveConfigPatches(ctx)
+	if err != nil {
+		r.logWarn("Failed to fetch live config patches", "component", "ConfigPatches", "error", err)
+	}
+
+	for _, file := range files {
+		if !r.inScope(file) {
+			continue
+		}
+
+		patches, err := loadConfigPatches(file)
+		if err != nil {
+			r.logError("Failed to parse config patch file", "component", "ConfigPatches", "file", strings.TrimPrefix(file, repoRoot), "error", err)
+			failed++
+			continue
+		}
+
+		var ids []string
+		targets := make(map[string]string, len(patches))
+		for _, p := range patches {
+			if !duplicateIDs[p.Metadata.ID] {
+				ids = append(ids, p.Metadata.ID)
+				targets[p.Metadata.ID] = p.Target()
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		fileContent := readFileContent(file)
+		result := func(id, status, diff, errMsg string) state.ResourceInfo {
+			return state.ResourceInfo{
+				ID:          id,
+				Type:        "ConfigPatch",
+				Status:      status,
+				Target:      targets[id],
+				Diff:        diff,
+				FileContent: fileContent,
+				LiveContent: allLiveStates[id],
+				Error:       errMsg,
+			}
+		}
+
+		// Never take over a patch that a cluster template or a controller
+		// owns; the next template sync would fight over it.
+		var foreign []string
+		for _, id := range ids {
+			if reason := foreignResource(allLiveStates[id]); reason != "" {
+				foreign = append(foreign, id+" ("+reason+")")
+			}
+		}
+		if len(foreign) > 0 {
+			errMsg := "Config patch already exists and is managed elsewhere: " + strings.Join(foreign, ", ")
+			r.logError("Config patch owned elsewhere, skipping file", "component", "ConfigPatches", "ids", strings.Join(foreign, ", "))
+			for _, id := range ids {
+				r.touch("ConfigPatch", id, false)
+				resources = append(resources, result(id, "failed", "", errMsg))
+			}
+			failed += len(ids)
+			continue
+		}
+
+		if err := r.client.ConfigPatchValidate(ctx, file); err != nil {
+			r.logError("Config patch validation failed", "component", "ConfigPatches", "ids", strings.Join(ids, ", "), "error", err)
+			for _, id := range ids {
+				r.touch("ConfigPatch", id, false)
+				resources = append(resources, result(id, "failed", "", err.Error()))
+			}
+			failed += len(ids)
+			continue
+		}
+
+		diffs, _, changed := r.diffDocuments("ConfigPatches", fileContent, ids, func(id string) string {
+			return allLiveStates[id]
+		})
+
+		if !changed {
+			r.logDebug("Config patches up to date", "component", "ConfigPatches", "ids", strings.Join(ids, ", "))
+			for _, id := range ids {
+				resources = append(resources, result(id, "success", "", ""))
+			}
+			applied += len(ids)
+			continue
+		}
+
+		if err := r.client.Apply(ctx, file); err != nil {
+			r.logError("Config patch apply failed", "component", "ConfigPatches", "ids", strings.Join(ids, ", "), "error", err)
+			for _, id := range ids {
+				r.touch("ConfigPatch", id, false)
+				resources = append(resources, result(id, "failed", diffs[id], err.Error()))
+			}
+			failed += len(ids)
+			continue
+		}
+
+		r.logInfo("Config patches applied", "component", "ConfigPatches", "ids", strings.Join(ids, ", "))
+		for _, id := range ids {
+			r.touch("ConfigPatch", id, true)
+			resources = append(resources, result(id, "success", diffs[id], ""))
+		}
+		applied += len(ids)
+	}
+
+	if r.scope != nil {
+		desiredIDs, err := collectConfigPatchIDs(dir)
+		if err != nil {
+			// Keep every existing entry rather than dropping ones we cannot verify
+			desiredIDs = nil
+			for _, res := range r.state.GetConfigPatches() {
+				desiredIDs = append(desiredIDs, res.ID)
+			}
+		}
+		resources = mergeScopedResources(r.state.GetConfigPatches(), resources, desiredIDs)
+	}
+
+	r.state.SetConfigPatches(resources)
+	r.logInfo("Config patches result", "component", "ConfigPatches", "synced", applied, "failed", failed)
+}
+
+// ============================================================
+// Config Patches — Delete
+// ============================================================
+
+// DeleteConfigPatches deletes config patches from Omni that no longer exist
+// in Git. Patches created by cluster templates or by Omni controllers are
+// never deleted, and nothing is deleted when the directory does not exist.
+// Deletions go through the prune guard; patches it holds back stay in the
+// state as "pendingdelete".
+func (r *Reconciler) DeleteConfigPatches(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No config patch changes, skipping delete", "component", "ConfigPatches")
+		return
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		r.logDebug("Directory not found, skipping delete", "component", "ConfigPatches", "path", dir)
+		return
+	}
+
+	desiredIDs, err := collectConfigPatchIDs(dir)
+	if err != nil {
+		// A file we cannot parse would make its patches look removed
+		r.logError("Failed to parse config patch files, skipping delete", "component", "ConfigPatches", "error", err)
+		return
+	}
+
+	live, err := r.client.GetAllLiveConfigPatches(ctx)
+	if err != nil {
+		r.logError("Failed to list config patches", "component", "ConfigPatches", "error", err)
+		return
+	}
+
+	r.logInfo("Checking for config patches to delete", "component", "ConfigPatches")
+
+	ids := make([]string, 0, len(live))
+	for id := range live {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var candidates []string
+	managed := 0
+	for _, id := range ids {
+		if foreignResource(live[id]) != "" {
+			continue
+		}
+		managed++
+		if !contains(desiredIDs, id) {
+			candidates = append(candidates, id)
+		}
+	}
+
+	allowed, held, reason := r.guardPrune("ConfigPatch", candidates, managed)
+	var pending []state.ResourceInfo
+	for _, id := range held {
+		r.logWarn("Config patch not in Git, deletion pending confirmation", "component", "ConfigPatches", "id", id)
+		pending = append(pending, state.ResourceInfo{
+			ID:     id,
+			Type:   "ConfigPatch",
+			Status: "pendingdelete",
+			Diff:   reason,
+		})
+	}
+
+	deleted, failed := 0, 0
+	for _, id := range allowed {
+		r.logWarn("Config patch not in Git, deleting", "component", "ConfigPatches", "id", id)
+		if err := r.client.DeleteConfigPatch(ctx, id); err != nil {
+			r.logError("Config patch delete failed", "component", "ConfigPatches", "id", id, "error", err)
+			r.touch("ConfigPatch", id, false)
+			failed++
+			continue
+		}
+		r.logInfo("Config patch deleted", "component", "ConfigPatches", "id", id)
+		r.touch("ConfigPatch", id, true)
+		deleted++
+	}
+
+	// Replace the entries of patches that are no longer in Git, including
+	// the pending ones of the previous run
+	var resources []state.ResourceInfo
+	for _, res := range r.state.GetConfigPatches() {
+		if contains(desiredIDs, res.ID) {
+			resources = append(resources, res)
+		}
+	}
+	r.state.SetConfigPatches(append(resources, pending...))
+
+	if deleted == 0 && failed == 0 && len(pending) == 0 {
+		r.logInfo("No config patches to delete", "component", "ConfigPatches")
+	} else {
+		r.logInfo("Config patch delete result", "component", "ConfigPatches", "deleted", deleted, "failed", failed, "pending", len(pending))
+	}
+}
+
+// ============================================================
+// Config Patches — Helpers
+// ============================================================
+
+// loadConfigPatches parses every config patch in a YAML file.
+func loadConfigPatches(file string) ([]model.ConfigPatch, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return model.ParseConfigPatches(data)
+}
+
+// configPatchIDs returns the ids of all config patches defined in a YAML file.
+func configPatchIDs(file string) ([]string, error) {
+	patches, err := loadConfigPatches(file)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(patches))
+	for i, p := range patches {
+		ids[i] = p.Metadata.ID
+	}
+	return ids, nil
+}
+
+// collectConfigPatchIDs returns all desired config patch IDs from the Git repo.
+// It fails if any file cannot be parsed.
+func collectConfigPatchIDs(dir string) ([]string, error) {
+	files, err := findYAMLFiles(dir)
+	if err != nil {
+		return nil, nil
+	}
+
+	var ids []string
+	for _, f := range files {
+		fileIDs, err := configPatchIDs(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		ids = append(ids, fileIDs...)
+	}
+	return ids, nil
+}
@@ -0,0 +1,187 @@
+package reconciler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"omni-cd/internal/state"
+)
+
+const patchProdNTP = `metadata:
+  namespace: default
+  type: ConfigPatches.omni.sidero.dev
+  id: 400-prod-ntp
+  labels:
+    omni.sidero.dev/cluster: prod
+spec:
+  data: |
+    machine:
+      time:
+        servers:
+          - time.cloudflare.com
+`
+
+func configPatch(t *testing.T, resources []state.ResourceInfo, id string) state.ResourceInfo {
+	t.Helper()
+	for _, r := range resources {
+		if r.ID == id {
+			return r
+		}
+	}
+	t.Fatalf("config patch %s not in state", id)
+	return state.ResourceInfo{}
+}
+
+func TestApplyConfigPatches(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("config-patches/prod.yaml", patchProdNTP)
+
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"Apply/400-prod-ntp"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	p := configPatch(t, env.state.GetConfigPatches(), "400-prod-ntp")
+	if p.Status != "success" || p.Target != "cluster prod" {
+		t.Errorf("status = %q, target = %q, want success for cluster prod", p.Status, p.Target)
+	}
+
+	env.fake.ResetCalls()
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("unchanged config patch was applied again: %v", calls)
+	}
+
+	env.write("config-patches/prod.yaml", strings.Replace(patchProdNTP, "time.cloudflare.com", "pool.ntp.org", 1))
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"Apply/400-prod-ntp"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if diff := configPatch(t, env.state.GetConfigPatches(), "400-prod-ntp").Diff; !strings.Contains(diff, "pool.ntp.org") {
+		t.Errorf("diff does not show the change: %q", diff)
+	}
+}
+
+func TestApplyConfigPatchesValidationFailure(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("config-patches/prod.yaml", patchProdNTP+"          - [broken\n")
+
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("invalid config patch was applied: %v", calls)
+	}
+	if p := configPatch(t, env.state.GetConfigPatches(), "400-prod-ntp"); p.Status != "failed" || p.Error == "" {
+		t.Errorf("status = %q, error = %q, want failed with an error", p.Status, p.Error)
+	}
+}
+
+func TestPruneConfigPatches(t *testing.T) {
+	foreign := map[string]string{
+		"template": `metadata:
+  type: ConfigPatches.omni.sidero.dev
+  id: 400-prod-template
+  annotations:
+    omni.sidero.dev/managed-by-cluster-templates: ""
+spec:
+  data: "{}"
+`,
+		"controller": `metadata:
+  type: ConfigPatches.omni.sidero.dev
+  id: 000-system
+  owner: ClusterController
+spec:
+  data: "{}"
+`,
+	}
+	manual := `metadata:
+  type: ConfigPatches.omni.sidero.dev
+  id: 500-manual
+spec:
+  data: "{}"
+`
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantIDs []string
+	}{
+		{
+			name:    "patches not in git are deleted, foreign ones kept",
+			files:   map[string]string{"config-patches/prod.yaml": patchProdNTP},
+			wantIDs: []string{"000-system", "400-prod-ntp", "400-prod-template"},
+		},
+		{
+			name:    "nothing is deleted without the directory",
+			wantIDs: []string{"000-system", "400-prod-template", "500-manual"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, true)
+			for _, doc := range []string{foreign["template"], foreign["controller"], manual} {
+				if err := env.fake.AddConfigPatch(doc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for rel, content := range tt.files {
+				env.write(rel, content)
+			}
+
+			env.reconcile()
+			if got := env.fake.ConfigPatchIDs(); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("config patches in Omni = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestApplyConfigPatchesRefusesTemplatePatch(t *testing.T) {
+	env := newTestEnv(t, true)
+	if err := env.fake.AddConfigPatch(`metadata:
+  type: ConfigPatches.omni.sidero.dev
+  id: 400-prod-ntp
+  annotations:
+    omni.sidero.dev/managed-by-cluster-templates: ""
+spec:
+  data: "{}"
+`); err != nil {
+		t.Fatal(err)
+	}
+	env.write("config-patches/prod.yaml", patchProdNTP)
+
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("template-managed config patch was overwritten: %v", calls)
+	}
+	if p := configPatch(t, env.state.GetConfigPatches(), "400-prod-ntp"); p.Status != "failed" {
+		t.Errorf("status = %q, want failed", p.Status)
+	}
+}
+
+func TestPruneConfigPatchesGuarded(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.SetPruneGuard(PruneGuard{MaxDeletions: 1})
+	for _, id := range []string{"500-a", "500-b"} {
+		if err := env.fake.AddConfigPatch("metadata:\n  type: ConfigPatches.omni.sidero.dev\n  id: " + id + "\nspec:\n  data: \"{}\"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.write("config-patches/prod.yaml", patchProdNTP)
+	env.reconcile()
+
+	// Two patches missing from Git exceed the limit of one deletion
+	if got, want := env.fake.ConfigPatchIDs(), []string{"400-prod-ntp", "500-a", "500-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("config patches in Omni = %v, want %v", got, want)
+	}
+	if got := statusOf(env.state.GetConfigPatches(), "500-a"); got != "pendingdelete" {
+		t.Errorf("status of 500-a = %q, want pendingdelete", got)
+	}
+
+	if !env.state.ApproveDeletion("ConfigPatch", "500-a") {
+		t.Fatal("pending config patch could not be approved")
+	}
+	env.reconcile()
+	if got, want := env.fake.ConfigPatchIDs(), []string{"400-prod-ntp", "500-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("config patches in Omni = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,197 @@
+package reconciler
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"omni-cd/internal/model"
+	"omni-cd/internal/state"
+)
+
+// ============================================================
+// Force Sync
+// ============================================================
+
+// Force selects the resources a targeted reconcile syncs, by ID or by a
+// label selector on the labels they carry in Git. Only the selected
+// machine classes and clusters are validated, diffed and applied; nothing
+// else is applied or pruned and the state of every other resource is left
+// as it is. Selected resources are synced even without changes or with a
+// manual sync policy.
+type Force struct {
+	Clusters              []string
+	MachineClasses        []string
+	ClusterSelectors      []string
+	MachineClassSelectors []string
+	// OverrideWindow lets the forced clusters be synced and deleted outside
+	// their sync windows.
+	OverrideWindow bool
+}
+
+// targetsClusters reports whether any cluster is selected.
+func (f Force) targetsClusters() bool {
+	return len(f.Clusters) > 0 || len(f.ClusterSelectors) > 0
+}
+
+// targetsMachineClasses reports whether any machine class is selected.
+func (f Force) targetsMachineClasses() bool {
+	return len(f.MachineClasses) > 0 || len(f.MachineClassSelectors) > 0
+}
+
+// targeted reports whether the reconcile is limited to selected resources.
+func (f Force) targeted() bool {
+	return f.targetsClusters() || f.targetsMachineClasses()
+}
+
+// SetForce sets the resources the following reconciles are limited to. The
+// zero Force reconciles everything and syncs nothing by force.
+func (r *Reconciler) SetForce(f Force) {
+	r.force = f
+}
+
+// reconcileTargets applies the selected machine classes and clusters,
+// machine classes first. No other kind is applied and nothing is pruned.
+func (r *Reconciler) reconcileTargets(ctx context.Context, p Paths) error {
+	for _, kind := range kindGraph().order(false) {
+		if r.stopped(ctx) {
+			return ErrStopped
+		}
+		switch {
+		case kind == kindMachineClasses && r.force.targetsMachineClasses():
+			r.ApplyMachineClasses(ctx, p.MachineClasses)
+		case kind == kindClusters && r.force.targetsClusters():
+			r.ApplyClusters(ctx, p.Clusters)
+		}
+	}
+	return nil
+}
+
+// forcedClusters returns the set of clusters selected by ID.
+func (r *Reconciler) forcedClusters() map[string]bool {
+	return toSet(r.force.Clusters)
+}
+
+// selectedByLabels reports whether labels match any of the selectors.
+// Selectors are validated before they are queued, so a malformed one
+// simply selects nothing.
+func selectedByLabels(selectors []string, labels map[string]string) bool {
+	for _, sel := range selectors {
+		if ok, err := model.MatchSelector(sel, labels); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// machineClassSelected reports whether a machine class from Git is selected.
+func (r *Reconciler) machineClassSelected(mc model.MachineClass) bool {
+	return contains(r.force.MachineClasses, mc.Metadata.ID) || selectedByLabels(r.force.MachineClassSelectors, mc.Metadata.Labels)
+}
+
+// machineClassIDSelected reports whether the machine class id is selected by
+// any of its definitions in files, for classes defined more than once.
+func (r *Reconciler) machineClassIDSelected(id string, files []string) bool {
+	if contains(r.force.MachineClasses, id) {
+		return true
+	}
+	for _, f := range files {
+		classes, _ := loadMachineClasses(f)
+		for _, mc := range classes {
+			if mc.Metadata.ID == id && r.machineClassSelected(mc) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// templateSelected reports whether the cluster template in file matches a
+// cluster selector.
+func (r *Reconciler) templateSelected(file string) bool {
+	if len(r.force.ClusterSelectors) == 0 {
+		return false
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	tmpl, err := model.ParseTemplate(data)
+	if err != nil || tmpl.Cluster == nil {
+		return false
+	}
+	return selectedByLabels(r.force.ClusterSelectors, tmpl.Cluster.Labels)
+}
+
+// deleteForcedClusters deletes the forced clusters in ids that are no longer
+// in Git, as long as omni-cd manages them. Like DeleteClusters, it keeps the
+// clusters whose policy or sync windows say so, and clusters it cannot
+// export. reason says why they are considered gone. The deletions go
+// through the prune guard like those of a full sync; the clusters it holds
+// back are added to the pending deletions. It returns the state entries of
+// the clusters that were not deleted, for collectUnmanagedClusters.
+func (r *Reconciler) deleteForcedClusters(ctx context.Context, dir string, ids []string, reason string) []state.ResourceInfo {
+	var (
+		kept       []state.ResourceInfo
+		candidates []string
+	)
+	for _, id := range ids {
+		if !r.client.IsClusterTemplateManaged(ctx, id) {
+			continue
+		}
+		live, err := r.client.GetLiveCluster(ctx, id)
+		if res := r.keepRemovedCluster(id, live, err == nil, r.force.OverrideWindow); res != nil {
+			kept = append(kept, *res)
+			continue
+		}
+		candidates = append(candidates, id)
+	}
+	if len(candidates) == 0 {
+		return kept
+	}
+
+	// The limits count the clusters in Git as well as the removed ones
+	desiredIDs, _ := collectClusterIDs(dir)
+	allowed, held, holdReason := r.checkPrune("Cluster", candidates, len(desiredIDs)+len(candidates))
+	r.state.AddPendingDeletions("Cluster", held)
+
+	for _, id := range held {
+		r.logWarn("Cluster not in Git"+reason+", deletion pending confirmation", "component", "Clusters", "cluster", id)
+		kept = append(kept, state.ResourceInfo{
+			ID:     id,
+			Type:   "Cluster",
+			Status: "pendingdelete",
+			Diff:   holdReason,
+		})
+	}
+	for _, id := range allowed {
+		r.logWarn("Cluster not in Git"+reason+", deleting", "component", "Clusters", "cluster", id)
+		if err := r.client.DeleteCluster(ctx, id); err != nil {
+			r.logError("Cluster delete failed", "component", "Clusters", "cluster", id, "error", err)
+			r.touch("Cluster", id, false)
+			continue
+		}
+		r.logInfo("Cluster deleted", "component", "Clusters", "cluster", id)
+		r.touch("Cluster", id, true)
+	}
+	return kept
+}
+
+// toSet returns the values as a set.
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// sortedKeys returns the keys of a set in order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
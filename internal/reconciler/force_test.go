@@ -0,0 +1,88 @@
+package reconciler
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"omni-cd/internal/state"
+)
+
+// labelledCluster returns a cluster template whose Cluster document carries
+// an env label.
+func labelledCluster(name, env string) string {
+	return "kind: Cluster\nname: " + name + "\nlabels:\n  env: " + env + "\n---\nkind: ControlPlane\nmachines: []\n"
+}
+
+// entryOf returns the state entry of a resource.
+func entryOf(resources []state.ResourceInfo, id string) state.ResourceInfo {
+	for _, r := range resources {
+		if r.ID == id {
+			return r
+		}
+	}
+	return state.ResourceInfo{}
+}
+
+func TestTargetedReconcile(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.write("machine-classes/control-plane.yaml", mcControlPlane)
+	env.write("clusters/prod-a/cluster.yaml", labelledCluster("prod-a", "prod"))
+	env.write("clusters/prod-b/cluster.yaml", labelledCluster("prod-b", "prod"))
+	env.write("clusters/dev/cluster.yaml", labelledCluster("dev", "dev"))
+	env.write("clusters/old/cluster.yaml", labelledCluster("old", "dev"))
+	env.reconcile()
+
+	// Change everything in Git, and remove a cluster
+	env.write("machine-classes/workers.yaml", strings.Replace(mcWorkers, "role = worker", "role = worker, zone = a", 1))
+	env.write("machine-classes/control-plane.yaml", strings.Replace(mcControlPlane, "role = controlplane", "role = cp", 1))
+	for name, envLabel := range map[string]string{"prod-a": "prod", "prod-b": "prod", "dev": "dev"} {
+		env.write("clusters/"+name+"/cluster.yaml", labelledCluster(name, envLabel)+"---\nkind: Workers\nmachines: []\n")
+	}
+	env.remove("clusters/old")
+	controlPlane := entryOf(env.state.GetMachineClasses(), "control-plane")
+	dev := entryOf(env.state.GetClusters(), "dev")
+	env.fake.ResetCalls()
+
+	env.rec.SetForce(Force{MachineClasses: []string{"workers"}, ClusterSelectors: []string{"env = prod"}})
+	env.reconcile()
+
+	calls := env.fake.Calls()
+	sort.Strings(calls)
+	if want := []string{"Apply/workers", "ClusterTemplateSync/prod-a", "ClusterTemplateSync/prod-b"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if got := entryOf(env.state.GetMachineClasses(), "control-plane"); !reflect.DeepEqual(got, controlPlane) {
+		t.Errorf("control-plane state changed:\n got %+v\nwant %+v", got, controlPlane)
+	}
+	if got := entryOf(env.state.GetClusters(), "dev"); !reflect.DeepEqual(got, dev) {
+		t.Errorf("dev state changed:\n got %+v\nwant %+v", got, dev)
+	}
+	if got := statusOf(env.state.GetClusters(), "old"); got == "" {
+		t.Error("cluster removed from Git was dropped from state by a targeted reconcile")
+	}
+}
+
+func TestTargetedDuplicateMachineClass(t *testing.T) {
+	env := newTestEnv(t, true)
+	labelled := strings.Replace(mcWorkers, "  id: workers\n", "  id: workers\n  labels:\n    tier: a\n", 1)
+	env.write("machine-classes/workers.yaml", labelled)
+	env.reconcile()
+
+	// A conflict on a class picked by a selector is reported like one on a
+	// class picked by its ID
+	env.write("machine-classes/copy.yaml", mcWorkers)
+	env.fake.ResetCalls()
+	env.rec.SetForce(Force{MachineClassSelectors: []string{"tier = a"}})
+	env.reconcile()
+
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+	workers := entryOf(env.state.GetMachineClasses(), "workers")
+	if workers.Status != "outofsync" || !strings.Contains(workers.Error, "Conflicting") {
+		t.Errorf("workers = status %q, error %q; want a conflict", workers.Status, workers.Error)
+	}
+}
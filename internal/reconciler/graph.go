@@ -0,0 +1,285 @@
+package reconciler
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+
+	"omni-cd/internal/model"
+)
+
+// ============================================================
+// Dependency Graph
+// ============================================================
+
+// dependencyGraph records which nodes depend on which. Nodes are resource
+// kinds ("Cluster") or single resources ("Cluster/prod").
+type dependencyGraph struct {
+	nodes []string            // In insertion order, used to break ties
+	deps  map[string][]string // Node -> nodes it depends on
+}
+
+// newDependencyGraph returns a graph holding the given nodes.
+func newDependencyGraph(nodes ...string) *dependencyGraph {
+	g := &dependencyGraph{deps: make(map[string][]string)}
+	for _, n := range nodes {
+		g.addNode(n)
+	}
+	return g
+}
+
+// addNode adds a node without dependencies unless it already exists.
+func (g *dependencyGraph) addNode(n string) {
+	if _, ok := g.deps[n]; !ok {
+		g.nodes = append(g.nodes, n)
+		g.deps[n] = nil
+	}
+}
+
+// addEdge records that from depends on to.
+func (g *dependencyGraph) addEdge(from, to string) {
+	g.addNode(from)
+	g.addNode(to)
+	if !contains(g.deps[from], to) {
+		g.deps[from] = append(g.deps[from], to)
+	}
+}
+
+// dependencies returns the nodes n depends on, sorted.
+func (g *dependencyGraph) dependencies(n string) []string {
+	out := append([]string(nil), g.deps[n]...)
+	sort.Strings(out)
+	return out
+}
+
+// dependents returns the nodes that depend on n, sorted.
+func (g *dependencyGraph) dependents(n string) []string {
+	var out []string
+	for _, from := range g.nodes {
+		if contains(g.deps[from], n) {
+			out = append(out, from)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// order returns every node with its dependencies before it, or with
+// reverse set, its dependents before it. Nodes that are ready at the same
+// time keep their insertion order. Nodes on a cycle, which the graphs
+// omni-cd builds cannot have, are appended at the end.
+func (g *dependencyGraph) order(reverse bool) []string {
+	// waiting[n] counts the nodes that must come before n
+	waiting := make(map[string]int, len(g.nodes))
+	for _, from := range g.nodes {
+		for _, to := range g.deps[from] {
+			if reverse {
+				waiting[to]++
+			} else {
+				waiting[from]++
+			}
+		}
+	}
+
+	var out []string
+	done := make(map[string]bool, len(g.nodes))
+	for len(out) < len(g.nodes) {
+		next := ""
+		for _, n := range g.nodes {
+			if !done[n] && waiting[n] == 0 {
+				next = n
+				break
+			}
+		}
+		if next == "" {
+			for _, n := range g.nodes {
+				if !done[n] {
+					out = append(out, n)
+				}
+			}
+			break
+		}
+		done[next] = true
+		out = append(out, next)
+		if reverse {
+			for _, to := range g.deps[next] {
+				waiting[to]--
+			}
+		} else {
+			for _, from := range g.dependents(next) {
+				waiting[from]--
+			}
+		}
+	}
+	return out
+}
+
+// templateGraph builds the dependencies of clusters on the machine classes
+// they allocate from, taken from the cluster templates in dir and from the
+// live clusters (cluster ID -> exported template).
+func templateGraph(dir string, live map[string]string) *dependencyGraph {
+	g := newDependencyGraph()
+	add := func(data []byte) {
+		tmpl, err := model.ParseTemplate(data)
+		if err != nil || tmpl.ClusterName() == "" {
+			return
+		}
+		cluster := "Cluster/" + tmpl.ClusterName()
+		g.addNode(cluster)
+		sets := append([]model.MachineSet(nil), tmpl.Workers...)
+		if tmpl.ControlPlane != nil {
+			sets = append(sets, *tmpl.ControlPlane)
+		}
+		for _, set := range sets {
+			if mc := set.MachineClassName(); mc != "" {
+				g.addEdge(cluster, "MachineClass/"+mc)
+			}
+		}
+	}
+
+	templates, _ := findClusterTemplates(dir)
+	for _, file := range templates {
+		if data, err := os.ReadFile(file); err == nil {
+			add(data)
+		}
+	}
+	ids := make([]string, 0, len(live))
+	for id := range live {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		add([]byte(live[id]))
+	}
+	return g
+}
+
+// nodeIDs strips the "Kind/" prefix from graph nodes.
+func nodeIDs(nodes []string) []string {
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		_, ids[i], _ = strings.Cut(n, "/")
+	}
+	return ids
+}
+
+// ============================================================
+// Ordered Reconcile
+// ============================================================
+
+// Resource kinds, as used in the kind dependency graph.
+const (
+	kindMachines       = "Machines"
+	kindMachineClasses = "MachineClasses"
+	kindClusters       = "Clusters"
+	kindConfigPatches  = "ConfigPatches"
+	kindAccess         = "Access"
+)
+
+// kindGraph returns how the resource kinds depend on each other. Machine
+// classes select machines by the labels and request sets under Machines,
+// clusters allocate from machine classes, and config patches target
+// clusters and machines. Access stands alone.
+func kindGraph() *dependencyGraph {
+	g := newDependencyGraph(kindMachines, kindMachineClasses, kindClusters, kindConfigPatches, kindAccess)
+	g.addEdge(kindMachineClasses, kindMachines)
+	g.addEdge(kindClusters, kindMachineClasses)
+	g.addEdge(kindConfigPatches, kindClusters)
+	g.addEdge(kindConfigPatches, kindMachines)
+	return g
+}
+
+// Paths holds the resource directories of a checkout. Kinds whose path is
+// empty are not managed.
+type Paths struct {
+	Machines       string
+	MachineClasses string
+	Clusters       string
+	ConfigPatches  string
+	Access         string
+}
+
+// Reconcile applies every managed resource kind with its dependencies
+// first, then prunes them with their dependents first, and finally checks
+// whether the machine classes can supply their clusters.
+// Once ctx is done or the reconciler is drained, the remaining kinds are
+// skipped and ErrStopped is returned; in particular nothing is pruned after
+// an apply phase that was cut short.
+// A targeted reconcile (see SetForce) only applies the selected resources.
+func (r *Reconciler) Reconcile(ctx context.Context, p Paths) error {
+	if r.force.targeted() {
+		return r.reconcileTargets(ctx, p)
+	}
+	g := kindGraph()
+	for _, kind := range g.order(false) {
+		if r.stopped(ctx) {
+			return ErrStopped
+		}
+		r.applyKind(ctx, kind, p)
+	}
+	for _, kind := range g.order(true) {
+		if r.stopped(ctx) {
+			return ErrStopped
+		}
+		r.pruneKind(ctx, kind, p)
+	}
+	if r.stopped(ctx) {
+		return ErrStopped
+	}
+	r.CheckMachineCapacity(ctx, p.MachineClasses, p.Clusters)
+	return nil
+}
+
+// applyKind runs the apply phase of one resource kind.
+func (r *Reconciler) applyKind(ctx context.Context, kind string, p Paths) {
+	switch kind {
+	case kindMachines:
+		if p.Machines != "" {
+			r.ApplyMachineResources(ctx, p.Machines)
+		}
+	case kindMachineClasses:
+		r.ApplyMachineClasses(ctx, p.MachineClasses)
+	case kindClusters:
+		// Only if enabled; force syncs go through reconcileTargets
+		if r.state.GetClustersEnabled() {
+			r.ApplyClusters(ctx, p.Clusters)
+		} else {
+			r.DiffClusters(ctx, p.Clusters)
+		}
+	case kindConfigPatches:
+		if p.ConfigPatches != "" {
+			r.ApplyConfigPatches(ctx, p.ConfigPatches)
+		}
+	case kindAccess:
+		if p.Access != "" {
+			r.ApplyAccess(ctx, p.Access)
+		}
+	}
+}
+
+// pruneKind runs the delete phase of one resource kind.
+func (r *Reconciler) pruneKind(ctx context.Context, kind string, p Paths) {
+	switch kind {
+	case kindMachines:
+		if p.Machines != "" {
+			r.DeleteMachineResources(ctx, p.Machines)
+		}
+	case kindMachineClasses:
+		r.DeleteMachineClasses(ctx, p.MachineClasses, p.Clusters)
+	case kindClusters:
+		if r.state.GetClustersEnabled() {
+			r.DeleteClusters(ctx, p.Clusters)
+		} else {
+			r.logInfo("Cluster sync disabled, skipping cluster delete", "component", "Clusters")
+		}
+	case kindConfigPatches:
+		if p.ConfigPatches != "" {
+			r.DeleteConfigPatches(ctx, p.ConfigPatches)
+		}
+	case kindAccess:
+		if p.Access != "" {
+			r.DeleteAccess(ctx, p.Access)
+		}
+	}
+}
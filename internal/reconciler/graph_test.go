@@ -0,0 +1,113 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"omni-cd/internal/state"
+)
+
+func TestKindOrder(t *testing.T) {
+	g := kindGraph()
+	if got, want := g.order(false), []string{"Machines", "MachineClasses", "Clusters", "ConfigPatches", "Access"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("apply order = %v, want %v", got, want)
+	}
+	if got, want := g.order(true), []string{"ConfigPatches", "Clusters", "MachineClasses", "Machines", "Access"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("prune order = %v, want %v", got, want)
+	}
+}
+
+func TestPruneRefusesReferencedMachineClass(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(env *testEnv)
+		release       func(env *testEnv)
+		wantBlockedBy []string
+	}{
+		{
+			name: "referenced by a template in git",
+			setup: func(env *testEnv) {
+				env.write("clusters/prod/cluster.yaml", clusterProd)
+			},
+			release: func(env *testEnv) {
+				env.remove("clusters/prod")
+			},
+			wantBlockedBy: []string{"prod"},
+		},
+		{
+			name: "referenced by a live cluster only",
+			setup: func(env *testEnv) {
+				env.fake.AddCluster("manual", strings.Replace(clusterProd, "name: prod", "name: manual", 1), false)
+			},
+			release: func(env *testEnv) {
+				if err := env.fake.DeleteCluster(context.Background(), "manual"); err != nil {
+					env.t.Fatal(err)
+				}
+			},
+			wantBlockedBy: []string{"manual"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, true)
+			env.write("machine-classes/workers.yaml", mcWorkers)
+			env.write("machine-classes/control-plane.yaml", mcControlPlane)
+			tt.setup(env)
+			env.reconcile()
+
+			env.remove("machine-classes/workers.yaml")
+			env.fake.ResetCalls()
+			env.reconcile()
+
+			if calls := env.fake.Calls(); len(calls) != 0 {
+				t.Errorf("referenced machine class was deleted: %v", calls)
+			}
+			var workers *state.ResourceInfo
+			for _, mc := range env.state.GetMachineClasses() {
+				if mc.ID == "workers" {
+					workers = &mc
+				}
+			}
+			if workers == nil || workers.Status != "blocked" || !reflect.DeepEqual(workers.BlockedBy, tt.wantBlockedBy) {
+				t.Errorf("workers = %+v, want blocked by %v", workers, tt.wantBlockedBy)
+			}
+
+			// Once the cluster is gone the class is pruned and the entry cleared
+			tt.release(env)
+			env.reconcile()
+			if got, want := env.fake.MachineClassIDs(), []string{"control-plane"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("machine classes in Omni = %v, want %v", got, want)
+			}
+			if got := statusOf(env.state.GetMachineClasses(), "workers"); got != "" {
+				t.Errorf("pruned machine class still in state with status %q", got)
+			}
+		})
+	}
+}
+
+func TestClusterBlockedByFailedMachineClass(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.write("machine-classes/control-plane.yaml", mcControlPlane)
+	env.write("clusters/prod/cluster.yaml", clusterProd)
+	env.fake.FailOn("Apply", "workers", errors.New("invalid selector"))
+
+	env.reconcile()
+	if got := env.fake.ClusterIDs(); len(got) != 0 {
+		t.Errorf("cluster synced although its machine class failed: %v", got)
+	}
+	for _, c := range env.state.GetClusters() {
+		if c.ID == "prod" && (c.Status != "blocked" || !reflect.DeepEqual(c.BlockedBy, []string{"workers"})) {
+			t.Errorf("prod = status %q, blocked by %v; want blocked by [workers]", c.Status, c.BlockedBy)
+		}
+	}
+
+	env.fake.FailOn("Apply", "workers", nil)
+	env.reconcile()
+	if got, want := env.fake.ClusterIDs(), []string{"prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("clusters in Omni = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package reconciler
+
+import (
+	"context"
+	"time"
+
+	"omni-cd/internal/omni"
+)
+
+// ============================================================
+// Concurrency and Timeouts
+// ============================================================
+
+// defaultMaxParallel is how many clusters are synced or deleted at the same
+// time when no limit is configured.
+const defaultMaxParallel = 4
+
+// Limits bound the cluster work a reconcile does at once, so a large fleet
+// does not start hundreds of omnictl processes and a single cluster that
+// never answers does not hold up the others.
+type Limits struct {
+	// MaxParallel is how many clusters are synced or deleted at the same time.
+	MaxParallel int
+	// ClusterTimeout is how long validating, diffing and syncing (or
+	// deleting) a single cluster may take. Zero means no limit.
+	ClusterTimeout time.Duration
+}
+
+// SetLimits sets the concurrency and timeout limits for cluster work.
+func (r *Reconciler) SetLimits(l Limits) {
+	r.limits = l
+}
+
+// workers returns a semaphore admitting MaxParallel cluster jobs at a time.
+func (r *Reconciler) workers() chan struct{} {
+	n := r.limits.MaxParallel
+	if n <= 0 {
+		n = defaultMaxParallel
+	}
+	return make(chan struct{}, n)
+}
+
+// clusterContext returns the context a single cluster's work runs under.
+func (r *Reconciler) clusterContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.limits.ClusterTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.limits.ClusterTimeout)
+}
+
+// clusterFailure returns the status and error message for a failed cluster
+// operation. Operations that ran out of time are reported as "timedout" so
+// they can be told apart from clusters Omni rejected.
+func clusterFailure(err error) (status, msg string) {
+	if omni.IsTimeout(err) {
+		return "timedout", "Timed out: " + err.Error()
+	}
+	return "failed", err.Error()
+}
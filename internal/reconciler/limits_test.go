@@ -0,0 +1,46 @@
+package reconciler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHungClusterTimesOut(t *testing.T) {
+	env := newTestEnv(t, true)
+	// One worker: the other cluster only syncs once the hung one gives up
+	env.rec.SetLimits(Limits{MaxParallel: 1, ClusterTimeout: 50 * time.Millisecond})
+	env.write("clusters/hung/cluster.yaml", staticCluster("hung"))
+	env.write("clusters/prod/cluster.yaml", staticCluster("prod"))
+	env.fake.HangOn("ClusterTemplateSync", "hung")
+	env.reconcile()
+
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	clusters := env.state.GetClusters()
+	if got := statusOf(clusters, "hung"); got != "timedout" {
+		t.Errorf("hung status = %q, want timedout", got)
+	}
+	if got := statusOf(clusters, "prod"); got != "success" {
+		t.Errorf("prod status = %q, want success", got)
+	}
+	if _, failed := env.rec.Touched(); !failed {
+		t.Error("Touched() reports no failure for the timed out cluster")
+	}
+}
+
+func TestHungClusterDeleteTimesOut(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.SetLimits(Limits{ClusterTimeout: 50 * time.Millisecond})
+	env.fake.AddCluster("old", staticCluster("old"), true)
+	env.fake.HangOn("DeleteCluster", "old")
+	env.reconcile()
+
+	if got := statusOf(env.state.GetClusters(), "old"); got != "timedout" {
+		t.Errorf("old status = %q, want timedout", got)
+	}
+	if got, want := env.fake.ClusterIDs(), []string{"old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("clusters = %v, want %v", got, want)
+	}
+}
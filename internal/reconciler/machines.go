@@ -0,0 +1,515 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"omni-cd/internal/model"
+	"omni-cd/internal/omni"
+	"omni-cd/internal/state"
+)
+
+// ============================================================
+// Machines — Apply
+// ============================================================
+
+// ApplyMachineResources applies the MachineLabels and MachineRequestSets
+// resources from the YAML files in the given directory. A resource is only
+// applied when it differs from the live one. Files can contain multiple
+// resources separated by ---.
+func (r *Reconciler) ApplyMachineResources(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No machine changes, skipping apply", "component", "Machines")
+		return
+	}
+
+	files, err := findYAMLFiles(dir)
+	if err != nil {
+		r.logWarn("Directory not found, skipping", "component", "Machines", "path", dir)
+		r.state.SetMachines([]state.ResourceInfo{})
+		return
+	}
+	if len(files) == 0 {
+		r.logWarn("No YAML files found", "component", "Machines", "path", dir)
+	}
+
+	// Detect duplicate IDs across files
+	idToFiles := make(map[string][]string)
+	idCount := 0
+	for _, f := range files {
+		ids, _ := machineResourceIDs(f)
+		for _, id := range ids {
+			idToFiles[id] = append(idToFiles[id], f)
+		}
+		if r.inScope(f) {
+			idCount += len(ids)
+		}
+	}
+
+	r.logInfo("Syncing machine labels and request sets", "component", "Machines", "count", idCount)
+
+	duplicateIDs := make(map[string]bool)
+	repoRoot := filepath.Dir(dir)
+	var resources []state.ResourceInfo
+	applied, failed := 0, 0
+	for id, idFiles := range idToFiles {
+		if len(idFiles) > 1 {
+			duplicateIDs[id] = true
+			relFiles := make([]string, len(idFiles))
+			for i, f := range idFiles {
+				relFiles[i] = strings.TrimPrefix(f, repoRoot)
+			}
+			r.logError("Duplicate machine resource ID found, skipping sync", "component", "Machines", "id", id, "files", strings.Join(relFiles, ", "))
+			r.touch("Machine", id, false)
+			resources = append(resources, state.ResourceInfo{
+				ID:     id,
+				Type:   "Machine",
+				Status: "outofsync",
+				Error:  fmt.Sprintf("Conflicting machine resource files: %s", strings.Join(relFiles, ", ")),
+			})
+			failed++
+		}
+	}
+
+	allLiveStates, err := r.client.GetAllLiveMachineResources(ctx)
+	if err != nil {
+		r.logWarn("Failed to fetch live machine resources", "component", "Machines", "error", err)
+	}
+
+	for _, file := range files {
+		if !r.inScope(file) {
+			continue
+		}
+
+		parsed, err := loadMachineResources(file)
+		if err != nil {
+			r.logError("Failed to parse machine resource file", "component", "Machines", "file", strings.TrimPrefix(file, repoRoot), "error", err)
+			failed++
+			continue
+		}
+
+		var ids []string
+		types := make(map[string]string, len(parsed))
+		summaries := make(map[string]string, len(parsed))
+		for i := range parsed {
+			res := &parsed[i]
+			if !duplicateIDs[res.Metadata.ID] {
+				ids = append(ids, res.Metadata.ID)
+				types[res.Metadata.ID] = machineResourceKind(res.Metadata.Type)
+				summaries[res.Metadata.ID] = model.MachineResourceSummary(res)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		fileContent := readFileContent(file)
+		result := func(id, status, diff, errMsg string) state.ResourceInfo {
+			return state.ResourceInfo{
+				ID:          id,
+				Type:        types[id],
+				Status:      status,
+				Summary:     summaries[id],
+				Diff:        diff,
+				FileContent: fileContent,
+				LiveContent: allLiveStates[id],
+				Error:       errMsg,
+			}
+		}
+
+		// Request sets Omni creates for auto-provisioned machine classes
+		// are owned by its controllers
+		var foreign []string
+		for _, id := range ids {
+			if reason := foreignResource(allLiveStates[id]); reason != "" {
+				foreign = append(foreign, id+" ("+reason+")")
+			}
+		}
+		if len(foreign) > 0 {
+			errMsg := "Resource already exists and is managed elsewhere: " + strings.Join(foreign, ", ")
+			r.logError("Machine resource owned elsewhere, skipping file", "component", "Machines", "ids", strings.Join(foreign, ", "))
+			for _, id := range ids {
+				r.touch(types[id], id, false)
+				resources = append(resources, result(id, "failed", "", errMsg))
+			}
+			failed += len(ids)
+			continue
+		}
+
+		if err := r.client.MachineResourceValidate(ctx, file); err != nil {
+			r.logError("Machine resource validation failed", "component", "Machines", "ids", strings.Join(ids, ", "), "error", err)
+			for _, id := range ids {
+				r.touch(types[id], id, false)
+				resources = append(resources, result(id, "failed", "", err.Error()))
+			}
+			failed += len(ids)
+			continue
+		}
+
+		diffs, _, changed := r.diffDocuments("Machines", fileContent, ids, func(id string) string {
+			return allLiveStates[id]
+		})
+
+		if !changed {
+			r.logDebug("Machine resources up to date", "component", "Machines", "ids", strings.Join(ids, ", "))
+			for _, id := range ids {
+				resources = append(resources, result(id, "success", "", ""))
+			}
+			applied += len(ids)
+			continue
+		}
+
+		if err := r.client.Apply(ctx, file); err != nil {
+			r.logError("Machine resource apply failed", "component", "Machines", "ids", strings.Join(ids, ", "), "error", err)
+			for _, id := range ids {
+				r.touch(types[id], id, false)
+				resources = append(resources, result(id, "failed", diffs[id], err.Error()))
+			}
+			failed += len(ids)
+			continue
+		}
+
+		r.logInfo("Machine resources applied", "component", "Machines", "ids", strings.Join(ids, ", "))
+		for _, id := range ids {
+			r.touch(types[id], id, true)
+			resources = append(resources, result(id, "success", diffs[id], ""))
+		}
+		applied += len(ids)
+	}
+
+	if r.scope != nil {
+		desiredIDs, err := collectMachineResourceIDs(dir)
+		if err != nil {
+			// Keep every existing entry rather than dropping ones we cannot verify
+			desiredIDs = nil
+			for _, res := range r.state.GetMachines() {
+				desiredIDs = append(desiredIDs, res.ID)
+			}
+		}
+		resources = mergeScopedResources(r.state.GetMachines(), resources, desiredIDs)
+	}
+
+	r.state.SetMachines(resources)
+	r.logInfo("Machine resources result", "component", "Machines", "synced", applied, "failed", failed)
+}
+
+// ============================================================
+// Machines — Delete
+// ============================================================
+
+// DeleteMachineResources deletes MachineLabels and MachineRequestSets that
+// no longer exist in Git. Deleting a MachineLabels resource removes the
+// labels users assigned to that machine; deleting a request set lets the
+// provider tear its machines down. Resources owned by an Omni controller are
+// never deleted, and nothing is deleted when the directory does not exist.
+// Like clusters and machine classes, resources whose live annotations say
+// omni-cd/prune: false or omni-cd/ignore: true are kept, and deletions go
+// through the prune guard, separately for each kind.
+func (r *Reconciler) DeleteMachineResources(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No machine changes, skipping delete", "component", "Machines")
+		return
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		r.logDebug("Directory not found, skipping delete", "component", "Machines", "path", dir)
+		return
+	}
+
+	desiredIDs, err := collectMachineResourceIDs(dir)
+	if err != nil {
+		// A file we cannot parse would make its resources look removed
+		r.logError("Failed to parse machine resource files, skipping delete", "component", "Machines", "error", err)
+		return
+	}
+
+	live, err := r.client.GetAllLiveMachineResources(ctx)
+	if err != nil {
+		r.logError("Failed to list machine resources", "component", "Machines", "error", err)
+		return
+	}
+
+	r.logInfo("Checking for machine resources to delete", "component", "Machines")
+
+	ids := make([]string, 0, len(live))
+	for id := range live {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var (
+		retained   []state.ResourceInfo
+		types      = make(map[string]string)
+		candidates = make(map[string][]string)
+		managed    = make(map[string]int)
+	)
+	for _, id := range ids {
+		if foreignResource(live[id]) != "" {
+			continue
+		}
+		parsed, err := model.ParseResources([]byte(live[id]))
+		if err != nil || len(parsed) == 0 {
+			continue
+		}
+		typ := parsed[0].Metadata.Type
+		kind := machineResourceKind(typ)
+		managed[kind]++
+		if contains(desiredIDs, id) {
+			continue
+		}
+
+		policy, err := model.PolicyFromAnnotations(parsed[0].Metadata.Annotations)
+		if err != nil {
+			r.logError("Invalid policy on machine resource, not deleting", "component", "Machines", "type", kind, "id", id, "error", err)
+			retained = append(retained, state.ResourceInfo{
+				ID:     id,
+				Type:   kind,
+				Status: "outofsync",
+				Error:  "Removed from Git but not deleted: " + err.Error(),
+			})
+			continue
+		}
+		if res := retainedResource(kind, id, policy); res != nil {
+			r.logInfo("Machine resource not in Git, kept by policy", "component", "Machines", "type", kind, "id", id, "policy", strings.Join(policy.Labels(), ", "))
+			retained = append(retained, *res)
+			continue
+		}
+		types[id] = typ
+		candidates[kind] = append(candidates[kind], id)
+	}
+
+	var allowed []string
+	for _, kind := range []string{"MachineLabels", "MachineRequestSet"} {
+		ok, held, reason := r.guardPrune(kind, candidates[kind], managed[kind])
+		allowed = append(allowed, ok...)
+		for _, id := range held {
+			r.logWarn("Machine resource not in Git, deletion pending confirmation", "component", "Machines", "type", kind, "id", id)
+			retained = append(retained, state.ResourceInfo{
+				ID:     id,
+				Type:   kind,
+				Status: "pendingdelete",
+				Diff:   reason,
+			})
+		}
+	}
+
+	deleted, failed := 0, 0
+	for _, id := range allowed {
+		typ := types[id]
+		kind := machineResourceKind(typ)
+
+		r.logWarn("Machine resource not in Git, deleting", "component", "Machines", "type", kind, "id", id)
+		if err := r.client.DeleteMachineResource(ctx, typ, id); err != nil {
+			r.logError("Machine resource delete failed", "component", "Machines", "type", kind, "id", id, "error", err)
+			r.touch(kind, id, false)
+			failed++
+			continue
+		}
+		r.logInfo("Machine resource deleted", "component", "Machines", "type", kind, "id", id)
+		r.touch(kind, id, true)
+		deleted++
+	}
+
+	// Replace the entries of resources that are no longer in Git, including
+	// the retained and pending ones of the previous run
+	var resources []state.ResourceInfo
+	for _, res := range r.state.GetMachines() {
+		if contains(desiredIDs, res.ID) {
+			resources = append(resources, res)
+		}
+	}
+	r.state.SetMachines(append(resources, retained...))
+
+	if deleted == 0 && failed == 0 && len(retained) == 0 {
+		r.logInfo("No machine resources to delete", "component", "Machines")
+	} else {
+		r.logInfo("Machine resource delete result", "component", "Machines", "deleted", deleted, "failed", failed, "kept", len(retained))
+	}
+}
+
+// ============================================================
+// Machines — Capacity
+// ============================================================
+
+// CheckMachineCapacity works out, for every machine class in the state,
+// whether it can supply the machines that the cluster templates in Git
+// allocate from it. A manually provisioned class counts the live machines
+// its selector matches that are either free or already part of a cluster
+// using the class. An auto-provisioned class depends on its infrastructure
+// provider being registered. The result is an estimate; it does not account
+// for one machine matching several classes.
+func (r *Reconciler) CheckMachineCapacity(ctx context.Context, mcDir, clustersDir string) {
+	classes := make(map[string]model.MachineClass)
+	files, _ := findYAMLFiles(mcDir)
+	for _, f := range files {
+		parsed, err := loadMachineClasses(f)
+		if err != nil {
+			continue
+		}
+		for _, mc := range parsed {
+			classes[mc.Metadata.ID] = mc
+		}
+	}
+	if len(classes) == 0 {
+		return
+	}
+
+	required, clusters := machineClassDemand(clustersDir)
+
+	needMachines, needProviders := false, false
+	for _, mc := range classes {
+		if mc.ProvisionType() == "auto" {
+			needProviders = true
+		} else {
+			needMachines = true
+		}
+	}
+	var machines []omni.Machine
+	var providers []string
+	var machinesErr, providersErr error
+	if needMachines {
+		machines, machinesErr = r.client.GetMachines(ctx)
+	}
+	if needProviders {
+		providers, providersErr = r.client.GetInfraProviderIDs(ctx)
+	}
+
+	capacity := make(map[string]*state.Capacity, len(classes))
+	short := 0
+	for id, mc := range classes {
+		c := &state.Capacity{Required: required[id], Clusters: clusters[id]}
+		capacity[id] = c
+
+		if mc.ProvisionType() == "auto" {
+			provider := mc.Spec.AutoProvision.ProviderID
+			switch {
+			case providersErr != nil:
+				c.Status, c.Message = "unknown", "Failed to list infra providers: "+providersErr.Error()
+			case contains(providers, provider):
+				c.Status, c.Message = "ondemand", "Machines are created by infra provider "+provider
+			default:
+				c.Status, c.Message = "insufficient", "Infra provider "+provider+" is not registered"
+			}
+		} else if machinesErr != nil {
+			c.Status, c.Message = "unknown", "Failed to list machines: "+machinesErr.Error()
+		} else {
+			c.Status = "ok"
+			for _, m := range machines {
+				ok, err := mc.Matches(m.Labels)
+				if err != nil {
+					c.Status, c.Message = "unknown", err.Error()
+					break
+				}
+				if ok && (m.Cluster() == "" || contains(c.Clusters, m.Cluster())) {
+					c.Available++
+				}
+			}
+			if c.Status == "ok" && c.Available < c.Required {
+				c.Status = "insufficient"
+				c.Message = fmt.Sprintf("%d machines available, clusters need %d", c.Available, c.Required)
+			}
+		}
+
+		if c.Status == "insufficient" {
+			r.logWarn("Machine class cannot satisfy its clusters", "component", "MachineClasses", "id", id, "reason", c.Message)
+			short++
+		}
+	}
+
+	r.state.SetMachineClassCapacity(capacity)
+	r.logInfo("Machine class capacity checked", "component", "MachineClasses", "classes", len(classes), "insufficient", short)
+}
+
+// machineClassDemand sums, per machine class, the machines the cluster
+// templates in dir allocate from it, and lists the clusters that use it.
+// Worker pools of unlimited size use the class without a fixed demand.
+func machineClassDemand(dir string) (required map[string]int, clusters map[string][]string) {
+	required = make(map[string]int)
+	clusters = make(map[string][]string)
+	templates, _ := findClusterTemplates(dir)
+	for _, file := range templates {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		tmpl, err := model.ParseTemplate(data)
+		if err != nil || tmpl.ClusterName() == "" {
+			continue
+		}
+		sets := append([]model.MachineSet(nil), tmpl.Workers...)
+		if tmpl.ControlPlane != nil {
+			sets = append(sets, *tmpl.ControlPlane)
+		}
+		for _, set := range sets {
+			id := set.MachineClassName()
+			if id == "" {
+				continue
+			}
+			required[id] += set.NodeCount()
+			if !contains(clusters[id], tmpl.ClusterName()) {
+				clusters[id] = append(clusters[id], tmpl.ClusterName())
+			}
+		}
+	}
+	for id := range clusters {
+		sort.Strings(clusters[id])
+	}
+	return required, clusters
+}
+
+// ============================================================
+// Machines — Helpers
+// ============================================================
+
+// machineResourceKind returns the short kind shown for a machine resource type.
+func machineResourceKind(typ string) string {
+	if typ == model.MachineRequestSetType {
+		return "MachineRequestSet"
+	}
+	return "MachineLabels"
+}
+
+// loadMachineResources parses every machine resource in a YAML file.
+func loadMachineResources(file string) ([]model.Resource, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return model.ParseMachineResources(data)
+}
+
+// machineResourceIDs returns the ids of all machine resources defined in a YAML file.
+func machineResourceIDs(file string) ([]string, error) {
+	resources, err := loadMachineResources(file)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(resources))
+	for i, res := range resources {
+		ids[i] = res.Metadata.ID
+	}
+	return ids, nil
+}
+
+// collectMachineResourceIDs returns all desired machine resource IDs from the
+// Git repo. It fails if any file cannot be parsed.
+func collectMachineResourceIDs(dir string) ([]string, error) {
+	files, err := findYAMLFiles(dir)
+	if err != nil {
+		return nil, nil
+	}
+
+	var ids []string
+	for _, f := range files {
+		fileIDs, err := machineResourceIDs(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		ids = append(ids, fileIDs...)
+	}
+	return ids, nil
+}
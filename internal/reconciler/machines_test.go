@@ -0,0 +1,203 @@
+package reconciler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"omni-cd/internal/state"
+)
+
+const machineLabelsRack1 = `metadata:
+  namespace: default
+  type: MachineLabels.omni.sidero.dev
+  id: m-4
+  labels:
+    role: worker
+`
+
+func capacityOf(t *testing.T, env *testEnv, id string) *state.Capacity {
+	t.Helper()
+	for _, mc := range env.state.GetMachineClasses() {
+		if mc.ID == id {
+			if mc.Capacity == nil {
+				t.Fatalf("machine class %s has no capacity", id)
+			}
+			return mc.Capacity
+		}
+	}
+	t.Fatalf("machine class %s not in state", id)
+	return nil
+}
+
+func TestApplyMachineResources(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machines/labels.yaml", machineLabelsRack1)
+
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"Apply/m-4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	res := env.state.GetMachines()
+	if len(res) != 1 || res[0].Type != "MachineLabels" || res[0].Summary != "role=worker" {
+		t.Errorf("machines = %+v, want MachineLabels m-4 assigning role=worker", res)
+	}
+
+	env.fake.ResetCalls()
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("unchanged machine labels were applied again: %v", calls)
+	}
+}
+
+func TestPruneMachineResources(t *testing.T) {
+	owned := `metadata:
+  type: MachineRequestSets.omni.sidero.dev
+  id: prod-workers
+  owner: MachineProvisionController
+spec:
+  providerid: aws
+  machinecount: 2
+`
+	manual := `metadata:
+  type: MachineRequestSets.omni.sidero.dev
+  id: spare
+spec:
+  providerid: aws
+  machinecount: 1
+`
+	oldLabels := strings.Replace(machineLabelsRack1, "m-4", "m-9", 1)
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantIDs []string
+	}{
+		{
+			name:    "resources not in git are deleted, owned ones kept",
+			files:   map[string]string{"machines/labels.yaml": machineLabelsRack1},
+			wantIDs: []string{"m-4", "prod-workers"},
+		},
+		{
+			name:    "nothing is deleted without the directory",
+			wantIDs: []string{"m-9", "prod-workers", "spare"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, true)
+			for _, doc := range []string{owned, manual, oldLabels} {
+				if err := env.fake.AddMachineResource(doc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for rel, content := range tt.files {
+				env.write(rel, content)
+			}
+
+			env.reconcile()
+			if got := env.fake.MachineResourceIDs(); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("machine resources in Omni = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestMachineClassCapacity(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.write("machine-classes/control-plane.yaml", mcControlPlane)
+	env.write("clusters/prod/cluster.yaml", clusterProd)
+	for _, id := range []string{"m-1", "m-2", "m-3"} {
+		env.fake.AddMachine(id, map[string]string{"role": "controlplane"})
+	}
+	env.fake.AddMachine("m-4", map[string]string{})
+	env.fake.AddMachine("m-5", map[string]string{"role": "worker"})
+	env.fake.AddMachine("m-6", map[string]string{"role": "worker", "omni.sidero.dev/cluster": "staging"})
+
+	env.reconcile()
+	if c := capacityOf(t, env, "control-plane"); c.Status != "ok" || c.Available != 3 || c.Required != 3 {
+		t.Errorf("control-plane capacity = %+v, want ok with 3 of 3", c)
+	}
+	c := capacityOf(t, env, "workers")
+	if c.Status != "insufficient" || c.Available != 1 || c.Required != 2 {
+		t.Errorf("workers capacity = %+v, want insufficient with 1 of 2", c)
+	}
+	if !reflect.DeepEqual(c.Clusters, []string{"prod"}) {
+		t.Errorf("workers clusters = %v, want [prod]", c.Clusters)
+	}
+
+	// Labelling a free machine from Git fixes the shortage
+	env.write("machines/labels.yaml", machineLabelsRack1)
+	env.reconcile()
+	if c := capacityOf(t, env, "workers"); c.Status != "ok" || c.Available != 2 {
+		t.Errorf("workers capacity after labelling = %+v, want ok with 2", c)
+	}
+}
+
+func TestAutoProvisionedMachineClassCapacity(t *testing.T) {
+	mcAuto := `metadata:
+  namespace: default
+  type: MachineClasses.omni.sidero.dev
+  id: workers
+spec:
+  autoprovision:
+    providerid: aws
+`
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcAuto)
+
+	env.reconcile()
+	if c := capacityOf(t, env, "workers"); c.Status != "insufficient" || !strings.Contains(c.Message, "aws") {
+		t.Errorf("capacity = %+v, want insufficient naming the missing provider", c)
+	}
+
+	env.fake.AddInfraProvider("aws")
+	env.reconcile()
+	if c := capacityOf(t, env, "workers"); c.Status != "ondemand" {
+		t.Errorf("capacity = %+v, want ondemand", c)
+	}
+}
+
+func TestPruneMachineResourcesGuarded(t *testing.T) {
+	kept := `metadata:
+  type: MachineRequestSets.omni.sidero.dev
+  id: kept
+  annotations:
+    omni-cd/prune: "false"
+spec:
+  providerid: aws
+  machinecount: 1
+`
+	spare := strings.Replace(kept, "  annotations:\n    omni-cd/prune: \"false\"\n", "", 1)
+	spare = strings.Replace(spare, "id: kept", "id: spare", 1)
+
+	env := newTestEnv(t, true)
+	env.rec.SetPruneGuard(PruneGuard{RequireApproval: true})
+	for _, doc := range []string{kept, spare} {
+		if err := env.fake.AddMachineResource(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.write("machines/labels.yaml", machineLabelsRack1)
+	env.reconcile()
+
+	if got, want := env.fake.MachineResourceIDs(), []string{"kept", "m-4", "spare"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("machine resources in Omni = %v, want %v", got, want)
+	}
+	machines := env.state.GetMachines()
+	if got := statusOf(machines, "kept"); got != "outofsync" {
+		t.Errorf("status of kept = %q, want outofsync", got)
+	}
+	if got := statusOf(machines, "spare"); got != "pendingdelete" {
+		t.Errorf("status of spare = %q, want pendingdelete", got)
+	}
+
+	if !env.state.ApproveDeletion("MachineRequestSet", "spare") {
+		t.Fatal("pending request set could not be approved")
+	}
+	env.reconcile()
+	if got, want := env.fake.MachineResourceIDs(), []string{"kept", "m-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("machine resources in Omni = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package reconciler
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"omni-cd/internal/model"
+	"omni-cd/internal/state"
+)
+
+// ============================================================
+// Sync Policy
+// ============================================================
+
+// liveClusterPolicy returns the policy annotations a live cluster carries,
+// read from its exported template.
+func liveClusterPolicy(live string) (model.Policy, error) {
+	tmpl, err := model.ParseTemplate([]byte(live))
+	if err != nil {
+		return model.Policy{}, err
+	}
+	return tmpl.Policy()
+}
+
+// liveMachineClassPolicy returns the policy annotations a live machine
+// class carries.
+func liveMachineClassPolicy(live string) (model.Policy, error) {
+	classes, err := model.ParseMachineClasses([]byte(live))
+	if err != nil || len(classes) == 0 {
+		return model.Policy{}, err
+	}
+	return classes[0].Policy()
+}
+
+// retainedResource returns the state entry of a resource that is gone from
+// Git but stays in Omni because of its policy, or nil when the policy lets
+// it be deleted.
+func retainedResource(typ, id string, p model.Policy) *state.ResourceInfo {
+	switch {
+	case p.Ignore:
+		return &state.ResourceInfo{ID: id, Type: typ, Status: "ignored", Policy: p.Labels()}
+	case p.NoPrune:
+		return &state.ResourceInfo{
+			ID:     id,
+			Type:   typ,
+			Status: "outofsync",
+			Policy: p.Labels(),
+			Diff:   "Removed from Git. Not deleted because of " + model.AnnotationPrune + ": false.",
+		}
+	}
+	return nil
+}
+
+// subsetFile returns a file holding only the documents of ids from file,
+// whose documents are content, so that they can be validated or applied
+// without the others. When ids covers every document, file itself is
+// returned. The returned function removes the temporary file.
+func subsetFile(file, content string, ids []string) (string, func(), error) {
+	docs, err := model.DocumentsByID(content)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(ids) == len(docs) {
+		return file, func() {}, nil
+	}
+
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, docs[id])
+	}
+	f, err := os.CreateTemp("", "omni-cd-subset-*.yaml")
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = f.WriteString(strings.Join(parts, "\n---\n") + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
+
+// templatePolicy returns the policy declared in a cluster template file.
+func templatePolicy(file string) (model.Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return model.Policy{}, err
+	}
+	tmpl, err := model.ParseTemplate(data)
+	if err != nil {
+		return model.Policy{}, err
+	}
+	return tmpl.Policy()
+}
+
+// removedCluster returns the state entry of a template-managed cluster
+// whose template is no longer in Git and that has not been deleted (yet).
+func (r *Reconciler) removedCluster(ctx context.Context, c state.ResourceInfo) state.ResourceInfo {
+	live, _ := r.client.GetLiveCluster(ctx, c.ID)
+	policy, _ := liveClusterPolicy(live)
+	c.Policy = policy.Labels()
+	if policy.Ignore {
+		c.Status, c.Diff = "ignored", ""
+		return c
+	}
+	c.Status = "outofsync"
+	c.Diff = "Cluster template removed from git. A sync deletes it, subject to the prune guard."
+	return c
+}
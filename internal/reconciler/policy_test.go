@@ -0,0 +1,201 @@
+package reconciler
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// annotatedCluster returns a cluster template without machine classes whose
+// Cluster document carries the given annotation lines.
+func annotatedCluster(name string, annotations ...string) string {
+	doc := "kind: Cluster\nname: " + name + "\n"
+	if len(annotations) > 0 {
+		doc += "annotations:\n  " + strings.Join(annotations, "\n  ") + "\n"
+	}
+	return doc + "---\nkind: ControlPlane\nmachines: []\n"
+}
+
+func TestClusterSyncPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		wantCalls  []string
+		wantStatus string
+		wantPolicy []string
+	}{
+		{
+			name:       "auto",
+			annotation: "omni-cd/sync: auto",
+			wantCalls:  []string{"ClusterTemplateSync/dev"},
+			wantStatus: "success",
+		},
+		{
+			name:       "manual",
+			annotation: "omni-cd/sync: manual",
+			wantStatus: "outofsync",
+			wantPolicy: []string{"manual sync"},
+		},
+		{
+			name:       "ignored",
+			annotation: `omni-cd/ignore: "true"`,
+			wantStatus: "ignored",
+			wantPolicy: []string{"ignored"},
+		},
+		{
+			name:       "invalid",
+			annotation: "omni-cd/sync: sometimes",
+			wantStatus: "failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, true)
+			env.write("clusters/dev/cluster.yaml", annotatedCluster("dev", tt.annotation))
+			env.reconcile()
+
+			calls := env.fake.Calls()
+			if len(calls) == 0 {
+				calls = nil
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			for _, c := range env.state.GetClusters() {
+				if c.ID == "dev" && (c.Status != tt.wantStatus || !reflect.DeepEqual(c.Policy, tt.wantPolicy)) {
+					t.Errorf("dev = status %q, policy %v; want %q, %v", c.Status, c.Policy, tt.wantStatus, tt.wantPolicy)
+				}
+			}
+		})
+	}
+}
+
+func TestManualClusterForceSync(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("clusters/prod/cluster.yaml", annotatedCluster("prod", "omni-cd/sync: manual"))
+	env.reconcile()
+	if got := env.fake.ClusterIDs(); len(got) != 0 {
+		t.Fatalf("manual cluster synced without force: %v", got)
+	}
+
+	env.rec.SetForce(Force{Clusters: []string{"prod"}})
+	env.reconcile()
+	if got, want := env.fake.ClusterIDs(), []string{"prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("clusters in Omni = %v, want %v", got, want)
+	}
+}
+
+func TestMachineClassSyncPolicy(t *testing.T) {
+	manual := strings.Replace(mcControlPlane, "  id: control-plane\n", "  id: control-plane\n  annotations:\n    omni-cd/sync: manual\n", 1)
+	ignored := `metadata:
+  namespace: default
+  type: MachineClasses.omni.sidero.dev
+  id: legacy
+  annotations:
+    omni-cd/ignore: "true"
+spec:
+  matchlabels:
+    - role = legacy
+`
+	env := newTestEnv(t, true)
+	env.write("machine-classes/all.yaml", mcWorkers+"---\n"+manual+"---\n"+ignored)
+	env.reconcile()
+
+	// Only the class without a policy is applied, on its own
+	if got, want := env.fake.Calls(), []string{"Apply/workers"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	want := map[string]string{"workers": "success", "control-plane": "outofsync", "legacy": "ignored"}
+	for id, status := range want {
+		if got := statusOf(env.state.GetMachineClasses(), id); got != status {
+			t.Errorf("status of %s = %q, want %q", id, got, status)
+		}
+	}
+
+	// A force sync applies the manual class, and only that class
+	env.fake.ResetCalls()
+	env.rec.SetForce(Force{MachineClasses: []string{"control-plane"}})
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"Apply/control-plane"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("forced calls = %v, want %v", got, want)
+	}
+	if got := statusOf(env.state.GetMachineClasses(), "control-plane"); got != "success" {
+		t.Errorf("status of control-plane after force sync = %q, want success", got)
+	}
+}
+
+func TestPrunePolicy(t *testing.T) {
+	noPruneClass := strings.Replace(mcWorkers, "  id: workers\n", "  id: workers\n  annotations:\n    omni-cd/prune: \"false\"\n", 1)
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", noPruneClass)
+	env.write("clusters/keep/cluster.yaml", annotatedCluster("keep", `omni-cd/prune: "false"`))
+	env.write("clusters/hidden/cluster.yaml", annotatedCluster("hidden", `omni-cd/ignore: "true"`))
+	env.write("clusters/old/cluster.yaml", annotatedCluster("old"))
+	env.reconcile()
+	// An ignored cluster is never created, so add it as if made by hand
+	env.fake.AddCluster("hidden", annotatedCluster("hidden", `omni-cd/ignore: "true"`), true)
+
+	env.remove("machine-classes/workers.yaml")
+	env.remove("clusters")
+	env.write("clusters/.keep", "")
+	env.fake.ResetCalls()
+	env.reconcile()
+
+	if got, want := env.fake.Calls(), []string{"DeleteCluster/old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	clusters := env.state.GetClusters()
+	if got := statusOf(clusters, "keep"); got != "outofsync" {
+		t.Errorf("status of keep = %q, want outofsync", got)
+	}
+	if got := statusOf(clusters, "hidden"); got != "ignored" {
+		t.Errorf("status of hidden = %q, want ignored", got)
+	}
+	if got := statusOf(env.state.GetMachineClasses(), "workers"); got != "outofsync" {
+		t.Errorf("status of workers = %q, want outofsync", got)
+	}
+}
+
+func TestPrunePolicyOnForcedAndFailedExports(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("clusters/prod/cluster.yaml", annotatedCluster("prod"))
+	env.write("clusters/keep/cluster.yaml", annotatedCluster("keep", `omni-cd/prune: "false"`))
+	env.write("clusters/old/cluster.yaml", annotatedCluster("old"))
+	env.reconcile()
+	env.remove("clusters/keep")
+	env.remove("clusters/old")
+
+	// A force sync respects the policy of the cluster it would delete
+	env.fake.ResetCalls()
+	env.rec.SetForce(Force{Clusters: []string{"keep"}})
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("force sync deleted a cluster kept by policy: %v", calls)
+	}
+	if got := statusOf(env.state.GetClusters(), "keep"); got != "outofsync" {
+		t.Errorf("status of keep = %q, want outofsync", got)
+	}
+
+	// Without its live annotations a cluster's policy is unknown
+	env.fake.FailOn("ExportCluster", "old", errors.New("export failed"))
+	env.fake.ResetCalls()
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("deleted a cluster whose policy could not be read: %v", calls)
+	}
+	if got := statusOf(env.state.GetClusters(), "old"); got != "outofsync" {
+		t.Errorf("status of old = %q, want outofsync", got)
+	}
+	env.rec.SetForce(Force{Clusters: []string{"old"}})
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("force sync deleted a cluster whose policy could not be read: %v", calls)
+	}
+
+	env.fake.FailOn("ExportCluster", "old", nil)
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"DeleteCluster/old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package reconciler
+
+import (
+	"fmt"
+)
+
+// ============================================================
+// Prune Guard
+// ============================================================
+
+// PruneGuard limits what a single reconcile may delete. It covers clusters
+// and machine classes, whose deletion takes workloads down with it.
+type PruneGuard struct {
+	// MaxDeletions is the number of resources of one kind a reconcile may
+	// delete without confirmation; 0 means no limit.
+	MaxDeletions int
+	// MaxPercent is the share of the managed resources of one kind a
+	// reconcile may delete without confirmation; 0 means no limit.
+	MaxPercent int
+	// RequireApproval holds every deletion until it is confirmed.
+	RequireApproval bool
+}
+
+// SetPruneGuard sets the limits applied to cluster and machine class
+// deletions.
+func (r *Reconciler) SetPruneGuard(g PruneGuard) {
+	r.pruneGuard = g
+}
+
+// guardPrune decides which of the candidates (resources of typ that are no
+// longer in Git) may be deleted now, out of managed resources of that type.
+// Deletions that were confirmed always go ahead. The others are held, with
+// the reason, when approval is required or when deleting all candidates
+// would exceed a limit; a limit holds the whole batch rather than deleting
+// an arbitrary part of it. The held resources replace the pending deletions
+// of typ so that they can be confirmed.
+func (r *Reconciler) guardPrune(typ string, candidates []string, managed int) (allowed, held []string, reason string) {
+	allowed, held, reason = r.checkPrune(typ, candidates, managed)
+	r.state.SetPendingDeletions(typ, held)
+	return allowed, held, reason
+}
+
+// checkPrune is guardPrune without recording the held resources, for
+// callers that only look at some of the resources of typ.
+func (r *Reconciler) checkPrune(typ string, candidates []string, managed int) (allowed, held []string, reason string) {
+	g := r.pruneGuard
+	switch {
+	case g.RequireApproval:
+		reason = "Removed from Git. Deletion requires approval."
+	case g.MaxDeletions > 0 && len(candidates) > g.MaxDeletions:
+		reason = fmt.Sprintf("Removed from Git. Deleting %d of %d resources exceeds the limit of %d per reconcile; confirm each deletion.", len(candidates), managed, g.MaxDeletions)
+	case g.MaxPercent > 0 && len(candidates)*100 > g.MaxPercent*managed:
+		reason = fmt.Sprintf("Removed from Git. Deleting %d of %d resources exceeds the limit of %d%% per reconcile; confirm each deletion.", len(candidates), managed, g.MaxPercent)
+	}
+
+	for _, id := range candidates {
+		if reason == "" || r.state.TakeDeletionApproval(typ, id) {
+			allowed = append(allowed, id)
+		} else {
+			held = append(held, id)
+		}
+	}
+	return allowed, held, reason
+}
@@ -0,0 +1,161 @@
+package reconciler
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// staticCluster returns a cluster template without machine classes.
+func staticCluster(name string) string {
+	return "kind: Cluster\nname: " + name + "\n---\nkind: ControlPlane\nmachines: []\n"
+}
+
+func TestPruneGuardLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		guard       PruneGuard
+		remove      []string
+		wantCalls   []string
+		wantPending []string
+	}{
+		{
+			name:      "within both limits",
+			guard:     PruneGuard{MaxDeletions: 1, MaxPercent: 50},
+			remove:    []string{"a"},
+			wantCalls: []string{"DeleteCluster/a"},
+		},
+		{
+			name:        "too many deletions",
+			guard:       PruneGuard{MaxDeletions: 1},
+			remove:      []string{"a", "b"},
+			wantPending: []string{"a", "b"},
+		},
+		{
+			name:        "too large a share",
+			guard:       PruneGuard{MaxPercent: 50},
+			remove:      []string{"a", "b", "c"},
+			wantPending: []string{"a", "b", "c"},
+		},
+		{
+			name:      "no limits",
+			remove:    []string{"a", "b", "c"},
+			wantCalls: []string{"DeleteCluster/a", "DeleteCluster/b", "DeleteCluster/c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, true)
+			env.rec.SetPruneGuard(tt.guard)
+			for _, id := range []string{"a", "b", "c", "d"} {
+				env.write("clusters/"+id+"/cluster.yaml", staticCluster(id))
+			}
+			env.reconcile()
+
+			for _, id := range tt.remove {
+				env.remove("clusters/" + id)
+			}
+			env.fake.ResetCalls()
+			env.reconcile()
+
+			// Clusters are deleted in parallel
+			calls := env.fake.Calls()
+			if len(calls) == 0 {
+				calls = nil
+			}
+			sort.Strings(calls)
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			clusters := env.state.GetClusters()
+			for _, id := range tt.wantPending {
+				if got := statusOf(clusters, id); got != "pendingdelete" {
+					t.Errorf("status of %s = %q, want pendingdelete", id, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneRequiresApproval(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.SetPruneGuard(PruneGuard{RequireApproval: true})
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.write("clusters/prod/cluster.yaml", staticCluster("prod"))
+	env.write("clusters/old/cluster.yaml", staticCluster("old"))
+	env.reconcile()
+
+	env.remove("machine-classes/workers.yaml")
+	env.remove("clusters/old")
+	env.fake.ResetCalls()
+	env.reconcile()
+
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Fatalf("deleted without approval: %v", calls)
+	}
+	if got := statusOf(env.state.GetClusters(), "old"); got != "pendingdelete" {
+		t.Errorf("status of old = %q, want pendingdelete", got)
+	}
+	if got := statusOf(env.state.GetMachineClasses(), "workers"); got != "pendingdelete" {
+		t.Errorf("status of workers = %q, want pendingdelete", got)
+	}
+	if env.state.ApproveDeletion("Cluster", "prod") {
+		t.Error("approved the deletion of a cluster that is still in Git")
+	}
+
+	// Only the confirmed deletion is carried out
+	if !env.state.ApproveDeletion("Cluster", "old") {
+		t.Fatal("pending cluster could not be approved")
+	}
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"DeleteCluster/old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if got := statusOf(env.state.GetClusters(), "old"); got != "" {
+		t.Errorf("deleted cluster still in state with status %q", got)
+	}
+	if got := statusOf(env.state.GetMachineClasses(), "workers"); got != "pendingdelete" {
+		t.Errorf("status of workers = %q, want pendingdelete", got)
+	}
+
+	// A confirmation is dropped when the resource returns to Git
+	env.state.ApproveDeletion("MachineClass", "workers")
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.reconcile()
+	env.remove("machine-classes/workers.yaml")
+	env.fake.ResetCalls()
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("stale confirmation was used: %v", calls)
+	}
+}
+
+func TestPruneGuardsForcedDeletions(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.SetPruneGuard(PruneGuard{RequireApproval: true})
+	env.write("clusters/prod/cluster.yaml", staticCluster("prod"))
+	env.write("clusters/old/cluster.yaml", staticCluster("old"))
+	env.reconcile()
+
+	env.remove("clusters/old")
+	env.fake.ResetCalls()
+	env.rec.SetForce(Force{Clusters: []string{"old"}})
+	env.reconcile()
+
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Fatalf("force sync deleted without approval: %v", calls)
+	}
+	if got := statusOf(env.state.GetClusters(), "old"); got != "pendingdelete" {
+		t.Errorf("status of old = %q, want pendingdelete", got)
+	}
+
+	// The held deletion can be confirmed like one held by a full sync
+	if !env.state.ApproveDeletion("Cluster", "old") {
+		t.Fatal("pending cluster could not be approved")
+	}
+	env.rec.SetForce(Force{Clusters: []string{"old"}})
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"DeleteCluster/old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
@@ -1,28 +1,148 @@
 package reconciler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"omni-cd/internal/model"
 	"omni-cd/internal/omni"
+	"omni-cd/internal/redact"
 	"omni-cd/internal/state"
+	"omni-cd/internal/syncwindow"
 )
 
 // Reconciler handles the apply and delete phases for machine classes
 // and cluster templates.
 type Reconciler struct {
-	state *state.AppState
+	state  *state.AppState
+	client omni.Client
+
+	// scope holds the absolute paths of files changed by the current commit.
+	// When nil, every resource is processed.
+	scope map[string]bool
+
+	// touched records the resources changed (or failing to change) during
+	// the current run as "Kind/id", mapped to whether the operation succeeded.
+	touchedMu sync.Mutex
+	touched   map[string]bool
+
+	// selfServiceAccount is the name of the service account omni-cd talks to
+	// Omni with. It is never deleted or changed.
+	selfServiceAccount string
+
+	// pruneGuard limits cluster and machine class deletions.
+	pruneGuard PruneGuard
+
+	// syncWindows restrict when clusters are synced and deleted; their
+	// schedules are evaluated in windowLocation.
+	syncWindows    syncwindow.Windows
+	windowLocation *time.Location
+	// now returns the current time (time.Now when nil)
+	now func() time.Time
+
+	// rollout groups cluster syncs into health-gated waves, whose health
+	// is polled every healthInterval (10s when zero).
+	rollout        Rollout
+	healthInterval time.Duration
+
+	// limits bound how many clusters are worked on at once and for how long.
+	limits Limits
+
+	// force lists the resources force-synced on request; see SetForce.
+	force Force
+
+	// draining stops the reconcile from starting new work; see Drain.
+	draining atomic.Bool
+}
+
+// New creates a new Reconciler with shared state that talks to Omni through client.
+func New(appState *state.AppState, client omni.Client) *Reconciler {
+	return &Reconciler{state: appState, client: client}
+}
+
+// ProtectServiceAccount names the service account omni-cd itself uses so
+// that access reconciliation never deletes it or changes its role. While it
+// is unknown, no service account is deleted at all.
+func (r *Reconciler) ProtectServiceAccount(name string) {
+	r.selfServiceAccount = name
 }
 
-// New creates a new Reconciler with shared state.
-func New(appState *state.AppState) *Reconciler {
-	return &Reconciler{state: appState}
+// SetScope limits the following apply and delete phases to resources whose
+// files are listed in changed (paths relative to repoDir). Machine classes are
+// scoped per file, clusters per template directory. Passing nil removes the
+// limit so that every resource is processed. Setting the scope starts a new
+// run, so the resources returned by Touched are reset.
+func (r *Reconciler) SetScope(repoDir string, changed []string) {
+	r.touchedMu.Lock()
+	r.touched = make(map[string]bool)
+	r.touchedMu.Unlock()
+
+	if changed == nil {
+		r.scope = nil
+		return
+	}
+	r.scope = make(map[string]bool, len(changed))
+	for _, f := range changed {
+		r.scope[filepath.Join(repoDir, f)] = true
+	}
+}
+
+// inScope reports whether file should be processed under the current scope.
+func (r *Reconciler) inScope(file string) bool {
+	return r.scope == nil || r.scope[file]
+}
+
+// dirInScope reports whether any changed file lives under dir.
+func (r *Reconciler) dirInScope(dir string) bool {
+	if r.scope == nil {
+		return true
+	}
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	for f := range r.scope {
+		if strings.HasPrefix(f, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Touched returns the resources that were applied, synced or deleted since
+// the scope was last set, sorted, and whether any of those operations failed.
+func (r *Reconciler) Touched() (resources []string, failed bool) {
+	r.touchedMu.Lock()
+	defer r.touchedMu.Unlock()
+	for res, ok := range r.touched {
+		resources = append(resources, res)
+		if !ok {
+			failed = true
+		}
+	}
+	sort.Strings(resources)
+	return resources, failed
+}
+
+// touch records the outcome of an operation on a resource. A failure is
+// never overwritten by a later success within the same run.
+func (r *Reconciler) touch(kind, id string, ok bool) {
+	r.touchedMu.Lock()
+	defer r.touchedMu.Unlock()
+	if r.touched == nil {
+		r.touched = make(map[string]bool)
+	}
+	key := kind + "/" + id
+	if prev, seen := r.touched[key]; seen && !prev {
+		return
+	}
+	r.touched[key] = ok
 }
 
 // ============================================================
@@ -32,7 +152,14 @@ func New(appState *state.AppState) *Reconciler {
 // ApplyMachineClasses applies all machine class YAML files from the given directory.
 // This is idempotent — existing classes are updated, new ones are created.
 // Files can contain multiple machine classes separated by ---.
-func (r *Reconciler) ApplyMachineClasses(dir string) {
+// Classes annotated omni-cd/ignore are skipped and those annotated
+// omni-cd/sync: manual are only diffed.
+func (r *Reconciler) ApplyMachineClasses(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No machine class changes, skipping apply", "component", "MachineClasses")
+		return
+	}
+
 	files, err := findYAMLFiles(dir)
 	if err != nil {
 		r.logWarn("Directory not found, skipping", "component", "MachineClasses", "path", dir)
@@ -43,18 +170,27 @@ func (r *Reconciler) ApplyMachineClasses(dir string) {
 		return
 	}
 
-	// Count total IDs across all files
-	idCount := 0
-	for _, f := range files {
-		idCount += len(extractAllIDs(f))
+	// A targeted reconcile only processes the selected classes
+	targeted := r.force.targetsMachineClasses()
+	if targeted {
+		r.logInfo("Force syncing machine classes", "component", "MachineClasses", "ids", strings.Join(r.force.MachineClasses, ", "), "selectors", strings.Join(r.force.MachineClassSelectors, "; "))
+	} else {
+		// Count total IDs across all files that will be processed
+		idCount := 0
+		for _, f := range files {
+			if r.inScope(f) {
+				ids, _ := machineClassIDs(f)
+				idCount += len(ids)
+			}
+		}
+		r.logInfo("Syncing machine classes", "component", "MachineClasses", "count", idCount)
 	}
 
-	r.logInfo("Syncing machine classes", "component", "MachineClasses", "count", idCount)
-
 	// Detect duplicate IDs across files
 	idToFiles := make(map[string][]string)
 	for _, f := range files {
-		for _, id := range extractAllIDs(f) {
+		ids, _ := machineClassIDs(f)
+		for _, id := range ids {
 			idToFiles[id] = append(idToFiles[id], f)
 		}
 	}
@@ -65,12 +201,16 @@ func (r *Reconciler) ApplyMachineClasses(dir string) {
 	for id, idFiles := range idToFiles {
 		if len(idFiles) > 1 {
 			duplicateIDs[id] = true
+			if targeted && !r.machineClassIDSelected(id, idFiles) {
+				continue
+			}
 			relFiles := make([]string, len(idFiles))
 			for i, f := range idFiles {
 				relFiles[i] = strings.TrimPrefix(f, repoRoot)
 			}
 			errMsg := fmt.Sprintf("Conflicting machine class templates: %s", strings.Join(relFiles, ", "))
 			r.logError("Duplicate machine class ID found, skipping sync", "component", "MachineClasses", "id", id, "files", strings.Join(relFiles, ", "))
+			r.touch("MachineClass", id, false)
 			resources = append(resources, state.ResourceInfo{
 				ID:     id,
 				Type:   "MachineClass",
@@ -82,113 +222,207 @@ func (r *Reconciler) ApplyMachineClasses(dir string) {
 	}
 
 	// Batch fetch all live machine class states once
-	allLiveStates, _ := omni.GetAllLiveMachineClasses()
+	allLiveStates, _ := r.client.GetAllLiveMachineClasses(ctx)
 
+	selected := make(map[string]bool)
 	for _, file := range files {
-		ids := extractAllIDs(file)
-		// Filter out duplicate IDs
-		var nonDupIDs []string
-		for _, id := range ids {
-			if !duplicateIDs[id] {
-				nonDupIDs = append(nonDupIDs, id)
-			}
+		// Only files touched by the current commit when scoped
+		if !r.inScope(file) {
+			continue
 		}
-		if len(nonDupIDs) == 0 {
+
+		classes, err := loadMachineClasses(file)
+		if err != nil {
+			r.logError("Failed to parse machine class file", "component", "MachineClasses", "file", strings.TrimPrefix(file, repoRoot), "error", err)
+			failed++
 			continue
 		}
-		ids = nonDupIDs
-		provisionType := detectProvisionType(file)
 
-		// Get dry-run diff to check if changes are needed
-		diffOutput, dryRunErr := omni.MachineClassDryRun(file)
+		// Filter out duplicate IDs and classes the policy hides from omni-cd
+		var ids []string
+		provisionTypes := make(map[string]string, len(classes))
+		policies := make(map[string]model.Policy, len(classes))
 		fileContent := readFileContent(file)
+		for _, mc := range classes {
+			id := mc.Metadata.ID
+			if targeted && !r.machineClassSelected(mc) {
+				continue
+			}
+			selected[id] = true
+			if duplicateIDs[id] {
+				continue
+			}
+			policy, err := mc.Policy()
+			if err != nil {
+				r.logError("Invalid machine class policy", "component", "MachineClasses", "id", id, "error", err)
+				r.touch("MachineClass", id, false)
+				resources = append(resources, state.ResourceInfo{
+					ID:          id,
+					Type:        "MachineClass",
+					Status:      "failed",
+					FileContent: fileContent,
+					Error:       err.Error(),
+				})
+				failed++
+				continue
+			}
+			if policy.Ignore {
+				r.logDebug("Machine class ignored by policy", "component", "MachineClasses", "id", id)
+				resources = append(resources, state.ResourceInfo{
+					ID:          id,
+					Type:        "MachineClass",
+					Status:      "ignored",
+					Policy:      policy.Labels(),
+					FileContent: fileContent,
+				})
+				continue
+			}
+			ids = append(ids, id)
+			provisionTypes[id] = mc.ProvisionType()
+			policies[id] = policy
+		}
+		if len(ids) == 0 {
+			continue
+		}
 
-		// If dry-run failed (validation error), mark as failed
-		if dryRunErr != nil {
-			r.logError("Machine class validation failed", "component", "MachineClasses", "ids", strings.Join(ids, ", "), "error", dryRunErr)
+		// Validate with a dry-run before comparing or applying anything
+		validateFile, cleanup, err := subsetFile(file, fileContent, ids)
+		if err == nil {
+			err = r.client.MachineClassValidate(ctx, validateFile)
+			cleanup()
+		}
+		if err != nil {
+			r.logError("Machine class validation failed", "component", "MachineClasses", "ids", strings.Join(ids, ", "), "error", err)
 			for _, id := range ids {
-				// Get from batch or fallback to individual fetch
-				liveContent := allLiveStates[id]
-				if liveContent == "" {
-					liveContent, _ = omni.GetLiveMachineClass(id)
-				}
+				r.touch("MachineClass", id, false)
 				resources = append(resources, state.ResourceInfo{
 					ID:            id,
 					Type:          "MachineClass",
 					Status:        "failed",
-					ProvisionType: provisionType,
+					ProvisionType: provisionTypes[id],
+					Policy:        policies[id].Labels(),
 					FileContent:   fileContent,
-					LiveContent:   liveContent,
-					Error:         dryRunErr.Error(),
+					LiveContent:   r.liveMachineClass(ctx, allLiveStates, id),
+					Error:         err.Error(),
 				})
 			}
 			failed += len(ids)
 			continue
 		}
 
-		// Check if there are any changes to apply
-		if diffOutput == "" || strings.Contains(diffOutput, "no changes") {
+		// Compare each desired machine class with its live counterpart
+		diffs, liveContents, _ := r.diffDocuments("MachineClasses", fileContent, ids, func(id string) string {
+			return r.liveMachineClass(ctx, allLiveStates, id)
+		})
+
+		// Classes with a manual sync policy are only reported as out of sync,
+		// unless they are force-synced
+		manual := func(id string) bool {
+			return policies[id].Manual && !targeted
+		}
+		var apply []string
+		for _, id := range ids {
+			if diffs[id] == "" {
+				continue
+			}
+			if manual(id) {
+				r.logWarn("Machine class out of sync (manual sync policy, skipping)", "component", "MachineClasses", "id", id)
+				continue
+			}
+			apply = append(apply, id)
+		}
+		result := func(id, status string, err error) state.ResourceInfo {
+			res := state.ResourceInfo{
+				ID:            id,
+				Type:          "MachineClass",
+				Status:        status,
+				ProvisionType: provisionTypes[id],
+				Policy:        policies[id].Labels(),
+				Diff:          diffs[id],
+				FileContent:   fileContent,
+				LiveContent:   liveContents[id],
+			}
+			if err != nil {
+				res.Error = err.Error()
+			}
+			return res
+		}
+
+		if len(apply) == 0 {
 			r.logDebug("Machine classes up to date", "component", "MachineClasses", "ids", strings.Join(ids, ", "))
 			for _, id := range ids {
-				// Get from batch or fallback to individual fetch
-				liveContent := allLiveStates[id]
-				if liveContent == "" {
-					liveContent, _ = omni.GetLiveMachineClass(id)
+				if diffs[id] != "" {
+					resources = append(resources, result(id, "outofsync", nil))
+				} else {
+					resources = append(resources, result(id, "success", nil))
+					applied++
 				}
-				resources = append(resources, state.ResourceInfo{
-					ID:            id,
-					Type:          "MachineClass",
-					Status:        "success",
-					ProvisionType: provisionType,
-					FileContent:   fileContent,
-					LiveContent:   liveContent,
-				})
 			}
-			applied += len(ids)
 			continue
 		}
 
-		// There is a diff — apply it
-		if err := omni.Apply(file); err != nil {
-			r.logError("Machine class apply failed", "component", "MachineClasses", "ids", strings.Join(ids, ", "), "error", err)
-			for _, id := range ids {
-				// Get from batch or fallback to individual fetch
-				liveContent := allLiveStates[id]
-				if liveContent == "" {
-					liveContent, _ = omni.GetLiveMachineClass(id)
+		// There is a diff — apply every class that is not synced manually
+		var auto []string
+		for _, id := range ids {
+			if !manual(id) {
+				auto = append(auto, id)
+			}
+		}
+		applyFile, cleanup, err := subsetFile(file, fileContent, auto)
+		if err == nil {
+			err = r.client.Apply(ctx, applyFile)
+			cleanup()
+		}
+		for _, id := range ids {
+			switch {
+			case manual(id):
+				if diffs[id] != "" {
+					resources = append(resources, result(id, "outofsync", nil))
+				} else {
+					resources = append(resources, result(id, "success", nil))
 				}
-				resources = append(resources, state.ResourceInfo{
-					ID:            id,
-					Type:          "MachineClass",
-					Status:        "failed",
-					ProvisionType: provisionType,
-					Diff:          diffOutput,
-					FileContent:   fileContent,
-					LiveContent:   liveContent,
-					Error:         err.Error(),
-				})
+			case err != nil:
+				r.touch("MachineClass", id, false)
+				resources = append(resources, result(id, "failed", err))
+				failed++
+			default:
+				// Unchanged classes in the same file were not really applied
+				if diffs[id] != "" {
+					r.touch("MachineClass", id, true)
+				}
+				resources = append(resources, result(id, "success", nil))
+				applied++
 			}
-			failed += len(ids)
+		}
+		if err != nil {
+			r.logError("Machine class apply failed", "component", "MachineClasses", "ids", strings.Join(apply, ", "), "error", err)
 		} else {
-			r.logInfo("Machine classes applied", "component", "MachineClasses", "ids", strings.Join(ids, ", "))
-			for _, id := range ids {
-				// Get from batch or fallback to individual fetch
-				liveContent := allLiveStates[id]
-				if liveContent == "" {
-					liveContent, _ = omni.GetLiveMachineClass(id)
-				}
-				resources = append(resources, state.ResourceInfo{
-					ID:            id,
-					Type:          "MachineClass",
-					Status:        "success",
-					ProvisionType: provisionType,
-					Diff:          diffOutput,
-					FileContent:   fileContent,
-					LiveContent:   liveContent,
-				})
+			r.logInfo("Machine classes applied", "component", "MachineClasses", "ids", strings.Join(apply, ", "))
+		}
+	}
+
+	if targeted {
+		for _, id := range r.force.MachineClasses {
+			if !selected[id] {
+				r.logWarn("Machine class not in Git, skipping force sync", "component", "MachineClasses", "id", id)
+			}
+		}
+		// Every other class keeps its state
+		var keep []string
+		for _, res := range r.state.GetMachineClasses() {
+			keep = append(keep, res.ID)
+		}
+		resources = mergeScopedResources(r.state.GetMachineClasses(), resources, keep)
+	} else if r.scope != nil {
+		desiredIDs, err := collectMachineClassIDs(dir)
+		if err != nil {
+			// Keep every existing entry rather than dropping ones we cannot verify
+			desiredIDs = nil
+			for _, res := range r.state.GetMachineClasses() {
+				desiredIDs = append(desiredIDs, res.ID)
 			}
-			applied += len(ids)
 		}
+		resources = mergeScopedResources(r.state.GetMachineClasses(), resources, desiredIDs)
 	}
 
 	r.state.SetMachineClasses(resources)
@@ -200,43 +434,131 @@ func (r *Reconciler) ApplyMachineClasses(dir string) {
 // ============================================================
 
 // DeleteMachineClasses deletes machine classes from Omni that no longer exist in Git.
-// If a machine class is still in use by a cluster, the delete is skipped with a warning.
-func (r *Reconciler) DeleteMachineClasses(dir string) {
-	desiredIDs := collectMachineClassIDs(dir)
+// A machine class that a cluster template in clustersDir or a live cluster
+// still allocates from is not deleted; it stays in the state as "blocked"
+// with the clusters that hold it. Deletions the prune guard holds back stay
+// in the state as "pendingdelete". Classes whose live annotations say
+// omni-cd/prune: false or omni-cd/ignore: true are kept.
+func (r *Reconciler) DeleteMachineClasses(ctx context.Context, dir, clustersDir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No machine class changes, skipping delete", "component", "MachineClasses")
+		return
+	}
+
+	desiredIDs, err := collectMachineClassIDs(dir)
+	if err != nil {
+		// A file we cannot parse would make its machine classes look removed
+		r.logError("Failed to parse machine class files, skipping delete", "component", "MachineClasses", "error", err)
+		return
+	}
 
-	existingIDs, err := omni.GetMachineClassIDs()
+	existingIDs, err := r.client.GetMachineClassIDs(ctx)
 	if err != nil {
 		r.logError("Failed to list machine classes", "component", "MachineClasses", "error", err)
 		return
 	}
 
+	liveClusters, err := r.client.GetAllLiveClusters(ctx)
+	if err != nil {
+		// Without the live clusters a class may look unused when it is not
+		r.logError("Failed to list clusters, skipping delete", "component", "MachineClasses", "error", err)
+		return
+	}
+	graph := templateGraph(clustersDir, liveClusters)
+
+	// The policy of a class that left Git is read from its live annotations
+	liveClasses, err := r.client.GetAllLiveMachineClasses(ctx)
+	if err != nil {
+		r.logError("Failed to fetch machine classes, skipping delete", "component", "MachineClasses", "error", err)
+		return
+	}
+
 	r.logInfo("Checking for machine classes to delete", "component", "MachineClasses")
 
-	deleted, failed := 0, 0
+	var (
+		blocked    []state.ResourceInfo
+		candidates []string
+	)
 	for _, id := range existingIDs {
 		if contains(desiredIDs, id) {
 			continue
 		}
 
+		policy, err := liveMachineClassPolicy(liveClasses[id])
+		if err != nil {
+			r.logError("Invalid policy on machine class, not deleting", "component", "MachineClasses", "id", id, "error", err)
+			blocked = append(blocked, state.ResourceInfo{
+				ID:     id,
+				Type:   "MachineClass",
+				Status: "outofsync",
+				Error:  "Removed from Git but not deleted: " + err.Error(),
+			})
+			continue
+		}
+		if res := retainedResource("MachineClass", id, policy); res != nil {
+			r.logInfo("Machine class not in Git, kept by policy", "component", "MachineClasses", "id", id, "policy", strings.Join(policy.Labels(), ", "))
+			blocked = append(blocked, *res)
+			continue
+		}
+
+		if users := nodeIDs(graph.dependents("MachineClass/" + id)); len(users) > 0 {
+			r.logWarn("Machine class not in Git but still referenced, not deleting", "component", "MachineClasses", "id", id, "blocked_by", strings.Join(users, ", "))
+			blocked = append(blocked, state.ResourceInfo{
+				ID:        id,
+				Type:      "MachineClass",
+				Status:    "blocked",
+				BlockedBy: users,
+				Error:     "Removed from Git but still used by cluster " + strings.Join(users, ", "),
+			})
+			continue
+		}
+		candidates = append(candidates, id)
+	}
+
+	allowed, held, reason := r.guardPrune("MachineClass", candidates, len(existingIDs))
+	for _, id := range held {
+		r.logWarn("Machine class not in Git, deletion pending confirmation", "component", "MachineClasses", "id", id)
+		blocked = append(blocked, state.ResourceInfo{
+			ID:     id,
+			Type:   "MachineClass",
+			Status: "pendingdelete",
+			Diff:   reason,
+		})
+	}
+
+	deleted, failed := 0, 0
+	for _, id := range allowed {
 		r.logWarn("Machine class not in Git, deleting", "component", "MachineClasses", "id", id)
-		output, err := omni.DeleteMachineClass(id)
+		output, err := r.client.DeleteMachineClass(ctx, id)
 		if err != nil {
 			if strings.Contains(output, "still in use") {
 				r.logWarn("Machine class still in use, skipping delete", "component", "MachineClasses", "id", id)
 			} else {
 				r.logError("Machine class delete failed", "component", "MachineClasses", "id", id, "output", output)
+				r.touch("MachineClass", id, false)
 				failed++
 			}
 		} else {
 			r.logInfo("Machine class deleted", "component", "MachineClasses", "id", id)
+			r.touch("MachineClass", id, true)
 			deleted++
 		}
 	}
 
-	if deleted == 0 && failed == 0 {
+	// Replace the entries of classes that are no longer in Git, including
+	// the blocked and pending ones of the previous run
+	var resources []state.ResourceInfo
+	for _, res := range r.state.GetMachineClasses() {
+		if contains(desiredIDs, res.ID) {
+			resources = append(resources, res)
+		}
+	}
+	r.state.SetMachineClasses(append(resources, blocked...))
+
+	if deleted == 0 && failed == 0 && len(held) == 0 {
 		r.logInfo("No machine classes to delete", "component", "MachineClasses")
 	} else {
-		r.logInfo("Machine class delete result", "component", "MachineClasses", "deleted", deleted, "failed", failed)
+		r.logInfo("Machine class delete result", "component", "MachineClasses", "deleted", deleted, "failed", failed, "pending", len(held))
 	}
 }
 
@@ -248,21 +570,30 @@ func (r *Reconciler) DeleteMachineClasses(dir string) {
 // Each subdirectory must contain a cluster.yaml file. Templates that fail validation
 // are skipped, leaving the existing cluster intact.
 // Only syncs when there is an actual diff to avoid unnecessary updates.
-func (r *Reconciler) ApplyClusters(dir string) {
-	// Check if we're force-syncing a specific cluster BEFORE checking templates
-	forceClusterID := r.state.GetForceClusterID()
+// Clusters annotated omni-cd/ignore are skipped and those annotated
+// omni-cd/sync: manual are only diffed unless they are force-synced.
+// Outside a cluster's sync windows its changes are only diffed, unless a
+// force sync overrides the windows.
+// Clusters are synced in rollout waves, lowest first. A wave only starts
+// once the clusters the previous one synced are healthy; when they are not
+// within the health timeout, or a cluster of the previous wave failed to
+// sync, the clusters of the later waves are left blocked.
+func (r *Reconciler) ApplyClusters(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No cluster template changes, skipping apply", "component", "Clusters")
+		return
+	}
+
+	// Check which clusters are force-synced BEFORE checking templates
+	forced := r.forcedClusters()
+	overrideWindow := r.force.OverrideWindow
 
 	templates, err := findClusterTemplates(dir)
 	if err != nil {
-		// If force-syncing and no templates directory exists, delete the cluster
-		if forceClusterID != "" && omni.IsClusterTemplateManaged(forceClusterID) {
-			r.logWarn("Cluster not in Git (no templates directory), deleting", "component", "Clusters", "cluster", forceClusterID)
-			if err := omni.DeleteCluster(forceClusterID); err != nil {
-				r.logError("Cluster delete failed", "component", "Clusters", "cluster", forceClusterID, "error", err)
-				return
-			}
-			r.logInfo("Cluster deleted", "component", "Clusters", "cluster", forceClusterID)
-			r.collectUnmanagedClusters(dir)
+		// If force-syncing and no templates directory exists, delete the clusters
+		if len(forced) > 0 {
+			kept := r.deleteForcedClusters(ctx, dir, sortedKeys(forced), " (no templates directory)")
+			r.collectUnmanagedClusters(ctx, dir, kept)
 			return
 		}
 		r.logWarn("Directory not found, skipping", "component", "Clusters", "path", dir)
@@ -270,43 +601,51 @@ func (r *Reconciler) ApplyClusters(dir string) {
 	}
 
 	if len(templates) == 0 {
-		// If force-syncing and no templates found, delete the cluster
-		if forceClusterID != "" && omni.IsClusterTemplateManaged(forceClusterID) {
-			r.logWarn("Cluster not in Git (no templates), deleting", "component", "Clusters", "cluster", forceClusterID)
-			if err := omni.DeleteCluster(forceClusterID); err != nil {
-				r.logError("Cluster delete failed", "component", "Clusters", "cluster", forceClusterID, "error", err)
-				return
-			}
-			r.logInfo("Cluster deleted", "component", "Clusters", "cluster", forceClusterID)
-			r.collectUnmanagedClusters(dir)
+		// If force-syncing and no templates found, delete the clusters
+		if len(forced) > 0 {
+			kept := r.deleteForcedClusters(ctx, dir, sortedKeys(forced), " (no templates)")
+			r.collectUnmanagedClusters(ctx, dir, kept)
 			return
 		}
 		r.logWarn("No cluster templates found", "component", "Clusters")
 		return
 	}
 
-	if forceClusterID != "" {
-		r.logInfo("Force syncing cluster", "component", "Clusters", "cluster", forceClusterID)
+	// Clusters selected by their labels are forced as well
+	for _, tmpl := range templates {
+		if r.templateSelected(tmpl) {
+			if name, _ := templateClusterName(tmpl); name != "" {
+				forced[name] = true
+			}
+		}
+	}
+	if r.force.targetsClusters() && len(forced) == 0 {
+		r.logWarn("No cluster template matches the selection", "component", "Clusters", "selectors", strings.Join(r.force.ClusterSelectors, "; "))
+		return
+	}
+
+	// Entries of forced clusters that left Git but were not deleted
+	var forcedKept []state.ResourceInfo
+	if len(forced) > 0 {
+		r.logInfo("Force syncing clusters", "component", "Clusters", "clusters", strings.Join(sortedKeys(forced), ", "))
 
-		// Check if the cluster exists in Git templates
-		clusterInGit := false
+		// Delete the forced clusters that are no longer in Git but managed
+		inGit := make(map[string]bool)
 		for _, tmpl := range templates {
-			if extractClusterName(tmpl) == forceClusterID {
-				clusterInGit = true
-				break
+			if name, _ := templateClusterName(tmpl); name != "" {
+				inGit[name] = true
 			}
 		}
-
-		// If cluster is not in Git but is managed, delete it
-		if !clusterInGit && omni.IsClusterTemplateManaged(forceClusterID) {
-			r.logWarn("Cluster not in Git, deleting", "component", "Clusters", "cluster", forceClusterID)
-			if err := omni.DeleteCluster(forceClusterID); err != nil {
-				r.logError("Cluster delete failed", "component", "Clusters", "cluster", forceClusterID, "error", err)
-				return
+		var gone []string
+		for _, id := range sortedKeys(forced) {
+			if !inGit[id] {
+				gone = append(gone, id)
 			}
-			r.logInfo("Cluster deleted", "component", "Clusters", "cluster", forceClusterID)
-			// Remove from state
-			r.collectUnmanagedClusters(dir)
+		}
+		forcedKept = r.deleteForcedClusters(ctx, dir, gone, "")
+		// Nothing is left to sync when none of them is in Git
+		if len(gone) == len(forced) {
+			r.collectUnmanagedClusters(ctx, dir, forcedKept)
 			return
 		}
 	} else {
@@ -314,7 +653,19 @@ func (r *Reconciler) ApplyClusters(dir string) {
 	}
 
 	// Batch fetch all live cluster states once
-	allLiveStates, _ := omni.GetAllLiveClusters()
+	allLiveStates, _ := r.client.GetAllLiveClusters(ctx)
+
+	// A cluster is only synced once the machine classes it allocates from
+	// have been applied
+	graph := templateGraph(dir, nil)
+	failedClasses := make(map[string]bool)
+	for _, mc := range r.state.GetMachineClasses() {
+		// A class is also out of sync when its file conflicts with another,
+		// but not when it merely waits for a manual sync
+		if mc.Status == "failed" || (mc.Status == "outofsync" && mc.Error != "") {
+			failedClasses[mc.ID] = true
+		}
+	}
 
 	var (
 		mu        sync.Mutex
@@ -322,6 +673,12 @@ func (r *Reconciler) ApplyClusters(dir string) {
 		resources []state.ResourceInfo
 		synced    int
 		failed    int
+		// Clusters synced by this run, which gate the next rollout wave
+		applied = make(map[string]bool)
+		// Sync policy of each cluster, written before its goroutine starts
+		policies = make(map[string]model.Policy)
+		// Clusters to sync by rollout wave
+		waves = make(map[int][]clusterJob)
 	)
 
 	// Detect duplicate cluster IDs across templates before processing.
@@ -329,14 +686,14 @@ func (r *Reconciler) ApplyClusters(dir string) {
 	// mark both as out of sync with an error and skip them.
 	nameToFiles := make(map[string][]string)
 	for _, tmpl := range templates {
-		name := extractClusterName(tmpl)
+		name, _ := templateClusterName(tmpl)
 		if name != "" {
 			nameToFiles[name] = append(nameToFiles[name], tmpl)
 		}
 	}
 	duplicates := make(map[string]bool)
 	for name, files := range nameToFiles {
-		if len(files) > 1 {
+		if len(files) > 1 && (len(forced) == 0 || forced[name]) {
 			duplicates[name] = true
 			relFiles := make([]string, len(files))
 			repoRoot := filepath.Dir(dir)
@@ -345,6 +702,7 @@ func (r *Reconciler) ApplyClusters(dir string) {
 			}
 			errMsg := fmt.Sprintf("Conflicting cluster templates: %s", strings.Join(relFiles, ", "))
 			r.logError("Duplicate cluster ID found, skipping sync", "component", "Clusters", "cluster", name, "files", strings.Join(relFiles, ", "))
+			r.touch("Cluster", name, false)
 			r.state.UpsertClusterStatus(name, "outofsync")
 			resources = append(resources, state.ResourceInfo{
 				ID:     name,
@@ -357,7 +715,11 @@ func (r *Reconciler) ApplyClusters(dir string) {
 	}
 
 	for _, tmpl := range templates {
-		name := extractClusterName(tmpl)
+		name, err := templateClusterName(tmpl)
+		if err != nil {
+			r.logError("Failed to parse cluster template, skipping", "component", "Clusters", "file", tmpl, "error", err)
+			continue
+		}
 		if name == "" {
 			r.logWarn("No cluster name found in template, skipping", "component", "Clusters", "file", tmpl)
 			continue
@@ -368,44 +730,132 @@ func (r *Reconciler) ApplyClusters(dir string) {
 			continue
 		}
 
-		// If force-syncing a specific cluster, skip others
-		if forceClusterID != "" && name != forceClusterID {
+		// If force-syncing specific clusters, skip others
+		if len(forced) > 0 && !forced[name] {
 			continue
 		}
 
-		wg.Add(1)
-		go func(tmplPath, clusterName string) {
+		// Only clusters whose directory was touched by the current commit when scoped
+		if !r.dirInScope(filepath.Dir(tmpl)) {
+			continue
+		}
+
+		policy, err := templatePolicy(tmpl)
+		var wave int
+		if err == nil {
+			wave, err = r.templateWave(tmpl)
+		}
+		if err != nil {
+			r.logError("Invalid cluster policy, skipping sync", "component", "Clusters", "cluster", name, "error", err)
+			r.touch("Cluster", name, false)
+			r.state.UpsertClusterStatus(name, "failed")
+			resources = append(resources, state.ResourceInfo{
+				ID:          name,
+				Type:        "Cluster",
+				Status:      "failed",
+				FileContent: readFileContent(tmpl),
+				Error:       err.Error(),
+			})
+			failed++
+			continue
+		}
+		policies[name] = policy
+		if policy.Ignore {
+			r.logDebug("Cluster ignored by policy", "component", "Clusters", "cluster", name)
+			resources = append(resources, state.ResourceInfo{
+				ID:          name,
+				Type:        "Cluster",
+				Status:      "ignored",
+				FileContent: readFileContent(tmpl),
+				LiveContent: allLiveStates[name],
+			})
+			continue
+		}
+
+		var blockers []string
+		for _, mc := range nodeIDs(graph.dependencies("Cluster/" + name)) {
+			if failedClasses[mc] {
+				blockers = append(blockers, mc)
+			}
+		}
+		if len(blockers) > 0 {
+			r.logError("Machine class failed to apply, not syncing cluster", "component", "Clusters", "cluster", name, "blocked_by", strings.Join(blockers, ", "))
+			r.touch("Cluster", name, false)
+			r.state.UpsertClusterStatus(name, "blocked")
+			resources = append(resources, state.ResourceInfo{
+				ID:          name,
+				Type:        "Cluster",
+				Status:      "blocked",
+				BlockedBy:   blockers,
+				FileContent: readFileContent(tmpl),
+				LiveContent: allLiveStates[name],
+				Error:       "Machine class " + strings.Join(blockers, ", ") + " failed to apply",
+			})
+			failed++
+			continue
+		}
+
+		windowOpen, nextWindow := r.clusterWindow(policy)
+
+		tmplPath, clusterName, manual := tmpl, name, policy.Manual
+		waves[wave] = append(waves[wave], clusterJob{tmpl: tmpl, name: name, run: func() {
 			defer wg.Done()
 
+			// A cluster that never answers must not hold up the others
+			ctx, cancel := r.clusterContext(ctx)
+			defer cancel()
+
 			// Read file content for UI display
 			fileContent := readFileContent(tmplPath)
 
 			// Validate the template before syncing to prevent broken configs
-			if err := omni.ClusterTemplateValidate(tmplPath); err != nil {
-				r.logError("Cluster template validation failed", "component", "Clusters", "cluster", clusterName, "error", err)
-				r.state.UpsertClusterStatus(clusterName, "failed")
+			if err := r.client.ClusterTemplateValidate(ctx, tmplPath); err != nil {
+				status, msg := clusterFailure(err)
+				r.logError("Cluster template validation failed", "component", "Clusters", "cluster", clusterName, "status", status, "error", err)
+				r.touch("Cluster", clusterName, false)
+				r.state.UpsertClusterStatus(clusterName, status)
 				mu.Lock()
 				resources = append(resources, state.ResourceInfo{
 					ID:          clusterName,
 					Type:        "Cluster",
-					Status:      "failed",
+					Status:      status,
 					FileContent: fileContent,
-					Error:       err.Error(),
+					Error:       msg,
 				})
 				failed++
 				mu.Unlock()
 				return
 			}
 
-			// Check if there are any changes to apply
-			diffOutput, _ := omni.ClusterTemplateDiff(tmplPath)
-			isForceSync := forceClusterID != "" && clusterName == forceClusterID
+			// Check if there are any changes to apply. Other diff errors
+			// show up again when syncing; a diff that timed out would make
+			// the cluster look up to date.
+			diffOutput, err := r.client.ClusterTemplateDiff(ctx, tmplPath)
+			if omni.IsTimeout(err) {
+				status, msg := clusterFailure(err)
+				r.logError("Cluster diff timed out", "component", "Clusters", "cluster", clusterName, "error", err)
+				r.touch("Cluster", clusterName, false)
+				r.state.UpsertClusterStatus(clusterName, status)
+				mu.Lock()
+				resources = append(resources, state.ResourceInfo{
+					ID:          clusterName,
+					Type:        "Cluster",
+					Status:      status,
+					FileContent: fileContent,
+					LiveContent: allLiveStates[clusterName],
+					Error:       msg,
+				})
+				failed++
+				mu.Unlock()
+				return
+			}
+			isForceSync := forced[clusterName]
 
 			if !isForceSync && (diffOutput == "" || strings.Contains(diffOutput, "no changes")) {
 				r.logDebug("Cluster up to date", "component", "Clusters", "cluster", clusterName)
 				liveContent := allLiveStates[clusterName]
 				if liveContent == "" {
-					liveContent, _ = omni.GetLiveCluster(clusterName)
+					liveContent, _ = r.client.GetLiveCluster(ctx, clusterName)
 				}
 				talos, k8s, cp, wk := clusterDetailFromLive(liveContent)
 				mu.Lock()
@@ -424,8 +874,60 @@ func (r *Reconciler) ApplyClusters(dir string) {
 				return
 			}
 
+			if manual && !isForceSync {
+				r.logWarn("Cluster out of sync (manual sync policy, skipping)", "component", "Clusters", "cluster", clusterName)
+				r.state.UpsertClusterStatus(clusterName, "outofsync")
+				liveContent := allLiveStates[clusterName]
+				if liveContent == "" {
+					liveContent, _ = r.client.GetLiveCluster(ctx, clusterName)
+				}
+				talos, k8s, cp, wk := clusterDetailFromLive(liveContent)
+				mu.Lock()
+				resources = append(resources, state.ResourceInfo{
+					ID:                clusterName,
+					Type:              "Cluster",
+					Status:            "outofsync",
+					Diff:              diffOutput,
+					FileContent:       fileContent,
+					LiveContent:       liveContent,
+					TalosVersion:      talos,
+					KubernetesVersion: k8s,
+					ControlPlane:      cp,
+					Workers:           wk,
+				})
+				mu.Unlock()
+				return
+			}
+
+			noChanges := diffOutput == "" || strings.Contains(diffOutput, "no changes")
+			if !windowOpen && !noChanges && !(isForceSync && overrideWindow) {
+				r.logWarn("Cluster out of sync, waiting for sync window", "component", "Clusters", "cluster", clusterName, "next_window", formatWindow(nextWindow))
+				r.state.UpsertClusterStatus(clusterName, "outofsync")
+				liveContent := allLiveStates[clusterName]
+				if liveContent == "" {
+					liveContent, _ = r.client.GetLiveCluster(ctx, clusterName)
+				}
+				talos, k8s, cp, wk := clusterDetailFromLive(liveContent)
+				mu.Lock()
+				resources = append(resources, waitForWindow(state.ResourceInfo{
+					ID:                clusterName,
+					Type:              "Cluster",
+					Diff:              diffOutput,
+					FileContent:       fileContent,
+					LiveContent:       liveContent,
+					TalosVersion:      talos,
+					KubernetesVersion: k8s,
+					ControlPlane:      cp,
+					Workers:           wk,
+				}, nextWindow))
+				mu.Unlock()
+				return
+			}
+
 			// There is a diff or force sync — log it and sync
-			if isForceSync {
+			if isForceSync && !windowOpen {
+				r.logWarn("Force syncing cluster outside its sync window", "component", "Clusters", "cluster", clusterName)
+			} else if isForceSync {
 				r.logWarn("Force syncing cluster", "component", "Clusters", "cluster", clusterName)
 			} else {
 				r.logWarn("Cluster out of sync", "component", "Clusters", "cluster", clusterName)
@@ -434,23 +936,25 @@ func (r *Reconciler) ApplyClusters(dir string) {
 			r.logInfo("Syncing cluster", "component", "Clusters", "cluster", clusterName)
 			r.state.UpsertClusterStatus(clusterName, "syncing")
 
-			if err := omni.ClusterTemplateSync(tmplPath); err != nil {
-				r.logError("Cluster sync failed", "component", "Clusters", "cluster", clusterName, "error", err)
-				r.state.UpsertClusterStatus(clusterName, "failed")
+			if err := r.client.ClusterTemplateSync(ctx, tmplPath); err != nil {
+				status, msg := clusterFailure(err)
+				r.logError("Cluster sync failed", "component", "Clusters", "cluster", clusterName, "status", status, "error", err)
+				r.touch("Cluster", clusterName, false)
+				r.state.UpsertClusterStatus(clusterName, status)
 				liveContent := allLiveStates[clusterName]
 				if liveContent == "" {
-					liveContent, _ = omni.GetLiveCluster(clusterName)
+					liveContent, _ = r.client.GetLiveCluster(ctx, clusterName)
 				}
 				talos, k8s, cp, wk := clusterDetailFromLive(liveContent)
 				mu.Lock()
 				resources = append(resources, state.ResourceInfo{
 					ID:                clusterName,
 					Type:              "Cluster",
-					Status:            "failed",
+					Status:            status,
 					Diff:              diffOutput,
 					FileContent:       fileContent,
 					LiveContent:       liveContent,
-					Error:             err.Error(),
+					Error:             msg,
 					TalosVersion:      talos,
 					KubernetesVersion: k8s,
 					ControlPlane:      cp,
@@ -460,11 +964,13 @@ func (r *Reconciler) ApplyClusters(dir string) {
 				mu.Unlock()
 			} else {
 				r.logInfo("Cluster synced", "component", "Clusters", "cluster", clusterName)
+				r.touch("Cluster", clusterName, true)
 				r.state.UpsertClusterStatus(clusterName, "success")
 				// Always fetch fresh after sync — the pre-fetched cache is stale
-				liveContent, _ := omni.GetLiveCluster(clusterName)
+				liveContent, _ := r.client.GetLiveCluster(ctx, clusterName)
 				talos, k8s, cp, wk := clusterDetailFromLive(liveContent)
 				mu.Lock()
+				applied[clusterName] = true
 				resources = append(resources, state.ResourceInfo{
 					ID:                clusterName,
 					Type:              "Cluster",
@@ -479,10 +985,103 @@ func (r *Reconciler) ApplyClusters(dir string) {
 				synced++
 				mu.Unlock()
 			}
-		}(tmpl, name)
+		}})
 	}
 
-	wg.Wait()
+	// Waves are synced one after the other. Before the next one starts,
+	// every cluster the current one synced has to be healthy, and none of
+	// its clusters may have failed.
+	order := sortedWaves(waves)
+	for i, wave := range order {
+		if len(order) > 1 {
+			r.logInfo("Rolling out wave", "component", "Clusters", "wave", wave, "clusters", len(waves[wave]))
+		}
+		sem := r.workers()
+		for _, job := range waves[wave] {
+			if r.stopped(ctx) {
+				break
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem }()
+				job.run()
+			}()
+		}
+		wg.Wait()
+		if i == len(order)-1 {
+			break
+		}
+		// Syncs cut short by a stop are not failures of the wave
+		if r.stopped(ctx) {
+			r.logWarn("Reconcile stopped, not rolling out further waves", "component", "Clusters", "wave", wave)
+			break
+		}
+
+		inWave := make(map[string]bool)
+		for _, job := range waves[wave] {
+			inWave[job.name] = true
+		}
+		var gate, syncFailed []string
+		for _, res := range resources {
+			switch {
+			case !inWave[res.ID]:
+			case applied[res.ID]:
+				gate = append(gate, res.ID)
+			case res.Status == "failed" || res.Status == "timedout":
+				syncFailed = append(syncFailed, res.ID)
+			}
+		}
+		sort.Strings(syncFailed)
+
+		// A failed sync halts the rollout without waiting for the others
+		var blockedBy []string
+		var reason string
+		if len(syncFailed) > 0 {
+			blockedBy = syncFailed
+			reason = fmt.Sprintf("Rollout halted: wave %d cluster %s failed to sync", wave, strings.Join(syncFailed, ", "))
+			r.logError("Rollout halted, cluster sync failed", "component", "Clusters", "wave", wave, "blocked_by", strings.Join(syncFailed, ", "))
+		} else {
+			unhealthy := r.waitHealthy(ctx, gate)
+			if r.stopped(ctx) {
+				r.logWarn("Reconcile stopped, not rolling out further waves", "component", "Clusters", "wave", wave)
+				break
+			}
+			if len(unhealthy) == 0 {
+				continue
+			}
+
+			timeout := r.rollout.HealthTimeout
+			if timeout <= 0 {
+				timeout = defaultHealthTimeout
+			}
+			blockedBy = unhealthy
+			reason = fmt.Sprintf("Rollout halted: wave %d cluster %s not healthy within %s", wave, strings.Join(unhealthy, ", "), timeout)
+			r.logError("Rollout halted, cluster not healthy", "component", "Clusters", "wave", wave, "blocked_by", strings.Join(unhealthy, ", "), "timeout", timeout.String())
+			for _, id := range unhealthy {
+				r.touch("Cluster", id, false)
+			}
+		}
+		for _, later := range order[i+1:] {
+			for _, job := range waves[later] {
+				r.state.UpsertClusterStatus(job.name, "blocked")
+				resources = append(resources, state.ResourceInfo{
+					ID:          job.name,
+					Type:        "Cluster",
+					Status:      "blocked",
+					BlockedBy:   blockedBy,
+					FileContent: readFileContent(job.tmpl),
+					LiveContent: allLiveStates[job.name],
+					Error:       reason,
+				})
+				failed++
+			}
+		}
+		break
+	}
+	for i := range resources {
+		resources[i].Policy = policies[resources[i].ID].Labels()
+	}
 
 	// Always merge with existing cluster states to preserve unmanaged clusters
 	existing := r.state.GetClusters()
@@ -503,12 +1102,8 @@ func (r *Reconciler) ApplyClusters(dir string) {
 			// This cluster was processed, use the new state
 			final = append(final, updated)
 			processedIDs[updated.ID] = true
-		} else if forceClusterID == "" {
-			// Not force-syncing, preserve all existing clusters
-			final = append(final, existingCluster)
-			processedIDs[existingCluster.ID] = true
-		} else if existingCluster.ID != forceClusterID {
-			// Force-syncing, but this isn't the target cluster, so preserve it
+		} else if !forced[existingCluster.ID] {
+			// Not a force-synced cluster, so preserve it
 			final = append(final, existingCluster)
 			processedIDs[existingCluster.ID] = true
 		}
@@ -523,14 +1118,14 @@ func (r *Reconciler) ApplyClusters(dir string) {
 
 	r.state.SetClusters(final)
 
-	if forceClusterID != "" {
+	if len(forced) > 0 {
 		r.logInfo("Force sync complete", "component", "Clusters", "synced", synced, "failed", failed)
 	} else {
 		r.logInfo("Cluster apply result", "component", "Clusters", "synced", synced, "failed", failed)
 	}
 
 	// Always collect unmanaged clusters to ensure they're visible
-	r.collectUnmanagedClusters(dir)
+	r.collectUnmanagedClusters(ctx, dir, forcedKept)
 
 	// Save state to disk
 	r.state.Save()
@@ -543,31 +1138,37 @@ func (r *Reconciler) ApplyClusters(dir string) {
 // DiffClusters runs validate + diff on all cluster templates without syncing.
 // Clusters with diffs are reported as "outofsync" in the state.
 // This allows operators to see drift even when sync is disabled.
-func (r *Reconciler) DiffClusters(dir string) {
+// Clusters annotated omni-cd/ignore are not diffed.
+func (r *Reconciler) DiffClusters(ctx context.Context, dir string) {
 	templates, err := findClusterTemplates(dir)
 	if err != nil {
 		r.logWarn("Directory not found, skipping", "component", "Clusters", "path", dir)
 		// Still need to collect unmanaged clusters even if directory doesn't exist
-		r.collectUnmanagedClusters(dir)
+		r.collectUnmanagedClusters(ctx, dir, nil)
 		return
 	}
 	if len(templates) == 0 {
 		r.logWarn("No cluster templates found", "component", "Clusters")
 		// Still need to collect unmanaged clusters even if no templates found
-		r.collectUnmanagedClusters(dir)
+		r.collectUnmanagedClusters(ctx, dir, nil)
 		return
 	}
 
 	r.logInfo("Checking cluster templates for drift (sync disabled)", "component", "Clusters", "count", len(templates))
 
 	// Batch fetch all live cluster states once
-	allLiveStates, _ := omni.GetAllLiveClusters()
+	allLiveStates, _ := r.client.GetAllLiveClusters(ctx)
 
 	var resources []state.ResourceInfo
+	policies := make(map[string]model.Policy)
 	inSync, outOfSync, errCount := 0, 0, 0
 
 	for _, tmpl := range templates {
-		name := extractClusterName(tmpl)
+		name, err := templateClusterName(tmpl)
+		if err != nil {
+			r.logError("Failed to parse cluster template, skipping", "component", "Clusters", "file", tmpl, "error", err)
+			continue
+		}
 		if name == "" {
 			r.logWarn("No cluster name found in template, skipping", "component", "Clusters", "file", tmpl)
 			continue
@@ -576,12 +1177,38 @@ func (r *Reconciler) DiffClusters(dir string) {
 		// Read file content for UI display
 		fileContent := readFileContent(tmpl)
 
+		policy, err := templatePolicy(tmpl)
+		if err != nil {
+			r.logError("Invalid cluster policy", "component", "Clusters", "cluster", name, "error", err)
+			resources = append(resources, state.ResourceInfo{
+				ID:          name,
+				Type:        "Cluster",
+				Status:      "failed",
+				FileContent: fileContent,
+				Error:       err.Error(),
+			})
+			errCount++
+			continue
+		}
+		if policy.Ignore {
+			resources = append(resources, state.ResourceInfo{
+				ID:          name,
+				Type:        "Cluster",
+				Status:      "ignored",
+				Policy:      policy.Labels(),
+				FileContent: fileContent,
+				LiveContent: allLiveStates[name],
+			})
+			continue
+		}
+		policies[name] = policy
+
 		// Validate the template
-		if err := omni.ClusterTemplateValidate(tmpl); err != nil {
+		if err := r.client.ClusterTemplateValidate(ctx, tmpl); err != nil {
 			r.logError("Cluster template validation failed", "component", "Clusters", "cluster", name, "error", err)
 			liveContent := allLiveStates[name]
 			if liveContent == "" {
-				liveContent, _ = omni.GetLiveCluster(name)
+				liveContent, _ = r.client.GetLiveCluster(ctx, name)
 			}
 			talos, k8s, cp, wk := clusterDetailFromLive(liveContent)
 			resources = append(resources, state.ResourceInfo{
@@ -600,10 +1227,10 @@ func (r *Reconciler) DiffClusters(dir string) {
 		}
 
 		// Check if there are any changes
-		diffOutput, _ := omni.ClusterTemplateDiff(tmpl)
+		diffOutput, _ := r.client.ClusterTemplateDiff(ctx, tmpl)
 		liveContent := allLiveStates[name]
 		if liveContent == "" {
-			liveContent, _ = omni.GetLiveCluster(name)
+			liveContent, _ = r.client.GetLiveCluster(ctx, name)
 		}
 		talos, k8s, cp, wk := clusterDetailFromLive(liveContent)
 		if diffOutput == "" || strings.Contains(diffOutput, "no changes") {
@@ -638,6 +1265,12 @@ func (r *Reconciler) DiffClusters(dir string) {
 		}
 	}
 
+	for i := range resources {
+		if p, ok := policies[resources[i].ID]; ok {
+			resources[i].Policy = p.Labels()
+		}
+	}
+
 	// Merge with existing cluster states to preserve unmanaged clusters
 	existing := r.state.GetClusters()
 
@@ -675,7 +1308,7 @@ func (r *Reconciler) DiffClusters(dir string) {
 	r.logInfo("Cluster diff result", "component", "Clusters", "in_sync", inSync, "out_of_sync", outOfSync, "failed", errCount)
 
 	// Also detect unmanaged clusters
-	r.collectUnmanagedClusters(dir)
+	r.collectUnmanagedClusters(ctx, dir, nil)
 
 	// Save state to disk
 	r.state.Save()
@@ -688,35 +1321,62 @@ func (r *Reconciler) DiffClusters(dir string) {
 // DeleteClusters deletes clusters from Omni that no longer exist in Git.
 // Only clusters with the omni.sidero.dev/managed-by-cluster-templates
 // annotation are considered. Manually created clusters are never touched.
-// Unmanaged clusters are added to state with "unmanaged" status for visibility.
-func (r *Reconciler) DeleteClusters(dir string) {
-	desiredIDs := collectClusterIDs(dir)
+// Unmanaged clusters are added to state with "unmanaged" status for visibility,
+// and clusters the prune guard holds back with "pendingdelete". Clusters whose
+// live annotations say omni-cd/prune: false or omni-cd/ignore: true are kept.
+func (r *Reconciler) DeleteClusters(ctx context.Context, dir string) {
+	if !r.dirInScope(dir) {
+		r.logDebug("No cluster template changes, skipping delete", "component", "Clusters")
+		return
+	}
 
-	allIDs, err := omni.GetClusterIDs()
+	desiredIDs, err := collectClusterIDs(dir)
+	if err != nil {
+		// A template we cannot parse would make its cluster look removed
+		r.logError("Failed to parse cluster templates, skipping delete", "component", "Clusters", "error", err)
+		return
+	}
+
+	allIDs, err := r.client.GetClusterIDs(ctx)
 	if err != nil {
 		r.logError("Failed to list clusters", "component", "Clusters", "error", err)
 		return
 	}
 
+	// The policy of a cluster that left Git is read from its live annotations
+	liveClusters, err := r.client.GetAllLiveClusters(ctx)
+	if liveClusters == nil {
+		r.logError("Failed to fetch clusters, skipping delete", "component", "Clusters", "error", err)
+		return
+	}
+	if err != nil {
+		// The clusters that could not be exported are kept below
+		r.logError("Failed to export some clusters", "component", "Clusters", "error", err)
+	}
+
 	r.logInfo("Checking for template-managed clusters to delete", "component", "Clusters")
 
 	// Track unmanaged clusters to preserve in state
 	var (
-		unmanaged []state.ResourceInfo
-		mu        sync.Mutex
-		wg        sync.WaitGroup
-		deleted   int
-		failed    int
+		unmanaged  []state.ResourceInfo
+		retained   []state.ResourceInfo
+		candidates []string
+		managed    int
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		deleted    int
+		failed     int
 	)
 
 	for _, id := range allIDs {
 		// If cluster is in Git, keep it
 		if contains(desiredIDs, id) {
+			managed++
 			continue
 		}
 
 		// Only delete clusters managed by cluster templates.
-		if !omni.IsClusterTemplateManaged(id) {
+		if !r.client.IsClusterTemplateManaged(ctx, id) {
 			r.logDebug("Cluster not managed by templates, ignoring", "component", "Clusters", "cluster", id)
 			unmanaged = append(unmanaged, state.ResourceInfo{
 				ID:     id,
@@ -725,19 +1385,62 @@ func (r *Reconciler) DeleteClusters(dir string) {
 			})
 			continue
 		}
+		managed++
 
+		live, exported := liveClusters[id]
+		if res := r.keepRemovedCluster(id, live, exported, false); res != nil {
+			retained = append(retained, *res)
+			continue
+		}
+		candidates = append(candidates, id)
+	}
+
+	allowed, held, reason := r.guardPrune("Cluster", candidates, managed)
+	var pending []state.ResourceInfo
+	for _, id := range held {
+		r.logWarn("Cluster not in Git, deletion pending confirmation", "component", "Clusters", "cluster", id)
+		pending = append(pending, state.ResourceInfo{
+			ID:     id,
+			Type:   "Cluster",
+			Status: "pendingdelete",
+			Diff:   reason,
+		})
+	}
+
+	sem := r.workers()
+	for _, id := range allowed {
+		if r.stopped(ctx) {
+			break
+		}
 		r.state.UpdateClusterStatus(id, "deleting")
 		wg.Add(1)
+		sem <- struct{}{}
 		go func(clusterID string) {
 			defer wg.Done()
+			defer func() { <-sem }()
+			ctx, cancel := r.clusterContext(ctx)
+			defer cancel()
 			r.logWarn("Cluster not in Git, deleting", "component", "Clusters", "cluster", clusterID)
-			if err := omni.DeleteCluster(clusterID); err != nil {
+			if err := r.client.DeleteCluster(ctx, clusterID); err != nil {
 				r.logError("Cluster delete failed", "component", "Clusters", "cluster", clusterID, "error", err)
+				r.touch("Cluster", clusterID, false)
 				mu.Lock()
 				failed++
+				// The cluster may still be going away; show it until the
+				// next reconcile finds out
+				if status, msg := clusterFailure(err); status == "timedout" {
+					retained = append(retained, state.ResourceInfo{
+						ID:     clusterID,
+						Type:   "Cluster",
+						Status: status,
+						Diff:   "Removed from Git. Deletion did not finish in time.",
+						Error:  msg,
+					})
+				}
 				mu.Unlock()
 			} else {
 				r.logInfo("Cluster deleted", "component", "Clusters", "cluster", clusterID)
+				r.touch("Cluster", clusterID, true)
 				mu.Lock()
 				deleted++
 				mu.Unlock()
@@ -763,18 +1466,66 @@ func (r *Reconciler) DeleteClusters(dir string) {
 			final = append(final, cluster)
 		}
 	}
-	// Then add unmanaged clusters
+	// Then add unmanaged clusters, those kept by their policy and those
+	// waiting for confirmation
 	final = append(final, unmanaged...)
+	final = append(final, retained...)
+	final = append(final, pending...)
 
 	r.state.SetClusters(final)
 
-	if deleted == 0 && failed == 0 {
+	if deleted == 0 && failed == 0 && len(pending) == 0 {
 		r.logInfo("No clusters to delete", "component", "Clusters")
 	} else {
-		r.logInfo("Cluster delete result", "component", "Clusters", "deleted", deleted, "failed", failed)
+		r.logInfo("Cluster delete result", "component", "Clusters", "deleted", deleted, "failed", failed, "pending", len(pending))
 	}
 }
 
+// keepRemovedCluster decides whether a managed cluster that left Git stays
+// in Omni, from live, its exported template. It returns the state entry of
+// a kept cluster, or nil when the cluster may be deleted. A cluster that
+// could not be exported is kept, since its policy is unknown. Outside the
+// cluster's sync windows it is kept too, unless overrideWindow is set.
+func (r *Reconciler) keepRemovedCluster(id, live string, exported, overrideWindow bool) *state.ResourceInfo {
+	if !exported {
+		r.logError("Could not export cluster, not deleting", "component", "Clusters", "cluster", id)
+		return &state.ResourceInfo{
+			ID:     id,
+			Type:   "Cluster",
+			Status: "outofsync",
+			Error:  "Removed from Git but not deleted: the live cluster could not be exported to read its policy.",
+		}
+	}
+	policy, err := liveClusterPolicy(live)
+	if err != nil {
+		r.logError("Invalid policy on cluster, not deleting", "component", "Clusters", "cluster", id, "error", err)
+		return &state.ResourceInfo{
+			ID:     id,
+			Type:   "Cluster",
+			Status: "outofsync",
+			Error:  "Removed from Git but not deleted: " + err.Error(),
+		}
+	}
+	if res := retainedResource("Cluster", id, policy); res != nil {
+		r.logInfo("Cluster not in Git, kept by policy", "component", "Clusters", "cluster", id, "policy", strings.Join(policy.Labels(), ", "))
+		return res
+	}
+	if overrideWindow {
+		return nil
+	}
+	if open, next := r.clusterWindow(policy); !open {
+		r.logWarn("Cluster not in Git, waiting for sync window to delete", "component", "Clusters", "cluster", id, "next_window", formatWindow(next))
+		res := waitForWindow(state.ResourceInfo{
+			ID:     id,
+			Type:   "Cluster",
+			Policy: policy.Labels(),
+			Diff:   "Removed from Git. Deleted once the sync window opens.",
+		}, next)
+		return &res
+	}
+	return nil
+}
+
 // ============================================================
 // Clusters — Detect Unmanaged
 // ============================================================
@@ -782,10 +1533,15 @@ func (r *Reconciler) DeleteClusters(dir string) {
 // collectUnmanagedClusters finds clusters in Omni that are not managed by
 // cluster templates and adds them to state with "unmanaged" status.
 // Also removes clusters from state that are no longer in git or Omni.
-func (r *Reconciler) collectUnmanagedClusters(dir string) {
-	desiredIDs := collectClusterIDs(dir)
+// kept holds entries the caller already made for clusters that left Git;
+// they replace the entry collectUnmanagedClusters would make.
+func (r *Reconciler) collectUnmanagedClusters(ctx context.Context, dir string, kept []state.ResourceInfo) {
+	desiredIDs, err := collectClusterIDs(dir)
+	if err != nil {
+		return
+	}
 
-	allIDs, err := omni.GetClusterIDs()
+	allIDs, err := r.client.GetClusterIDs(ctx)
 	if err != nil {
 		return
 	}
@@ -803,6 +1559,11 @@ func (r *Reconciler) collectUnmanagedClusters(dir string) {
 		omniMap[id] = true
 	}
 
+	keptMap := make(map[string]state.ResourceInfo, len(kept))
+	for _, res := range kept {
+		keptMap[res.ID] = res
+	}
+
 	// Build final state:
 	// 1. Keep clusters that are in git (already processed by Apply/Diff)
 	// 2. Mark managed clusters not in git as "outofsync" (removed from git but still managed)
@@ -818,10 +1579,10 @@ func (r *Reconciler) collectUnmanagedClusters(dir string) {
 			// Skip - this cluster has been deleted
 		} else {
 			// In Omni but not in git - check if it's template-managed
-			isManaged := omni.IsClusterTemplateManaged(cluster.ID)
-			if isManaged {
-				cluster.Status = "outofsync"
-				cluster.Diff = "Cluster template removed from git. Force sync to delete this cluster."
+			if res, ok := keptMap[cluster.ID]; ok {
+				cluster = res
+			} else if r.client.IsClusterTemplateManaged(ctx, cluster.ID) {
+				cluster = r.removedCluster(ctx, cluster)
 			} else {
 				cluster.Status = "unmanaged"
 				cluster.Diff = ""
@@ -848,14 +1609,10 @@ func (r *Reconciler) collectUnmanagedClusters(dir string) {
 		}
 
 		// Check if this is a managed or unmanaged cluster
-		isManaged := omni.IsClusterTemplateManaged(id)
-		if isManaged {
-			final = append(final, state.ResourceInfo{
-				ID:     id,
-				Type:   "Cluster",
-				Status: "outofsync",
-				Diff:   "Cluster template removed from git. Force sync to delete this cluster.",
-			})
+		if res, ok := keptMap[id]; ok {
+			final = append(final, res)
+		} else if r.client.IsClusterTemplateManaged(ctx, id) {
+			final = append(final, r.removedCluster(ctx, state.ResourceInfo{ID: id, Type: "Cluster"}))
 		} else {
 			final = append(final, state.ResourceInfo{
 				ID:     id,
@@ -926,75 +1683,155 @@ func clusterDetailFromLive(liveContent string) (talos, k8s string, cp state.Node
 	return
 }
 
-// extractAllIDs extracts all resource ids from a YAML file.
+// foreignResource returns why a live resource is not omni-cd's to manage,
+// or "" when it is. Resources created by a cluster template sync or owned
+// by an Omni controller belong to those.
+func foreignResource(live string) string {
+	if live == "" {
+		return ""
+	}
+	resources, err := model.ParseResources([]byte(live))
+	if err != nil || len(resources) == 0 {
+		return "unreadable live state"
+	}
+	meta := resources[0].Metadata
+	if _, ok := meta.Annotations[model.AnnotationClusterTemplateManaged]; ok {
+		return "cluster template"
+	}
+	if meta.Owner != "" {
+		return "owned by " + meta.Owner
+	}
+	return ""
+}
+
+// loadMachineClasses parses the machine classes defined in a YAML file.
 // Supports multi-document YAML files separated by ---.
-func extractAllIDs(file string) []string {
+func loadMachineClasses(file string) ([]model.MachineClass, error) {
 	data, err := os.ReadFile(file)
 	if err != nil {
-		return nil
+		return nil, err
 	}
+	return model.ParseMachineClasses(data)
+}
 
-	var ids []string
-	for _, line := range strings.Split(string(data), "\n") {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "id:") {
-			parts := strings.Fields(trimmed)
-			if len(parts) >= 2 {
-				ids = append(ids, parts[1])
-			}
+// liveMachineClass returns the live YAML of a machine class from the batch
+// fetch, falling back to an individual fetch when the batch fetch failed.
+// Returns "" when the machine class does not exist.
+func (r *Reconciler) liveMachineClass(ctx context.Context, batch map[string]string, id string) string {
+	if batch != nil {
+		return batch[id]
+	}
+	live, _ := r.client.GetLiveMachineClass(ctx, id)
+	return live
+}
+
+// diffDocuments compares the desired document of each id in fileContent
+// with its live YAML. It returns the per-id diffs and live contents, and
+// whether any resource needs to be applied.
+func (r *Reconciler) diffDocuments(component, fileContent string, ids []string, live func(id string) string) (diffs, liveContents map[string]string, changed bool) {
+	docs, _ := model.DocumentsByID(fileContent)
+	diffs = make(map[string]string, len(ids))
+	liveContents = make(map[string]string, len(ids))
+	for _, id := range ids {
+		liveContents[id] = live(id)
+		diff, err := model.Diff(docs[id], liveContents[id])
+		if err != nil {
+			// Cannot tell whether it changed, so let the apply decide
+			r.logWarn("Failed to diff resource", "component", component, "id", id, "error", err)
+			diff = docs[id]
+		}
+		diffs[id] = diff
+		if diff != "" {
+			changed = true
 		}
 	}
-	return ids
+	return diffs, liveContents, changed
 }
 
-// extractClusterName extracts the cluster name from the 'name' field
-// in a cluster.yaml template file.
-func extractClusterName(file string) string {
-	data, err := os.ReadFile(file)
+// machineClassIDs returns the ids of all machine classes defined in a YAML file.
+func machineClassIDs(file string) ([]string, error) {
+	classes, err := loadMachineClasses(file)
 	if err != nil {
-		return ""
+		return nil, err
+	}
+	ids := make([]string, len(classes))
+	for i, mc := range classes {
+		ids[i] = mc.Metadata.ID
 	}
+	return ids, nil
+}
 
-	for _, line := range strings.Split(string(data), "\n") {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "name:") {
-			parts := strings.Fields(trimmed)
-			if len(parts) >= 2 {
-				return parts[1]
-			}
-		}
+// templateClusterName returns the name of the Cluster document in a
+// cluster.yaml template file.
+func templateClusterName(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
 	}
-	return ""
+	tmpl, err := model.ParseTemplate(data)
+	if err != nil {
+		return "", err
+	}
+	return tmpl.ClusterName(), nil
 }
 
 // collectMachineClassIDs returns all desired machine class IDs from the Git repo.
-func collectMachineClassIDs(dir string) []string {
+// It fails if any file cannot be parsed, so callers never mistake a broken
+// file for removed machine classes.
+func collectMachineClassIDs(dir string) ([]string, error) {
 	files, err := findYAMLFiles(dir)
 	if err != nil {
-		return nil
+		return nil, nil
 	}
 
 	var ids []string
 	for _, f := range files {
-		ids = append(ids, extractAllIDs(f)...)
+		fileIDs, err := machineClassIDs(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		ids = append(ids, fileIDs...)
 	}
-	return ids
+	return ids, nil
 }
 
 // collectClusterIDs returns all desired cluster names from the Git repo.
-func collectClusterIDs(dir string) []string {
+// It fails if any template cannot be parsed.
+func collectClusterIDs(dir string) ([]string, error) {
 	templates, err := findClusterTemplates(dir)
 	if err != nil {
-		return nil
+		return nil, nil
 	}
 
 	var ids []string
 	for _, t := range templates {
-		if name := extractClusterName(t); name != "" {
+		name, err := templateClusterName(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(filepath.Dir(t)), err)
+		}
+		if name != "" {
 			ids = append(ids, name)
 		}
 	}
-	return ids
+	return ids, nil
+}
+
+// mergeScopedResources combines the resources produced by a scoped run with
+// the existing list. Existing entries that were not reprocessed are kept as
+// long as they are still desired in Git.
+func mergeScopedResources(existing, updated []state.ResourceInfo, desiredIDs []string) []state.ResourceInfo {
+	updatedIDs := make(map[string]bool, len(updated))
+	for _, res := range updated {
+		updatedIDs[res.ID] = true
+	}
+
+	final := make([]state.ResourceInfo, 0, len(existing)+len(updated))
+	for _, res := range existing {
+		if !updatedIDs[res.ID] && contains(desiredIDs, res.ID) {
+			final = append(final, res)
+		}
+	}
+	return append(final, updated...)
 }
 
 // contains checks if a string slice contains a value.
@@ -1109,7 +1946,7 @@ func formatLogMessage(level, msg string, attrs ...any) string {
 		}
 	}
 
-	return strings.Join(jsonParts, ",") + "}"
+	return redact.String(strings.Join(jsonParts, ",") + "}")
 }
 
 // readFileContent reads and returns the content of a file, or empty string on error.
@@ -1120,20 +1957,3 @@ func readFileContent(file string) string {
 	}
 	return string(data)
 }
-
-// detectProvisionType determines if a machine class uses auto or manual provisioning.
-// Auto provision files contain "providerid:" in the autoprovision spec.
-func detectProvisionType(file string) string {
-	data, err := os.ReadFile(file)
-	if err != nil {
-		return ""
-	}
-
-	content := string(data)
-	// Auto provision has "providerid:" field
-	if strings.Contains(content, "providerid:") {
-		return "auto"
-	}
-	// Manual provision uses matchlabels or has autoprovision: null
-	return "manual"
-}
@@ -0,0 +1,468 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"omni-cd/internal/omni/omnitest"
+	"omni-cd/internal/state"
+)
+
+const (
+	mcWorkers = `metadata:
+  namespace: default
+  type: MachineClasses.omni.sidero.dev
+  id: workers
+spec:
+  matchlabels:
+    - role = worker
+`
+	mcControlPlane = `metadata:
+  namespace: default
+  type: MachineClasses.omni.sidero.dev
+  id: control-plane
+spec:
+  matchlabels:
+    - role = controlplane
+`
+	clusterProd = `kind: Cluster
+name: prod
+kubernetes:
+  version: v1.31.1
+talos:
+  version: v1.8.0
+---
+kind: ControlPlane
+machineClass:
+  name: control-plane
+  size: 3
+---
+kind: Workers
+name: workers
+machineClass:
+  name: workers
+  size: 2
+`
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testEnv is a Git checkout on disk plus a fake Omni behind a Reconciler.
+type testEnv struct {
+	t     *testing.T
+	repo  string
+	fake  *omnitest.Fake
+	state *state.AppState
+	rec   *Reconciler
+}
+
+func newTestEnv(t *testing.T, clustersEnabled bool) *testEnv {
+	t.Helper()
+	repo := t.TempDir()
+	for _, dir := range []string{"machine-classes", "clusters"} {
+		if err := os.MkdirAll(filepath.Join(repo, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appState := state.New(500, "https://omni.example.com", clustersEnabled, filepath.Join(t.TempDir(), "state.json"))
+	fake := omnitest.New()
+	return &testEnv{t: t, repo: repo, fake: fake, state: appState, rec: New(appState, fake)}
+}
+
+func (e *testEnv) mcDir() string       { return filepath.Join(e.repo, "machine-classes") }
+func (e *testEnv) clustersDir() string { return filepath.Join(e.repo, "clusters") }
+func (e *testEnv) patchesDir() string  { return filepath.Join(e.repo, "config-patches") }
+func (e *testEnv) accessDir() string   { return filepath.Join(e.repo, "access") }
+func (e *testEnv) machinesDir() string { return filepath.Join(e.repo, "machines") }
+
+// write creates or replaces a file relative to the repository root.
+func (e *testEnv) write(rel, content string) {
+	e.t.Helper()
+	path := filepath.Join(e.repo, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// remove deletes a file or directory relative to the repository root.
+func (e *testEnv) remove(rel string) {
+	e.t.Helper()
+	if err := os.RemoveAll(filepath.Join(e.repo, rel)); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// reconcile runs a full sync of every resource kind, as main does.
+func (e *testEnv) reconcile() {
+	e.rec.SetScope(e.repo, nil)
+	e.rec.Reconcile(context.Background(), Paths{
+		Machines:       e.machinesDir(),
+		MachineClasses: e.mcDir(),
+		Clusters:       e.clustersDir(),
+		ConfigPatches:  e.patchesDir(),
+		Access:         e.accessDir(),
+	})
+}
+
+func statusOf(resources []state.ResourceInfo, id string) string {
+	for _, r := range resources {
+		if r.ID == id {
+			return r.Status
+		}
+	}
+	return ""
+}
+
+func TestApplyMachineClasses(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantCalls []string
+		wantIDs   []string
+		wantState map[string]string
+	}{
+		{
+			name:      "new machine classes are applied",
+			files:     map[string]string{"machine-classes/all.yaml": mcWorkers + "---\n" + mcControlPlane},
+			wantCalls: []string{"Apply/control-plane", "Apply/workers"},
+			wantIDs:   []string{"control-plane", "workers"},
+			wantState: map[string]string{"workers": "success", "control-plane": "success"},
+		},
+		{
+			name:      "duplicate ids across files are not applied",
+			files:     map[string]string{"machine-classes/a.yaml": mcWorkers, "machine-classes/b.yaml": mcWorkers},
+			wantState: map[string]string{"workers": "outofsync"},
+		},
+		{
+			name: "invalid file does not block valid ones",
+			files: map[string]string{
+				"machine-classes/good.yaml": mcWorkers,
+				"machine-classes/bad.yaml":  "metadata:\n  id: [broken\n",
+			},
+			wantCalls: []string{"Apply/workers"},
+			wantIDs:   []string{"workers"},
+			wantState: map[string]string{"workers": "success"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, true)
+			for rel, content := range tt.files {
+				env.write(rel, content)
+			}
+			env.rec.ApplyMachineClasses(context.Background(), env.mcDir())
+
+			if got := env.fake.Calls(); !reflect.DeepEqual(got, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", got, tt.wantCalls)
+			}
+			if got := env.fake.MachineClassIDs(); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("machine classes in Omni = %v, want %v", got, tt.wantIDs)
+			}
+			mcs := env.state.GetMachineClasses()
+			for id, want := range tt.wantState {
+				if got := statusOf(mcs, id); got != want {
+					t.Errorf("status of %s = %q, want %q", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyMachineClassesIsIdempotent(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcWorkers)
+
+	env.rec.ApplyMachineClasses(context.Background(), env.mcDir())
+	env.fake.ResetCalls()
+	env.rec.ApplyMachineClasses(context.Background(), env.mcDir())
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("unchanged machine class was applied again: %v", calls)
+	}
+
+	env.write("machine-classes/workers.yaml", mcWorkers+"    - zone = a\n")
+	env.rec.ApplyMachineClasses(context.Background(), env.mcDir())
+	if got, want := env.fake.Calls(), []string{"Apply/workers"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	want := "--- live\n+++ git\n+ spec.matchlabels[1]: zone = a"
+	if got := env.state.GetMachineClasses()[0].Diff; got != want {
+		t.Errorf("diff = %q, want %q", got, want)
+	}
+}
+
+func TestApplyMachineClassesTouchesChangedOnly(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/all.yaml", mcWorkers+"---\n"+mcControlPlane)
+	env.reconcile()
+
+	env.write("machine-classes/all.yaml", mcWorkers+"    - zone = a\n---\n"+mcControlPlane)
+	env.reconcile()
+	if resources, _ := env.rec.Touched(); !reflect.DeepEqual(resources, []string{"MachineClass/workers"}) {
+		t.Errorf("Touched() = %v, want [MachineClass/workers]", resources)
+	}
+}
+
+func TestPruneMachineClasses(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.write("machine-classes/control-plane.yaml", mcControlPlane)
+	env.write("machine-classes/spare.yaml", `metadata:
+  id: spare
+spec:
+  matchlabels:
+    - role = spare
+`)
+	env.write("clusters/prod/cluster.yaml", clusterProd)
+	env.reconcile()
+
+	// Remove a machine class that is in use and one that is not
+	env.remove("machine-classes/workers.yaml")
+	env.remove("machine-classes/spare.yaml")
+	env.fake.ResetCalls()
+	env.reconcile()
+
+	if got, want := env.fake.Calls(), []string{"DeleteMachineClass/spare"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if got, want := env.fake.MachineClassIDs(), []string{"control-plane", "workers"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("machine classes in Omni = %v, want %v (in-use class must survive)", got, want)
+	}
+}
+
+func TestPruneSkippedWhenFileCannotBeParsed(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.reconcile()
+
+	env.write("machine-classes/workers.yaml", "metadata:\n  id: [broken\n")
+	env.fake.ResetCalls()
+	env.rec.DeleteMachineClasses(context.Background(), env.mcDir(), env.clustersDir())
+
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("machine class deleted although its file failed to parse: %v", calls)
+	}
+}
+
+func TestApplyClusters(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/all.yaml", mcWorkers+"---\n"+mcControlPlane)
+	env.write("clusters/prod/cluster.yaml", clusterProd)
+	env.reconcile()
+
+	if got, want := env.fake.ClusterIDs(), []string{"prod"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("clusters in Omni = %v, want %v", got, want)
+	}
+	clusters := env.state.GetClusters()
+	if got := statusOf(clusters, "prod"); got != "success" {
+		t.Errorf("status of prod = %q, want success", got)
+	}
+	for _, c := range clusters {
+		if c.ID == "prod" && (c.ControlPlane.Count != 3 || c.ControlPlane.MachineClass != "control-plane" || c.TalosVersion != "v1.8.0") {
+			t.Errorf("cluster details not parsed from live template: %+v", c)
+		}
+	}
+
+	// No change in Git means no sync
+	env.fake.ResetCalls()
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("unchanged cluster was synced again: %v", calls)
+	}
+}
+
+func TestApplyClustersValidationFailureKeepsCluster(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("clusters/prod/cluster.yaml", clusterProd)
+	env.reconcile()
+
+	// A template without a ControlPlane document fails validation
+	env.write("clusters/prod/cluster.yaml", "kind: Cluster\nname: prod\n")
+	env.fake.ResetCalls()
+	env.reconcile()
+
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("invalid template caused changes: %v", calls)
+	}
+	if got := statusOf(env.state.GetClusters(), "prod"); got != "failed" {
+		t.Errorf("status of prod = %q, want failed", got)
+	}
+	if got := env.fake.ClusterIDs(); len(got) != 1 {
+		t.Errorf("clusters in Omni = %v, want prod to remain", got)
+	}
+}
+
+func TestApplyClustersSyncFailure(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("clusters/prod/cluster.yaml", clusterProd)
+	env.fake.FailOn("ClusterTemplateSync", "prod", errors.New("machine allocation failed"))
+	env.reconcile()
+
+	if got := statusOf(env.state.GetClusters(), "prod"); got != "failed" {
+		t.Errorf("status of prod = %q, want failed", got)
+	}
+	if resources, failed := env.rec.Touched(); !failed || !reflect.DeepEqual(resources, []string{"Cluster/prod"}) {
+		t.Errorf("Touched() = %v, %v; want [Cluster/prod], true", resources, failed)
+	}
+}
+
+func TestDiffClustersWhenSyncDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	env.fake.AddCluster("prod", clusterProd, true)
+	env.write("clusters/prod/cluster.yaml", clusterProd)
+	env.write("clusters/staging/cluster.yaml", `kind: Cluster
+name: staging
+---
+kind: ControlPlane
+machines:
+  - 11111111-2222-3333-4444-555555555555
+`)
+	env.reconcile()
+
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("diff-only reconcile made changes: %v", calls)
+	}
+	clusters := env.state.GetClusters()
+	if got := statusOf(clusters, "prod"); got != "success" {
+		t.Errorf("status of prod = %q, want success", got)
+	}
+	if got := statusOf(clusters, "staging"); got != "outofsync" {
+		t.Errorf("status of staging = %q, want outofsync", got)
+	}
+}
+
+func TestPruneClusters(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.fake.AddCluster("manual", clusterProd, false)
+	env.write("clusters/prod/cluster.yaml", clusterProd)
+	env.write("clusters/old/cluster.yaml", "kind: Cluster\nname: old\n---\nkind: ControlPlane\nmachines: []\n")
+	env.reconcile()
+
+	env.remove("clusters/old")
+	env.fake.ResetCalls()
+	env.reconcile()
+
+	if got, want := env.fake.Calls(), []string{"DeleteCluster/old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	clusters := env.state.GetClusters()
+	if got := statusOf(clusters, "manual"); got != "unmanaged" {
+		t.Errorf("status of manual = %q, want unmanaged", got)
+	}
+	if got := statusOf(clusters, "old"); got != "" {
+		t.Errorf("deleted cluster still in state with status %q", got)
+	}
+}
+
+func TestForceSync(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(env *testEnv)
+		beforeForce  func(env *testEnv)
+		force        []string
+		wantCalls    []string
+		wantClusters []string
+	}{
+		{
+			name: "in-sync cluster is synced anyway",
+			setup: func(env *testEnv) {
+				env.write("clusters/prod/cluster.yaml", clusterProd)
+				env.write("clusters/staging/cluster.yaml", "kind: Cluster\nname: staging\n---\nkind: ControlPlane\nmachines: []\n")
+			},
+			force:        []string{"prod"},
+			wantCalls:    []string{"ClusterTemplateSync/prod"},
+			wantClusters: []string{"prod", "staging"},
+		},
+		{
+			name: "several clusters are synced and deleted together",
+			setup: func(env *testEnv) {
+				env.write("clusters/prod/cluster.yaml", clusterProd)
+				env.write("clusters/staging/cluster.yaml", "kind: Cluster\nname: staging\n---\nkind: ControlPlane\nmachines: []\n")
+			},
+			beforeForce: func(env *testEnv) {
+				env.fake.AddCluster("gone", "kind: Cluster\nname: gone\n", true)
+			},
+			force:        []string{"gone", "prod"},
+			wantCalls:    []string{"DeleteCluster/gone", "ClusterTemplateSync/prod"},
+			wantClusters: []string{"prod", "staging"},
+		},
+		{
+			name: "managed cluster removed from Git is deleted",
+			setup: func(env *testEnv) {
+				env.write("clusters/prod/cluster.yaml", clusterProd)
+			},
+			// Added after the initial full sync, which would prune it
+			beforeForce: func(env *testEnv) {
+				env.fake.AddCluster("gone", "kind: Cluster\nname: gone\n", true)
+			},
+			force:        []string{"gone"},
+			wantCalls:    []string{"DeleteCluster/gone"},
+			wantClusters: []string{"prod"},
+		},
+		{
+			name: "unmanaged cluster is never deleted",
+			setup: func(env *testEnv) {
+				env.write("clusters/prod/cluster.yaml", clusterProd)
+				env.fake.AddCluster("manual", "kind: Cluster\nname: manual\n", false)
+			},
+			force:        []string{"manual"},
+			wantClusters: []string{"manual", "prod"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Cluster sync is disabled; force sync must still act on the target
+			env := newTestEnv(t, false)
+			tt.setup(env)
+			env.state.SetClustersEnabled(true)
+			env.reconcile()
+			env.state.SetClustersEnabled(false)
+			if tt.beforeForce != nil {
+				tt.beforeForce(env)
+			}
+			env.fake.ResetCalls()
+
+			env.rec.SetForce(Force{Clusters: tt.force})
+			env.reconcile()
+
+			if got := env.fake.Calls(); !reflect.DeepEqual(got, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", got, tt.wantCalls)
+			}
+			if got := env.fake.ClusterIDs(); !reflect.DeepEqual(got, tt.wantClusters) {
+				t.Errorf("clusters in Omni = %v, want %v", got, tt.wantClusters)
+			}
+		})
+	}
+}
+
+func TestScopedApplyOnlyTouchesChangedFiles(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.write("machine-classes/workers.yaml", mcWorkers)
+	env.write("machine-classes/control-plane.yaml", mcControlPlane)
+	env.reconcile()
+
+	env.write("machine-classes/workers.yaml", mcWorkers+"    - zone = b\n")
+	env.write("machine-classes/control-plane.yaml", mcControlPlane+"    - zone = b\n")
+	env.fake.ResetCalls()
+
+	env.rec.SetScope(env.repo, []string{"machine-classes/workers.yaml"})
+	env.rec.ApplyMachineClasses(context.Background(), env.mcDir())
+
+	if got, want := env.fake.Calls(), []string{"Apply/workers"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if got := statusOf(env.state.GetMachineClasses(), "control-plane"); got != "success" {
+		t.Errorf("out-of-scope machine class lost from state, status %q", got)
+	}
+}
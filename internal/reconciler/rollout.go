@@ -0,0 +1,116 @@
+package reconciler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"omni-cd/internal/model"
+)
+
+// ============================================================
+// Progressive Rollout
+// ============================================================
+
+// defaultHealthTimeout is how long a wave may take to become healthy when
+// no timeout is configured.
+const defaultHealthTimeout = 15 * time.Minute
+
+// Rollout controls how cluster changes are rolled out in waves. Clusters
+// are grouped by their omni-cd/wave label and the waves are synced one
+// after the other, lowest first. Before a wave starts, every cluster of the
+// previous one has to be healthy.
+type Rollout struct {
+	// HealthTimeout is how long the clusters of a wave may take to report
+	// Ready and KubernetesAPIReady before the rollout halts.
+	HealthTimeout time.Duration
+	// WaveFromDirectory takes the wave of a cluster without the label from
+	// the number its directory name starts with, e.g. "2-prod-eu".
+	WaveFromDirectory bool
+}
+
+// clusterJob syncs one cluster template as part of a rollout wave.
+type clusterJob struct {
+	tmpl string
+	name string
+	run  func()
+}
+
+// SetRollout sets how cluster changes are rolled out.
+func (r *Reconciler) SetRollout(ro Rollout) {
+	r.rollout = ro
+}
+
+// templateWave returns the rollout wave of a cluster template. Clusters
+// without a wave are in wave 0.
+func (r *Reconciler) templateWave(file string) (int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	tmpl, err := model.ParseTemplate(data)
+	if err != nil {
+		return 0, err
+	}
+	wave, ok, err := tmpl.Wave()
+	if err != nil || ok || !r.rollout.WaveFromDirectory {
+		return wave, err
+	}
+
+	dir := filepath.Base(filepath.Dir(file))
+	end := 0
+	for end < len(dir) && dir[end] >= '0' && dir[end] <= '9' {
+		end++
+	}
+	wave, _ = strconv.Atoi(dir[:end])
+	return wave, nil
+}
+
+// sortedWaves returns the waves in the order they are rolled out.
+func sortedWaves[T any](waves map[int]T) []int {
+	order := make([]int, 0, len(waves))
+	for w := range waves {
+		order = append(order, w)
+	}
+	sort.Ints(order)
+	return order
+}
+
+// waitHealthy polls Omni until every one of the clusters reports Ready and
+// KubernetesAPIReady, and returns those that still do not when the health
+// timeout expires or ctx is done.
+func (r *Reconciler) waitHealthy(ctx context.Context, ids []string) []string {
+	timeout := r.rollout.HealthTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	interval := r.healthInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		var unhealthy []string
+		statuses, err := r.client.GetAllClusterReadyStatuses(ctx)
+		if err == nil {
+			r.state.UpdateClusterReadyStatuses(statuses)
+		}
+		for _, id := range ids {
+			if st, ok := statuses[id]; !ok || !st.Ready || !st.KubernetesAPIReady {
+				unhealthy = append(unhealthy, id)
+			}
+		}
+		if len(unhealthy) == 0 || time.Now().After(deadline) || r.stopped(ctx) {
+			sort.Strings(unhealthy)
+			return unhealthy
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+		}
+	}
+}
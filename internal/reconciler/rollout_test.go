@@ -0,0 +1,109 @@
+package reconciler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waveCluster returns a cluster template without machine classes in the
+// given rollout wave.
+func waveCluster(name, wave string) string {
+	return "kind: Cluster\nname: " + name + "\nlabels:\n  omni-cd/wave: \"" + wave + "\"\n---\nkind: ControlPlane\nmachines: []\n"
+}
+
+func TestRolloutWaves(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.healthInterval = time.Millisecond
+	env.write("clusters/prod/cluster.yaml", waveCluster("prod", "2"))
+	env.write("clusters/staging/cluster.yaml", waveCluster("staging", "1"))
+	env.write("clusters/canary/cluster.yaml", staticCluster("canary"))
+	env.reconcile()
+
+	// One cluster per wave, so the order of the syncs is the wave order
+	want := []string{"ClusterTemplateSync/canary", "ClusterTemplateSync/staging", "ClusterTemplateSync/prod"}
+	if got := env.fake.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestRolloutHaltsOnUnhealthyWave(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.SetRollout(Rollout{HealthTimeout: 20 * time.Millisecond})
+	env.rec.healthInterval = time.Millisecond
+	env.write("clusters/canary/cluster.yaml", waveCluster("canary", "0"))
+	env.write("clusters/prod/cluster.yaml", waveCluster("prod", "1"))
+	env.fake.AddCluster("canary", "", true)
+	env.fake.SetClusterHealthy("canary", false)
+	env.reconcile()
+
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/canary"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	for _, c := range env.state.GetClusters() {
+		if c.ID == "prod" && (c.Status != "blocked" || !reflect.DeepEqual(c.BlockedBy, []string{"canary"})) {
+			t.Errorf("prod = status %q, blocked by %v; want blocked by [canary]", c.Status, c.BlockedBy)
+		}
+	}
+
+	// The rollout continues once the wave is healthy
+	env.fake.SetClusterHealthy("canary", true)
+	env.fake.ResetCalls()
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestRolloutGatesOnSyncedClusters(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.SetRollout(Rollout{HealthTimeout: time.Hour})
+	env.rec.healthInterval = time.Millisecond
+	env.write("clusters/canary/cluster.yaml", waveCluster("canary", "0"))
+	env.write("clusters/prod/cluster.yaml", waveCluster("prod", "1"))
+	env.reconcile()
+
+	// An unhealthy cluster that this reconcile left alone does not gate
+	env.fake.SetClusterHealthy("canary", false)
+	env.write("clusters/prod/cluster.yaml", waveCluster("prod", "1")+"---\nkind: Workers\nmachines: []\n")
+	env.fake.ResetCalls()
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestRolloutHaltsOnFailedSync(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.healthInterval = time.Millisecond
+	env.write("clusters/canary/cluster.yaml", waveCluster("canary", "0"))
+	env.write("clusters/prod/cluster.yaml", waveCluster("prod", "1"))
+	env.fake.FailOn("ClusterTemplateSync", "canary", errors.New("sync failed"))
+	env.reconcile()
+
+	// Failed calls are not recorded
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("later wave synced after a failure: %v", calls)
+	}
+	prod := entryOf(env.state.GetClusters(), "prod")
+	if prod.Status != "blocked" || !reflect.DeepEqual(prod.BlockedBy, []string{"canary"}) {
+		t.Errorf("prod = status %q, blocked by %v; want blocked by [canary]", prod.Status, prod.BlockedBy)
+	}
+}
+
+func TestRolloutWaveFromDirectory(t *testing.T) {
+	env := newTestEnv(t, true)
+	env.rec.SetRollout(Rollout{WaveFromDirectory: true})
+	env.rec.healthInterval = time.Millisecond
+	env.write("clusters/10-prod/cluster.yaml", staticCluster("prod"))
+	env.write("clusters/2-staging/cluster.yaml", staticCluster("staging"))
+	env.write("clusters/labelled/cluster.yaml", waveCluster("labelled", "5"))
+	env.write("clusters/canary/cluster.yaml", staticCluster("canary"))
+	env.reconcile()
+
+	want := []string{"ClusterTemplateSync/canary", "ClusterTemplateSync/staging", "ClusterTemplateSync/labelled", "ClusterTemplateSync/prod"}
+	if got := env.fake.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package reconciler
+
+import (
+	"time"
+
+	"omni-cd/internal/model"
+	"omni-cd/internal/state"
+	"omni-cd/internal/syncwindow"
+)
+
+// ============================================================
+// Sync Windows
+// ============================================================
+
+// SetSyncWindows sets the windows that restrict when clusters are synced
+// and deleted. Their cron schedules, and those a cluster declares with
+// omni-cd/sync-window, are evaluated in loc.
+func (r *Reconciler) SetSyncWindows(ws syncwindow.Windows, loc *time.Location) {
+	r.syncWindows = ws
+	r.windowLocation = loc
+}
+
+// clusterWindow reports whether a cluster with the given policy may be
+// changed now, and if not, when it next may (zero when no window opens
+// within a year).
+func (r *Reconciler) clusterWindow(p model.Policy) (bool, time.Time) {
+	// A cluster's own windows restrict the global ones further
+	ws := syncwindow.All{r.syncWindows}
+	if p.SyncWindow != "" {
+		// Already checked by model.PolicyFromAnnotations
+		own, _ := syncwindow.Parse(p.SyncWindow)
+		ws = append(ws, own)
+	}
+
+	loc := r.windowLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	t := now().In(loc)
+	if ws.Open(t) {
+		return true, time.Time{}
+	}
+	return false, ws.NextOpen(t)
+}
+
+// waitForWindow marks a cluster state entry as out of sync until the sync
+// window that opens at next.
+func waitForWindow(res state.ResourceInfo, next time.Time) state.ResourceInfo {
+	res.Status = "outofsync"
+	res.WaitingForWindow = true
+	res.NextWindow = nil
+	if !next.IsZero() {
+		res.NextWindow = &next
+	}
+	return res
+}
+
+// formatWindow formats the start of the next sync window for logs.
+func formatWindow(next time.Time) string {
+	if next.IsZero() {
+		return "none within a year"
+	}
+	return next.Format(time.RFC3339)
+}
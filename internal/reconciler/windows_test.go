@@ -0,0 +1,130 @@
+package reconciler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"omni-cd/internal/syncwindow"
+)
+
+// setWindows sets the global sync windows and fixes the clock at now.
+func (e *testEnv) setWindows(spec string, now time.Time) {
+	e.t.Helper()
+	ws, err := syncwindow.Parse(spec)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.rec.SetSyncWindows(ws, time.UTC)
+	e.rec.now = func() time.Time { return now }
+}
+
+func TestSyncWindowHoldsClusterChanges(t *testing.T) {
+	// Thursday noon, outside the Saturday night window
+	now := time.Date(2026, time.October, 15, 12, 0, 0, 0, time.UTC)
+	next := time.Date(2026, time.October, 17, 22, 0, 0, 0, time.UTC)
+
+	env := newTestEnv(t, true)
+	env.setWindows("allow 0 22 * * 6 4h", now)
+	env.write("clusters/dev/cluster.yaml", staticCluster("dev"))
+	env.reconcile()
+
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Fatalf("synced outside the window: %v", calls)
+	}
+	for _, c := range env.state.GetClusters() {
+		if c.ID != "dev" {
+			continue
+		}
+		if c.Status != "outofsync" || !c.WaitingForWindow {
+			t.Errorf("dev = status %q, waiting %v; want outofsync, waiting", c.Status, c.WaitingForWindow)
+		}
+		if c.NextWindow == nil || !c.NextWindow.Equal(next) {
+			t.Errorf("next window = %v, want %s", c.NextWindow, next)
+		}
+	}
+
+	// A force sync waits as well unless it overrides the window
+	env.rec.SetForce(Force{Clusters: []string{"dev"}})
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Fatalf("force sync without override ran outside the window: %v", calls)
+	}
+	env.rec.SetForce(Force{Clusters: []string{"dev"}, OverrideWindow: true})
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/dev"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	env.rec.SetForce(Force{})
+
+	// Once the window opens, changes are applied again
+	env.write("clusters/dev/cluster.yaml", staticCluster("dev")+"---\nkind: Workers\nmachines: []\n")
+	env.fake.ResetCalls()
+	env.rec.now = func() time.Time { return next.Add(time.Hour) }
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/dev"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestClusterSyncWindowAnnotation(t *testing.T) {
+	now := time.Date(2026, time.October, 15, 12, 0, 0, 0, time.UTC)
+	env := newTestEnv(t, true)
+	env.setWindows("", now)
+	env.write("clusters/prod/cluster.yaml", annotatedCluster("prod", `omni-cd/sync-window: "deny 0 9 * * 1-5 8h"`))
+	env.write("clusters/dev/cluster.yaml", staticCluster("dev"))
+	env.reconcile()
+
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/dev"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+
+	// Deleting a cluster waits for the windows it carries in Omni
+	env.fake.AddCluster("prod", annotatedCluster("prod", `omni-cd/sync-window: "deny 0 9 * * 1-5 8h"`), true)
+	env.remove("clusters/prod")
+	env.fake.ResetCalls()
+	env.reconcile()
+	if calls := env.fake.Calls(); len(calls) != 0 {
+		t.Errorf("deleted outside the window: %v", calls)
+	}
+	if got := statusOf(env.state.GetClusters(), "prod"); got != "outofsync" {
+		t.Errorf("status of prod = %q, want outofsync", got)
+	}
+
+	env.rec.now = func() time.Time { return now.Add(6 * time.Hour) }
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"DeleteCluster/prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestClusterSyncWindowNarrowsGlobal(t *testing.T) {
+	// Thursday 22:30, inside the nightly global window but outside the
+	// Saturday window prod declares
+	now := time.Date(2026, time.October, 15, 22, 30, 0, 0, time.UTC)
+	next := time.Date(2026, time.October, 17, 23, 0, 0, 0, time.UTC)
+
+	env := newTestEnv(t, true)
+	env.setWindows("allow 0 22 * * * 4h", now)
+	env.write("clusters/prod/cluster.yaml", annotatedCluster("prod", `omni-cd/sync-window: "allow 0 23 * * 6 1h"`))
+	env.write("clusters/dev/cluster.yaml", staticCluster("dev"))
+	env.reconcile()
+
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/dev"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	c := entryOf(env.state.GetClusters(), "prod")
+	if c.Status != "outofsync" || !c.WaitingForWindow {
+		t.Errorf("prod = status %q, waiting %v; want outofsync, waiting", c.Status, c.WaitingForWindow)
+	}
+	if c.NextWindow == nil || !c.NextWindow.Equal(next) {
+		t.Errorf("next window = %v, want %s", c.NextWindow, next)
+	}
+
+	env.fake.ResetCalls()
+	env.rec.now = func() time.Time { return next.Add(30 * time.Minute) }
+	env.reconcile()
+	if got, want := env.fake.Calls(), []string{"ClusterTemplateSync/prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
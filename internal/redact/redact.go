@@ -0,0 +1,111 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// placeholder replaces every occurrence of a registered secret.
+const placeholder = "[REDACTED]"
+
+// minSecretLen avoids scrubbing trivially short values (e.g. "1") that would
+// mangle unrelated log output.
+const minSecretLen = 4
+
+var (
+	mu      sync.RWMutex
+	secrets []string
+)
+
+// Register adds values that must never appear in logs, errors shown in the
+// web UI, or any other output passed through String.
+func Register(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) < minSecretLen {
+			continue
+		}
+		secrets = append(secrets, v)
+	}
+}
+
+// String returns s with every registered secret replaced by a placeholder.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, secret := range secrets {
+		if strings.Contains(s, secret) {
+			s = strings.ReplaceAll(s, secret, placeholder)
+		}
+	}
+	return s
+}
+
+// Handler wraps a slog.Handler and redacts the message and attribute values
+// of every record before passing it on.
+type Handler struct {
+	inner slog.Handler
+}
+
+// NewHandler returns a redacting wrapper around inner.
+func NewHandler(inner slog.Handler) *Handler {
+	return &Handler{inner: inner}
+}
+
+// Enabled reports whether the wrapped handler handles records at the given level.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+// Handle redacts the record and passes it to the wrapped handler.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, String(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.inner.Handle(ctx, out)
+}
+
+// WithAttrs returns a redacting handler with the given attributes added.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &Handler{inner: h.inner.WithAttrs(redacted)}
+}
+
+// WithGroup returns a redacting handler that nests attributes under name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{inner: h.inner.WithGroup(name)}
+}
+
+// redactAttr scrubs secrets from a single attribute, descending into groups.
+// Non-string values (errors, Stringers, ...) are only replaced by their
+// string form when that form actually contained a secret.
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, String(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, len(group))
+		for i, ga := range group {
+			redacted[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		s := fmt.Sprint(v.Any())
+		if r := String(s); r != s {
+			return slog.String(a.Key, r)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
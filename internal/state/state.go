@@ -1,13 +1,17 @@
 package state
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"omni-cd/internal/omni"
+	"omni-cd/internal/queue"
 )
 
 // ReconcileType identifies the type of reconciliation.
@@ -22,10 +26,11 @@ const (
 type ReconcileStatus string
 
 const (
-	StatusIdle    ReconcileStatus = "idle"
-	StatusRunning ReconcileStatus = "running"
-	StatusSuccess ReconcileStatus = "success"
-	StatusFailed  ReconcileStatus = "failed"
+	StatusIdle      ReconcileStatus = "idle"
+	StatusRunning   ReconcileStatus = "running"
+	StatusSuccess   ReconcileStatus = "success"
+	StatusFailed    ReconcileStatus = "failed"
+	StatusCancelled ReconcileStatus = "cancelled"
 )
 
 // OmniHealth holds the result of the last Omni connectivity check.
@@ -39,10 +44,52 @@ type OmniHealth struct {
 type GitInfo struct {
 	Repo          string    `json:"repo"`
 	Branch        string    `json:"branch"`
+	Tag           string    `json:"tag,omitempty"` // Release tag checked out (tag mode only)
 	SHA           string    `json:"sha"`
 	ShortSHA      string    `json:"shortSha"`
 	CommitMessage string    `json:"commitMessage"`
+	Author        string    `json:"author,omitempty"`
+	CommitTime    time.Time `json:"commitTime,omitempty"`
 	LastSync      time.Time `json:"lastSync"`
+	// Commit signature verification (only set when GIT_VERIFY_SIGNATURES is on).
+	// When Signature is "untrusted", SHA is the last verified commit that stays
+	// in effect and UntrustedSHA is the rejected HEAD.
+	Signature      string `json:"signature,omitempty"`
+	UntrustedSHA   string `json:"untrustedSha,omitempty"`
+	SignatureError string `json:"signatureError,omitempty"`
+}
+
+// maxHistory is the number of processed commits kept in the history.
+const maxHistory = 50
+
+// CommitRecord holds a commit omni-cd reconciled and the outcome.
+type CommitRecord struct {
+	SHA          string    `json:"sha"`
+	ShortSHA     string    `json:"shortSha"`
+	Author       string    `json:"author"`
+	Message      string    `json:"message"`
+	CommitTime   time.Time `json:"commitTime"`
+	ReconciledAt time.Time `json:"reconciledAt"`
+	// Outcome is "running", "success", "failed", "cancelled", or "skipped"
+	// when the commit touched no managed resources.
+	Outcome string `json:"outcome"`
+	// Resources lists the resources the commit touched as "Kind/id". FullSync
+	// is set instead when the whole repository was reconciled.
+	Resources []string `json:"resources,omitempty"`
+	FullSync  bool     `json:"fullSync,omitempty"`
+	// LastResync is the latest reconcile of the commit after its first one,
+	// e.g. a scheduled or targeted sync without a new commit. It is kept
+	// apart so the entry still shows what the commit itself changed.
+	LastResync *Resync `json:"lastResync,omitempty"`
+}
+
+// Resync records a reconcile of a commit that had already been reconciled.
+// Its fields mean the same as those of CommitRecord.
+type Resync struct {
+	ReconciledAt time.Time `json:"reconciledAt"`
+	Outcome      string    `json:"outcome"`
+	Resources    []string  `json:"resources,omitempty"`
+	FullSync     bool      `json:"fullSync,omitempty"`
 }
 
 // ReconcileInfo holds information about the last reconciliation.
@@ -61,15 +108,43 @@ type NodeGroup struct {
 	MachineClass string `json:"machineClass,omitempty"`
 }
 
+// Capacity tells whether a machine class can supply the machines that the
+// clusters in Git allocate from it.
+type Capacity struct {
+	// Status is "ok", "insufficient", "ondemand" (an infrastructure provider
+	// creates machines as needed) or "unknown".
+	Status    string   `json:"status"`
+	Available int      `json:"available"` // Matching machines that are free or already in a referencing cluster
+	Required  int      `json:"required"`  // Machines the referencing clusters allocate
+	Clusters  []string `json:"clusters,omitempty"`
+	Message   string   `json:"message,omitempty"`
+}
+
 type ResourceInfo struct {
 	ID            string `json:"id"`
 	Type          string `json:"type"`
 	Status        string `json:"status"`
 	ProvisionType string `json:"provisionType,omitempty"`
+	Target        string `json:"target,omitempty"`  // What a config patch applies to
+	Role          string `json:"role,omitempty"`    // Role of a user or service account
+	Summary       string `json:"summary,omitempty"` // What a machine resource assigns or requests
 	Diff          string `json:"diff,omitempty"`
 	FileContent   string `json:"fileContent,omitempty"`
 	LiveContent   string `json:"liveContent,omitempty"`
 	Error         string `json:"error,omitempty"`
+	// BlockedBy lists what keeps a "blocked" resource from being applied or
+	// deleted: the machine classes a cluster waits for, or the clusters
+	// still using a machine class.
+	BlockedBy []string `json:"blockedBy,omitempty"`
+	// Policy lists the non-default omni-cd annotations of the resource,
+	// e.g. "manual sync" or "no prune".
+	Policy []string `json:"policy,omitempty"`
+	// A cluster whose changes wait for its sync window to open, and when
+	// it next opens (nil when no window opens within a year)
+	WaitingForWindow bool       `json:"waitingForWindow,omitempty"`
+	NextWindow       *time.Time `json:"nextWindow,omitempty"`
+	// Machine class capacity (nil until it has been checked)
+	Capacity *Capacity `json:"capacity,omitempty"`
 	// Cluster-specific detail (populated from live template export)
 	TalosVersion       string      `json:"talosVersion,omitempty"`
 	KubernetesVersion  string      `json:"kubernetesVersion,omitempty"`
@@ -98,8 +173,18 @@ type SnapshotData struct {
 	LastReconcile   ReconcileInfo  `json:"lastReconcile"`
 	MachineClasses  []ResourceInfo `json:"machineClasses"`
 	Clusters        []ResourceInfo `json:"clusters"`
+	ConfigPatches   []ResourceInfo `json:"configPatches"`
+	Machines        []ResourceInfo `json:"machines"`
+	Access          []ResourceInfo `json:"access"`
 	ClustersEnabled bool           `json:"clustersEnabled"`
+	PinnedSHA       string         `json:"pinnedSha,omitempty"`
+	History         []CommitRecord `json:"history"`
+	Queue           queue.Snapshot `json:"queue"`
 	Logs            []LogEntry     `json:"logs"`
+
+	// Only written to the state file, so approvals survive a restart
+	PendingDeletions  []string `json:"pendingDeletions,omitempty"`
+	ApprovedDeletions []string `json:"approvedDeletions,omitempty"`
 }
 
 // AppState holds all shared state for the application.
@@ -114,12 +199,27 @@ type AppState struct {
 	LastReconcile   ReconcileInfo  `json:"lastReconcile"`
 	MachineClasses  []ResourceInfo `json:"machineClasses"`
 	Clusters        []ResourceInfo `json:"clusters"`
+	ConfigPatches   []ResourceInfo `json:"configPatches"`
+	Machines        []ResourceInfo `json:"machines"`
+	Access          []ResourceInfo `json:"access"`
 	ClustersEnabled bool           `json:"clustersEnabled"`
-	ForceClusterID  string         // Cluster ID to force sync (not exported to JSON)
+	PinnedSHA       string         `json:"pinnedSha,omitempty"` // Commit to deploy instead of the tracked ref
+	History         []CommitRecord `json:"history"`             // Most recent first
 	Logs            []LogEntry     `json:"logs"`
 	maxLogs         int
 	stateFile       string        // Path to state file (not exported to JSON)
 	changeCh        chan struct{} // Closed/sent on every state mutation
+
+	// Deletions held back by the prune guard and those confirmed through
+	// the API, as "Type/id" (persisted by SaveToFile, not exported to JSON)
+	pendingDeletions  map[string]bool
+	approvedDeletions map[string]bool
+
+	// Reconcile requests running and waiting (not exported to JSON)
+	queue queue.Snapshot
+
+	// Cancels the reconcile in flight; nil while none is running
+	cancelReconcile context.CancelFunc
 }
 
 // New creates a new AppState with a max log buffer size.
@@ -130,6 +230,10 @@ func New(maxLogs int, omniEndpoint string, clustersEnabled bool, stateFile strin
 		ClustersEnabled: clustersEnabled,
 		MachineClasses:  []ResourceInfo{},
 		Clusters:        []ResourceInfo{},
+		ConfigPatches:   []ResourceInfo{},
+		Machines:        []ResourceInfo{},
+		Access:          []ResourceInfo{},
+		History:         []CommitRecord{},
 		Logs:            []LogEntry{},
 		stateFile:       stateFile,
 		changeCh:        make(chan struct{}, 1),
@@ -189,14 +293,16 @@ func (s *AppState) UpdateGit(info GitInfo) {
 	s.notifyChange()
 }
 
-// SetReconcileStarted marks a reconciliation as started.
-func (s *AppState) SetReconcileStarted(t ReconcileType) {
+// SetReconcileStarted marks a reconciliation as started. cancel aborts it
+// when CancelReconcile is called.
+func (s *AppState) SetReconcileStarted(t ReconcileType, cancel context.CancelFunc) {
 	s.mu.Lock()
 	s.LastReconcile = ReconcileInfo{
 		Type:      t,
 		Status:    StatusRunning,
 		StartedAt: time.Now().UTC(),
 	}
+	s.cancelReconcile = cancel
 	s.mu.Unlock()
 	s.notifyChange()
 }
@@ -210,10 +316,34 @@ func (s *AppState) SetReconcileFinished(success bool) {
 		s.LastReconcile.Status = StatusFailed
 	}
 	s.LastReconcile.FinishedAt = time.Now().UTC()
+	s.cancelReconcile = nil
 	s.mu.Unlock()
 	s.notifyChange()
 }
 
+// SetReconcileCancelled marks a reconciliation as stopped before it finished.
+func (s *AppState) SetReconcileCancelled() {
+	s.mu.Lock()
+	s.LastReconcile.Status = StatusCancelled
+	s.LastReconcile.FinishedAt = time.Now().UTC()
+	s.cancelReconcile = nil
+	s.mu.Unlock()
+	s.notifyChange()
+}
+
+// CancelReconcile aborts the reconcile in flight and reports whether one
+// was running.
+func (s *AppState) CancelReconcile() bool {
+	s.mu.Lock()
+	cancel := s.cancelReconcile
+	s.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	return true
+}
+
 // SetMachineClasses replaces the machine class list.
 func (s *AppState) SetMachineClasses(resources []ResourceInfo) {
 	s.mu.Lock()
@@ -222,6 +352,81 @@ func (s *AppState) SetMachineClasses(resources []ResourceInfo) {
 	s.notifyChange()
 }
 
+// GetMachineClasses returns a copy of the current machine class list.
+func (s *AppState) GetMachineClasses() []ResourceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ResourceInfo, len(s.MachineClasses))
+	copy(out, s.MachineClasses)
+	return out
+}
+
+// SetConfigPatches replaces the config patch list.
+func (s *AppState) SetConfigPatches(resources []ResourceInfo) {
+	s.mu.Lock()
+	s.ConfigPatches = resources
+	s.mu.Unlock()
+	s.notifyChange()
+}
+
+// GetConfigPatches returns a copy of the current config patch list.
+func (s *AppState) GetConfigPatches() []ResourceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ResourceInfo, len(s.ConfigPatches))
+	copy(out, s.ConfigPatches)
+	return out
+}
+
+// SetMachineClassCapacity sets the capacity of every machine class in the
+// list. Classes missing from capacity have theirs cleared.
+func (s *AppState) SetMachineClassCapacity(capacity map[string]*Capacity) {
+	s.mu.Lock()
+	updated := make([]ResourceInfo, len(s.MachineClasses))
+	for i, mc := range s.MachineClasses {
+		mc.Capacity = capacity[mc.ID]
+		updated[i] = mc
+	}
+	s.MachineClasses = updated
+	s.mu.Unlock()
+	s.notifyChange()
+}
+
+// SetMachines replaces the list of MachineLabels and MachineRequestSets.
+func (s *AppState) SetMachines(resources []ResourceInfo) {
+	s.mu.Lock()
+	s.Machines = resources
+	s.mu.Unlock()
+	s.notifyChange()
+}
+
+// GetMachines returns a copy of the current MachineLabels and
+// MachineRequestSets list.
+func (s *AppState) GetMachines() []ResourceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ResourceInfo, len(s.Machines))
+	copy(out, s.Machines)
+	return out
+}
+
+// SetAccess replaces the list of users, service accounts and access policy.
+func (s *AppState) SetAccess(resources []ResourceInfo) {
+	s.mu.Lock()
+	s.Access = resources
+	s.mu.Unlock()
+	s.notifyChange()
+}
+
+// GetAccess returns a copy of the current access list.
+func (s *AppState) GetAccess() []ResourceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ResourceInfo, len(s.Access))
+	copy(out, s.Access)
+	return out
+}
+
 // GetClusters returns a copy of the current cluster list.
 func (s *AppState) GetClusters() []ResourceInfo {
 	s.mu.RLock()
@@ -373,27 +578,176 @@ func (s *AppState) Save() {
 	s.save()
 }
 
-// SetForceClusterID sets a specific cluster to force sync on next reconcile.
-func (s *AppState) SetForceClusterID(id string) {
+// GetPinnedSHA returns the pinned commit, or "" when following the tracked ref.
+func (s *AppState) GetPinnedSHA() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.PinnedSHA
+}
+
+// SetPinnedSHA pins deployments to a commit ("" removes the pin) and
+// persists the change immediately so it survives a restart.
+func (s *AppState) SetPinnedSHA(sha string) {
+	s.mu.Lock()
+	s.PinnedSHA = sha
+	s.mu.Unlock()
+	s.save()
+	s.notifyChange()
+}
+
+// SetQueue records the reconcile requests running and waiting.
+func (s *AppState) SetQueue(q queue.Snapshot) {
+	s.mu.Lock()
+	s.queue = q
+	s.mu.Unlock()
+	s.notifyChange()
+}
+
+// NextClusterWindow returns the earliest time a sync window opens for a
+// cluster that waits for one, or the zero time when none does.
+func (s *AppState) NextClusterWindow() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var next time.Time
+	for _, c := range s.Clusters {
+		if c.NextWindow != nil && (next.IsZero() || c.NextWindow.Before(next)) {
+			next = *c.NextWindow
+		}
+	}
+	return next
+}
+
+// SetPendingDeletions replaces the deletions of a resource type that wait
+// for confirmation. Confirmations of resources that are no longer pending
+// are dropped, so a resource removed from Git again later needs a new one.
+func (s *AppState) SetPendingDeletions(typ string, ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pendingDeletions == nil {
+		s.pendingDeletions = make(map[string]bool)
+		s.approvedDeletions = make(map[string]bool)
+	}
+	prefix := typ + "/"
+	for key := range s.pendingDeletions {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.pendingDeletions, key)
+		}
+	}
+	for _, id := range ids {
+		s.pendingDeletions[prefix+id] = true
+	}
+	for key := range s.approvedDeletions {
+		if strings.HasPrefix(key, prefix) && !s.pendingDeletions[key] {
+			delete(s.approvedDeletions, key)
+		}
+	}
+}
+
+// AddPendingDeletions adds deletions of a resource type that wait for
+// confirmation, keeping those already pending.
+func (s *AppState) AddPendingDeletions(typ string, ids []string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.ForceClusterID = id
+	if s.pendingDeletions == nil {
+		s.pendingDeletions = make(map[string]bool)
+		s.approvedDeletions = make(map[string]bool)
+	}
+	for _, id := range ids {
+		s.pendingDeletions[typ+"/"+id] = true
+	}
+}
+
+// ApproveDeletion confirms a pending deletion so the next reconcile carries
+// it out, and persists the confirmation immediately so it survives a
+// restart. It reports false when the resource is not pending deletion.
+func (s *AppState) ApproveDeletion(typ, id string) bool {
+	s.mu.Lock()
+	key := typ + "/" + id
+	if !s.pendingDeletions[key] {
+		s.mu.Unlock()
+		return false
+	}
+	s.approvedDeletions[key] = true
+	s.mu.Unlock()
+	s.save()
+	return true
+}
+
+// deletionKeys returns the "Type/id" keys of a deletion set in order.
+func deletionKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
-// GetForceClusterID returns and clears the force cluster ID.
-func (s *AppState) GetForceClusterID() string {
+// TakeDeletionApproval reports whether a deletion was confirmed and clears
+// the confirmation.
+func (s *AppState) TakeDeletionApproval(typ, id string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	id := s.ForceClusterID
-	s.ForceClusterID = ""
-	return id
+	key := typ + "/" + id
+	if !s.approvedDeletions[key] {
+		return false
+	}
+	delete(s.approvedDeletions, key)
+	return true
+}
+
+// RecordCommit starts a history entry for a commit that is being reconciled.
+// If the most recent entry is the same commit (e.g. a sync without a new
+// commit), the run is recorded as its LastResync instead, unless the entry's
+// own reconcile never finished.
+func (s *AppState) RecordCommit(rec CommitRecord) {
+	s.mu.Lock()
+	if len(s.History) > 0 && s.History[0].SHA == rec.SHA {
+		if s.History[0].Outcome == "running" {
+			s.History[0].ReconciledAt = rec.ReconciledAt
+		} else {
+			s.History[0].LastResync = &Resync{ReconciledAt: rec.ReconciledAt, Outcome: rec.Outcome}
+		}
+	} else {
+		s.History = append([]CommitRecord{rec}, s.History...)
+		if len(s.History) > maxHistory {
+			s.History = s.History[:maxHistory]
+		}
+	}
+	s.mu.Unlock()
+	s.notifyChange()
+}
+
+// FinishCommit records the outcome and touched resources of the run started
+// by the last RecordCommit for sha: the most recent history entry, or its
+// LastResync. If the commit is not at the head of the history it is a no-op.
+func (s *AppState) FinishCommit(sha, outcome string, resources []string, fullSync bool) {
+	s.mu.Lock()
+	if len(s.History) == 0 || s.History[0].SHA != sha {
+		s.mu.Unlock()
+		return
+	}
+	if r := s.History[0].LastResync; r != nil && r.Outcome == "running" {
+		// Replaced rather than updated, as copies of the history share it
+		s.History[0].LastResync = &Resync{ReconciledAt: r.ReconciledAt, Outcome: outcome, Resources: resources, FullSync: fullSync}
+	} else {
+		s.History[0].Outcome = outcome
+		if resources != nil || fullSync {
+			s.History[0].Resources = resources
+			s.History[0].FullSync = fullSync
+		}
+	}
+	s.mu.Unlock()
+	s.notifyChange()
 }
 
-// HasForceClusterID checks if a force cluster ID is set without clearing it.
-func (s *AppState) HasForceClusterID() bool {
+// GetHistory returns a copy of the commit history, most recent first.
+func (s *AppState) GetHistory() []CommitRecord {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.ForceClusterID != ""
+	out := make([]CommitRecord, len(s.History))
+	copy(out, s.History)
+	return out
 }
 
 // AddLog appends a log entry, trimming old entries if needed.
@@ -427,7 +781,13 @@ func (s *AppState) Snapshot() SnapshotData {
 		LastReconcile:   s.LastReconcile,
 		MachineClasses:  s.MachineClasses,
 		Clusters:        s.Clusters,
+		ConfigPatches:   s.ConfigPatches,
+		Machines:        s.Machines,
+		Access:          s.Access,
 		ClustersEnabled: s.ClustersEnabled,
+		PinnedSHA:       s.PinnedSHA,
+		History:         s.History,
+		Queue:           s.queue,
 		Logs:            s.Logs,
 	}
 }
@@ -463,8 +823,16 @@ func (s *AppState) SaveToFile(path string) error {
 		LastReconcile:   s.LastReconcile,
 		MachineClasses:  filteredMCs,
 		Clusters:        filteredClusters,
+		ConfigPatches:   s.ConfigPatches,
+		Machines:        s.Machines,
+		Access:          s.Access,
 		ClustersEnabled: s.ClustersEnabled,
+		PinnedSHA:       s.PinnedSHA,
+		History:         s.History,
 		// Logs intentionally omitted
+
+		PendingDeletions:  deletionKeys(s.pendingDeletions),
+		ApprovedDeletions: deletionKeys(s.approvedDeletions),
 	}
 
 	s.mu.RUnlock()
@@ -502,17 +870,38 @@ func (s *AppState) LoadFromFile(path string) error {
 	}
 
 	// Unmarshal into a temporary struct
-	var loaded AppState
+	var loaded SnapshotData
 	if err := json.Unmarshal(data, &loaded); err != nil {
 		return err
 	}
 
 	// Restore persisted fields
 	s.ClustersEnabled = loaded.ClustersEnabled
+	s.PinnedSHA = loaded.PinnedSHA
+	if loaded.History != nil {
+		s.History = loaded.History
+	}
+	s.pendingDeletions = make(map[string]bool)
+	s.approvedDeletions = make(map[string]bool)
+	for _, key := range loaded.PendingDeletions {
+		s.pendingDeletions[key] = true
+	}
+	for _, key := range loaded.ApprovedDeletions {
+		s.approvedDeletions[key] = true
+	}
 	// Don't restore Git - it's transient
 	s.LastReconcile = loaded.LastReconcile
 	s.MachineClasses = loaded.MachineClasses
 	s.Clusters = loaded.Clusters
+	if loaded.ConfigPatches != nil {
+		s.ConfigPatches = loaded.ConfigPatches
+	}
+	if loaded.Machines != nil {
+		s.Machines = loaded.Machines
+	}
+	if loaded.Access != nil {
+		s.Access = loaded.Access
+	}
 	s.OmniVersion = loaded.OmniVersion
 	s.OmnictlVersion = loaded.OmnictlVersion
 	s.VersionMismatch = loaded.VersionMismatch
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResyncKeepsCommitHistory(t *testing.T) {
+	s := New(10, "", true, "")
+	run := func(outcome string, resources []string, fullSync bool) {
+		s.RecordCommit(CommitRecord{SHA: "abc", ReconciledAt: time.Now(), Outcome: "running"})
+		s.FinishCommit("abc", outcome, resources, fullSync)
+	}
+
+	// A refresh of the new commit, then a sync and a targeted sync of it
+	run("success", []string{"Cluster/prod"}, false)
+	run("success", nil, true)
+	run("failed", []string{"Cluster/dev"}, false)
+
+	history := s.GetHistory()
+	if len(history) != 1 {
+		t.Fatalf("got %d history entries, want 1", len(history))
+	}
+	h := history[0]
+	if h.Outcome != "success" || h.FullSync || !reflect.DeepEqual(h.Resources, []string{"Cluster/prod"}) {
+		t.Errorf("entry = outcome %q, resources %v, full sync %v; want the refresh's", h.Outcome, h.Resources, h.FullSync)
+	}
+	r := h.LastResync
+	if r == nil || r.Outcome != "failed" || !reflect.DeepEqual(r.Resources, []string{"Cluster/dev"}) {
+		t.Errorf("last resync = %+v, want the targeted sync", r)
+	}
+
+	// A new commit starts its own entry
+	s.RecordCommit(CommitRecord{SHA: "def", Outcome: "running"})
+	s.FinishCommit("def", "skipped", nil, false)
+	if history := s.GetHistory(); len(history) != 2 || history[0].LastResync != nil {
+		t.Errorf("history = %+v, want a fresh entry for def", history)
+	}
+}
+
+func TestDeletionApprovalsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := New(10, "", true, path)
+	s.SetPendingDeletions("Cluster", []string{"prod", "dev"})
+	if !s.ApproveDeletion("Cluster", "prod") {
+		t.Fatal("approving prod failed")
+	}
+
+	restarted := New(10, "", true, path)
+	if !restarted.TakeDeletionApproval("Cluster", "prod") {
+		t.Error("approval of prod lost on restart")
+	}
+	if restarted.TakeDeletionApproval("Cluster", "dev") {
+		t.Error("dev approved without confirmation")
+	}
+	if !restarted.ApproveDeletion("Cluster", "dev") {
+		t.Error("dev no longer pending after restart")
+	}
+}
@@ -0,0 +1,135 @@
+package syncwindow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week. Each field is a bit set of the values it
+// matches.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, a restricted day of month and day of week match when
+	// either does; a "*" field leaves the day to the other one.
+	domStar, dowStar bool
+}
+
+// parseSchedule parses the five fields of a cron expression. Fields accept
+// "*", numbers, ranges ("1-5"), steps ("*/15", "0-30/10") and lists of
+// these. Day of week runs from 0 (Sunday) to 7 (Sunday again).
+func parseSchedule(fields []string) (schedule, error) {
+	if len(fields) != 5 {
+		return schedule{}, fmt.Errorf("cron expression needs 5 fields, got %d", len(fields))
+	}
+	var (
+		s   schedule
+		err error
+	)
+	if s.minute, _, err = parseField(fields[0], 0, 59); err != nil {
+		return schedule{}, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, _, err = parseField(fields[1], 0, 23); err != nil {
+		return schedule{}, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, s.domStar, err = parseField(fields[2], 1, 31); err != nil {
+		return schedule{}, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, _, err = parseField(fields[3], 1, 12); err != nil {
+		return schedule{}, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, s.dowStar, err = parseField(fields[4], 0, 7); err != nil {
+		return schedule{}, fmt.Errorf("day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseField returns the values matched by one cron field, and whether the
+// field is an unrestricted "*".
+func parseField(field string, min, max int) (uint64, bool, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, false, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var errA, errB error
+			lo, errA = strconv.Atoi(a)
+			hi, errB = strconv.Atoi(b)
+			if errA != nil || errB != nil || lo > hi {
+				return 0, false, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, false, fmt.Errorf("invalid value %q", rng)
+			}
+			lo, hi = n, n
+			// "5/15" means from 5 to the end in steps of 15
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max {
+			return 0, false, fmt.Errorf("%q is outside %d-%d", rng, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, field == "*", nil
+}
+
+// next returns the first time at or after t, to the minute, that the
+// schedule matches in t's location, or the zero time when there is none
+// within five years (e.g. "0 0 30 2 *").
+func (s schedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	if sec := t.Second()*int(time.Second) + t.Nanosecond(); sec > 0 {
+		t = t.Add(time.Minute - time.Duration(sec))
+	}
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		y, m, d := t.Date()
+		switch {
+		case s.month&(1<<uint(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day-of-month and
+// day-of-week fields.
+func (s schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
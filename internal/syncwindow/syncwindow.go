@@ -0,0 +1,149 @@
+// Package syncwindow decides when changes may be applied to a cluster.
+//
+// A window opens on a cron schedule and stays open for a fixed duration.
+// Allow windows are the only times changes may be applied; deny windows are
+// times they may not, and win over allow windows that overlap them. Without
+// any allow window, every time outside a deny window is open.
+package syncwindow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Window is a single allow or deny window.
+type Window struct {
+	Allow    bool
+	Duration time.Duration
+	spec     string
+	schedule schedule
+}
+
+// String returns the window as it was written.
+func (w Window) String() string {
+	return w.spec
+}
+
+// active reports whether the window is open at t.
+func (w Window) active(t time.Time) bool {
+	t = t.Truncate(time.Minute)
+	start := w.schedule.next(t.Add(-w.Duration + time.Minute))
+	return !start.IsZero() && !start.After(t)
+}
+
+// Windows is a set of sync windows evaluated together.
+type Windows []Window
+
+// Parse reads windows separated by ";", each written as "allow" or "deny",
+// a five-field cron expression for when it opens and how long it stays
+// open, e.g. "allow 0 22 * * 1-5 4h; deny 0 0 24 12 * 48h". An empty spec
+// has no windows, so changes may be applied at any time.
+func Parse(spec string) (Windows, error) {
+	var ws Windows
+	for _, part := range strings.Split(spec, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("sync window %q must be \"allow|deny <cron> <duration>\"", strings.TrimSpace(part))
+		}
+
+		w := Window{spec: strings.Join(fields, " ")}
+		switch fields[0] {
+		case "allow":
+			w.Allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("sync window %q must start with allow or deny", w.spec)
+		}
+		sched, err := parseSchedule(fields[1:6])
+		if err != nil {
+			return nil, fmt.Errorf("sync window %q: %w", w.spec, err)
+		}
+		w.schedule = sched
+		d, err := time.ParseDuration(fields[6])
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("sync window %q: duration must be at least 1m", w.spec)
+		}
+		w.Duration = d
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+// Open reports whether changes may be applied at t. The cron schedules are
+// evaluated in t's location.
+func (ws Windows) Open(t time.Time) bool {
+	hasAllow, allowed := false, false
+	for _, w := range ws {
+		if w.Allow {
+			hasAllow = true
+			allowed = allowed || w.active(t)
+		} else if w.active(t) {
+			return false
+		}
+	}
+	return !hasAllow || allowed
+}
+
+// NextOpen returns t when the windows are open at t, and otherwise the
+// time they next open within a year, or the zero time when they do not.
+func (ws Windows) NextOpen(t time.Time) time.Time {
+	return All{ws}.NextOpen(t)
+}
+
+// All is a list of window sets that are open only while every one of them
+// is, e.g. the global windows and those a single cluster declares.
+type All []Windows
+
+// Open reports whether every set of windows is open at t.
+func (a All) Open(t time.Time) bool {
+	for _, ws := range a {
+		if !ws.Open(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// NextOpen returns t when all windows are open at t, and otherwise the time
+// they next are within a year, or the zero time when they are not.
+func (a All) NextOpen(t time.Time) time.Time {
+	if a.Open(t) {
+		return t
+	}
+
+	// The windows can only open when an allow window starts or a deny
+	// window ends
+	horizon := t.AddDate(1, 0, 0)
+	var candidates []time.Time
+	for _, ws := range a {
+		for _, w := range ws {
+			from := t.Add(-w.Duration + time.Minute)
+			for i := 0; i < 64; i++ {
+				start := w.schedule.next(from)
+				if start.IsZero() || start.After(horizon) {
+					break
+				}
+				at := start
+				if !w.Allow {
+					at = start.Add(w.Duration)
+				}
+				if at.After(t) {
+					candidates = append(candidates, at)
+				}
+				from = start.Add(time.Minute)
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	for _, c := range candidates {
+		if a.Open(c) {
+			return c
+		}
+	}
+	return time.Time{}
+}
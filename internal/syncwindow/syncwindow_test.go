@@ -0,0 +1,144 @@
+package syncwindow
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a UTC time on the given day of October 2026, which starts on
+// a Thursday.
+func at(day, hour, minute int) time.Time {
+	return time.Date(2026, time.October, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{spec: "", want: 0},
+		{spec: "allow 0 22 * * 1-5 4h", want: 1},
+		{spec: "allow 0 22 * * 1-5 4h; deny */15 0-6/2 1,15 1-12 0 30m;", want: 2},
+		{spec: "allow 0 22 * * 1-5", wantErr: true},
+		{spec: "permit 0 22 * * * 4h", wantErr: true},
+		{spec: "allow 60 22 * * * 4h", wantErr: true},
+		{spec: "allow 0 22 * * 8 4h", wantErr: true},
+		{spec: "allow 0 22 5-1 * * 4h", wantErr: true},
+		{spec: "allow 0 22 * * * 30s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := Parse(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d windows, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{name: "no windows", spec: "", at: at(15, 12, 0), want: true},
+		{name: "inside allow", spec: "allow 0 22 * * * 4h", at: at(15, 23, 30), want: true},
+		{name: "allow spans midnight", spec: "allow 0 22 * * * 4h", at: at(16, 1, 59), want: true},
+		{name: "allow end is exclusive", spec: "allow 0 22 * * * 4h", at: at(16, 2, 0), want: false},
+		{name: "before allow", spec: "allow 0 22 * * * 4h", at: at(15, 21, 59), want: false},
+		{name: "outside deny", spec: "deny 0 9 * * 1-5 8h", at: at(15, 18, 0), want: true},
+		{name: "inside deny", spec: "deny 0 9 * * 1-5 8h", at: at(15, 9, 0), want: false},
+		{name: "deny on weekdays only", spec: "deny 0 9 * * 1-5 8h", at: at(17, 10, 0), want: true},
+		{name: "deny wins over allow", spec: "allow 0 0 * * * 24h; deny 0 12 15 10 * 1h", at: at(15, 12, 30), want: false},
+		{name: "day of month or week", spec: "allow 0 0 1 * 6 24h", at: at(17, 12, 0), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ws.Open(tt.at); got != tt.want {
+				t.Errorf("Open(%s) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		at   time.Time
+		want time.Time
+	}{
+		{name: "already open", spec: "allow 0 22 * * * 4h", at: at(15, 23, 0), want: at(15, 23, 0)},
+		{name: "next allow", spec: "allow 0 22 * * 6 4h", at: at(15, 12, 0), want: at(17, 22, 0)},
+		{name: "end of deny", spec: "deny 0 9 * * 1-5 8h", at: at(15, 12, 0), want: at(15, 17, 0)},
+		{name: "allow inside deny", spec: "allow 0 * * * * 30m; deny 0 9 15 10 * 8h", at: at(15, 12, 0), want: at(15, 17, 0)},
+		{name: "never", spec: "allow 0 0 30 2 * 1h", at: at(15, 12, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ws.NextOpen(tt.at); !got.Equal(tt.want) {
+				t.Errorf("NextOpen(%s) = %s, want %s", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAll(t *testing.T) {
+	global, err := Parse("allow 0 22 * * * 4h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := Parse("allow 0 23 * * 6 1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := All{global, own}
+
+	tests := []struct {
+		at       time.Time
+		open     bool
+		nextOpen time.Time
+	}{
+		{at: at(15, 22, 30), open: false, nextOpen: at(17, 23, 0)},
+		{at: at(17, 22, 30), open: false, nextOpen: at(17, 23, 0)},
+		{at: at(17, 23, 30), open: true, nextOpen: at(17, 23, 30)},
+		{at: at(18, 0, 30), open: false, nextOpen: at(24, 23, 0)},
+	}
+	for _, tt := range tests {
+		if got := a.Open(tt.at); got != tt.open {
+			t.Errorf("Open(%s) = %v, want %v", tt.at, got, tt.open)
+		}
+		if got := a.NextOpen(tt.at); !got.Equal(tt.nextOpen) {
+			t.Errorf("NextOpen(%s) = %s, want %s", tt.at, got, tt.nextOpen)
+		}
+	}
+}
+
+func TestLocation(t *testing.T) {
+	ws, err := Parse("allow 0 22 * * * 2h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin := time.FixedZone("CEST", 2*60*60)
+	// 21:00 UTC is 23:00 in Berlin
+	if ws.Open(at(15, 21, 0)) {
+		t.Error("open at 21:00 UTC")
+	}
+	if !ws.Open(at(15, 21, 0).In(berlin)) {
+		t.Error("closed at 23:00 in Berlin")
+	}
+}
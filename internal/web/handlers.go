@@ -3,9 +3,14 @@ package web
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
-	"omni-cd/internal/omni"
+	"omni-cd/internal/model"
+	"omni-cd/internal/queue"
 )
 
 // handleState returns the current application state as JSON.
@@ -19,49 +24,214 @@ func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(snapshot)
 }
 
-// handleReconcile triggers a hard reconcile.
+// handleHistory returns the processed commits, most recent first.
+// The optional "limit" query parameter caps the number of entries.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	history := s.appState.GetHistory()
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit < len(history) {
+			history = history[:limit]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// handleReconcile queues a sync.
 func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Block sync when version mismatch
-	snap := s.appState.Snapshot()
-	if snap.VersionMismatch {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"status": "blocked", "reason": "version mismatch"})
+	if s.blockedByVersion(w) {
 		return
 	}
+	s.enqueue(w, queue.Request{Kind: queue.Sync})
+}
 
-	select {
-	case s.triggerHard <- struct{}{}:
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "triggered"})
-	default:
-		w.Header().Set("Content-Type", "application/json")
+// handleCancelReconcile aborts the reconcile in flight, including the
+// omnictl calls it is running.
+func (s *Server) handleCancelReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !s.appState.CancelReconcile() {
 		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"status": "already running"})
+		json.NewEncoder(w).Encode(map[string]string{"status": "not running"})
+		return
 	}
+	slog.Warn("Reconcile cancelled via API", "component", "Web")
+	json.NewEncoder(w).Encode(map[string]string{"status": "cancelling"})
 }
 
-// handleCheck triggers a soft reconcile (git check).
+// handleCheck queues a refresh (git check).
 func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	select {
-	case s.triggerSoft <- struct{}{}:
+	s.enqueue(w, queue.Request{Kind: queue.Refresh})
+}
+
+// handleQueue returns the reconcile requests running and waiting, or queues
+// a new one.
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "triggered"})
+		json.NewEncoder(w).Encode(s.queue.Snapshot())
+		return
+	case http.MethodPost:
 	default:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"status": "already running"})
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Kind           queue.Kind `json:"kind"`
+		Clusters       []string   `json:"clusters"`
+		MachineClasses []string   `json:"machineClasses"`
+		OverrideWindow bool       `json:"overrideWindow"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	targeted := len(req.Clusters) > 0 || len(req.MachineClasses) > 0
+	switch req.Kind {
+	case queue.Refresh, queue.Sync:
+		if targeted || req.OverrideWindow {
+			http.Error(w, fmt.Sprintf("Kind %s does not take clusters, machine classes or overrideWindow", req.Kind), http.StatusBadRequest)
+			return
+		}
+	case queue.Targeted:
+		if !targeted {
+			http.Error(w, "Kind targeted needs clusters or machine classes", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Kind must be refresh, sync or targeted", http.StatusBadRequest)
+		return
+	}
+
+	if req.Kind != queue.Refresh && s.blockedByVersion(w) {
+		return
+	}
+	if req.OverrideWindow {
+		slog.Warn("Force sync overrides sync windows", "clusters", strings.Join(req.Clusters, ", "), "component", "Web")
+	}
+	s.enqueue(w, queue.Request{
+		Kind:           req.Kind,
+		Clusters:       req.Clusters,
+		MachineClasses: req.MachineClasses,
+		OverrideWindow: req.OverrideWindow,
+	})
+}
+
+// handleSync queues a targeted sync of the clusters and machine classes
+// picked by a list of selectors, each a resource type with either an ID or
+// a label selector. Only the selected resources are validated, diffed and
+// applied.
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Resources []struct {
+			Type     string `json:"type"`
+			ID       string `json:"id"`
+			Selector string `json:"selector"`
+		} `json:"resources"`
+		// Sync the selected clusters even while their sync windows are closed
+		OverrideWindow bool `json:"overrideWindow"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Resources) == 0 {
+		http.Error(w, "At least one resource is required", http.StatusBadRequest)
+		return
+	}
+
+	target := queue.Request{Kind: queue.Targeted, OverrideWindow: req.OverrideWindow}
+	for i, res := range req.Resources {
+		if (res.ID == "") == (res.Selector == "") {
+			http.Error(w, fmt.Sprintf("Resource %d needs either an id or a selector", i), http.StatusBadRequest)
+			return
+		}
+		if res.Selector != "" {
+			if err := model.ValidateSelector(res.Selector); err != nil {
+				http.Error(w, fmt.Sprintf("Resource %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+		}
+		switch {
+		case res.Type == "Cluster" && res.ID != "":
+			target.Clusters = append(target.Clusters, res.ID)
+		case res.Type == "Cluster":
+			target.ClusterSelectors = append(target.ClusterSelectors, res.Selector)
+		case res.Type == "MachineClass" && res.ID != "":
+			target.MachineClasses = append(target.MachineClasses, res.ID)
+		case res.Type == "MachineClass":
+			target.MachineClassSelectors = append(target.MachineClassSelectors, res.Selector)
+		default:
+			http.Error(w, fmt.Sprintf("Resource %d: type must be Cluster or MachineClass", i), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s.blockedByVersion(w) {
+		return
+	}
+	if req.OverrideWindow {
+		slog.Warn("Targeted sync overrides sync windows", "component", "Web")
+	}
+	s.enqueue(w, target)
+}
+
+// enqueue queues a reconcile request from the web UI and answers with the
+// pending request it ended up in, which may be an earlier one it was merged
+// into.
+func (s *Server) enqueue(w http.ResponseWriter, req queue.Request) {
+	queued := s.queue.Add(req, "web UI")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "queued",
+		"request": queued,
+	})
+}
+
+// blockedByVersion answers with a conflict and returns true while syncs are
+// blocked because omnictl and Omni versions differ.
+func (s *Server) blockedByVersion(w http.ResponseWriter) bool {
+	if !s.appState.Snapshot().VersionMismatch {
+		return false
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{"status": "blocked", "reason": "version mismatch"})
+	return true
 }
 
 // handleClustersToggle toggles cluster sync on/off at runtime.
@@ -78,7 +248,7 @@ func (s *Server) handleClustersToggle(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleForceCluster sets a specific cluster to force sync.
+// handleForceCluster queues a force sync of a specific cluster.
 func (s *Server) handleForceCluster(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -87,6 +257,8 @@ func (s *Server) handleForceCluster(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		ID string `json:"id"`
+		// Sync even while the cluster's sync windows are closed
+		OverrideWindow bool `json:"overrideWindow"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -99,10 +271,62 @@ func (s *Server) handleForceCluster(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.appState.SetForceClusterID(req.ID)
+	if s.blockedByVersion(w) {
+		return
+	}
+	if req.OverrideWindow {
+		slog.Warn("Force sync overrides sync windows", "cluster", req.ID, "component", "Web")
+	}
+	s.enqueue(w, queue.Request{
+		Kind:           queue.Targeted,
+		Clusters:       []string{req.ID},
+		OverrideWindow: req.OverrideWindow,
+	})
+}
+
+// handleConfirmDelete confirms the deletion of a cluster or machine class
+// that the prune guard holds back, and queues a sync to carry it out.
+// prunableTypes are the resource types whose deletion the prune guard holds.
+var prunableTypes = []string{"Cluster", "MachineClass", "MachineLabels", "MachineRequestSet", "ConfigPatch"}
+
+func (s *Server) handleConfirmDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !slices.Contains(prunableTypes, req.Type) {
+		http.Error(w, "Type must be one of "+strings.Join(prunableTypes, ", "), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		http.Error(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.appState.ApproveDeletion(req.Type, req.ID) {
+		http.Error(w, fmt.Sprintf("%s %s is not pending deletion", req.Type, req.ID), http.StatusNotFound)
+		return
+	}
+	slog.Warn("Deletion confirmed", "type", req.Type, "id", req.ID, "component", "Web")
+
+	// A sync that is already queued picks the confirmation up as well
+	s.queue.Add(queue.Request{Kind: queue.Sync}, "confirm delete")
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status": "ok",
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "confirmed",
+		"type":   req.Type,
 		"id":     req.ID,
 	})
 }
@@ -129,7 +353,7 @@ func (s *Server) handleExportCluster(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Export the cluster template
-	yamlContent, err := omni.ExportCluster(req.ID)
+	yamlContent, err := s.omniClient.ExportCluster(r.Context(), req.ID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to export cluster: %v", err), http.StatusInternalServerError)
 		return
@@ -140,3 +364,61 @@ func (s *Server) handleExportCluster(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.yaml", req.ID))
 	w.Write([]byte(yamlContent))
 }
+
+// handlePin pins deployments to a specific commit until it is unpinned.
+// The pin takes effect on the next reconcile.
+func (s *Server) handlePin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SHA string `json:"sha"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sha := strings.ToLower(strings.TrimSpace(req.SHA))
+	if !isCommitSHA(sha) {
+		http.Error(w, "A commit SHA (4-40 hex characters) is required", http.StatusBadRequest)
+		return
+	}
+
+	s.appState.SetPinnedSHA(sha)
+	slog.Info("Deployment pinned", "sha", sha, "component", "Web")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "pinned",
+		"sha":    sha,
+	})
+}
+
+// handleUnpin removes the commit pin so the tracked ref is followed again.
+func (s *Server) handleUnpin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.appState.SetPinnedSHA("")
+	slog.Info("Deployment unpinned", "component", "Web")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "unpinned"})
+}
+
+// isCommitSHA reports whether s looks like a full or abbreviated commit SHA.
+func isCommitSHA(s string) bool {
+	if len(s) < 4 || len(s) > 40 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
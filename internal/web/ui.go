@@ -76,6 +76,17 @@ const uiHTML = `<!DOCTYPE html>
   .btn-reconcile:hover { background: #e0285f; }
   .btn-reconcile:active { background: #c92255; }
   .btn-reconcile:disabled { background: #27272a; color: #52525b; cursor: not-allowed; }
+  .btn-cancel {
+    background: none;
+    color: #f87171;
+    border: 1px solid #dc2626;
+    padding: 9px 16px;
+    border-radius: 8px;
+    font-size: 14px;
+    font-weight: 600;
+    cursor: pointer;
+  }
+  .btn-cancel:hover { background: rgba(248, 113, 113, 0.1); }
 
   .status-bar {
     display: grid;
@@ -124,6 +135,10 @@ const uiHTML = `<!DOCTYPE html>
   .badge-deleting { background: #4d1500; color: #fb7a37; }
   .badge-syncing { background: #0d2d2a; color: #2dd4bf; }
   .badge-idle { background: #3f3f46; color: #a1a1aa; }
+  .badge-blocked { background: #422006; color: #facc15; }
+  .badge-ignored { background: #27272a; color: #a1a1aa; border: 1px dashed #52525b; }
+  .badge-pendingdelete { background: #451a1e; color: #fca5a5; border: 1px dashed #f87171; }
+  .badge-timedout { background: #451a1e; color: #fb923c; border: 1px dashed #fb923c; }
   .badge-ready { background: #14532d; color: #4ade80; }
   .badge-notready { background: #451a1e; color: #f87171; }
 
@@ -147,6 +162,45 @@ const uiHTML = `<!DOCTYPE html>
     color: #fb923c;
     border-color: #fb923c;
   }
+  .policy-tag {
+    display: inline-block;
+    padding: 2px 8px;
+    border-radius: 4px;
+    font-size: 10px;
+    font-weight: 600;
+    text-transform: uppercase;
+    margin-right: 8px;
+    color: #a78bfa;
+    border: 1px dashed #7c3aed;
+  }
+  .capacity {
+    display: inline-block;
+    padding: 2px 8px;
+    border-radius: 4px;
+    font-size: 10px;
+    font-weight: 600;
+    margin-right: 8px;
+    white-space: nowrap;
+  }
+  .next-window {
+    font-size: 11px;
+    color: #a1a1aa;
+    margin-right: 8px;
+    white-space: nowrap;
+  }
+  .capacity.ok { background: #14532d; color: #4ade80; }
+  .capacity.insufficient { background: #451a1e; color: #f87171; }
+  .capacity.ondemand { background: #1e3a5f; color: #60a5fa; }
+  .capacity.unknown { background: #3f3f46; color: #a1a1aa; }
+  .patch-target {
+    color: #a1a1aa;
+    font-size: 11px;
+    margin-right: 8px;
+    white-space: nowrap;
+    overflow: hidden;
+    text-overflow: ellipsis;
+    max-width: 260px;
+  }
 
   .version-warning {
     background: #431407;
@@ -162,6 +216,41 @@ const uiHTML = `<!DOCTYPE html>
     white-space: nowrap;
   }
   .version-warning .warn-icon { font-size: 14px; }
+  .pin-banner {
+    background: #1e3a5f;
+    border: 1px solid #60a5fa;
+    border-radius: 8px;
+    padding: 8px 14px;
+    color: #60a5fa;
+    font-size: 12px;
+    font-weight: 500;
+    display: flex;
+    align-items: center;
+    gap: 8px;
+    white-space: nowrap;
+  }
+  .queue-badge {
+    background: #27272a;
+    border: 1px solid #52525b;
+    border-radius: 8px;
+    padding: 8px 14px;
+    color: #a1a1aa;
+    font-size: 12px;
+    font-weight: 500;
+    white-space: nowrap;
+    cursor: default;
+  }
+  .pin-banner code { font-family: 'SF Mono', 'Fira Code', monospace; color: #fff; }
+  .btn-unpin {
+    background: none;
+    border: 1px solid #60a5fa;
+    color: #60a5fa;
+    padding: 2px 8px;
+    border-radius: 4px;
+    font-size: 11px;
+    cursor: pointer;
+  }
+  .btn-unpin:hover { background: rgba(96, 165, 250, 0.1); }
 
   /* Toggle switch */
   .toggle-switch {
@@ -204,6 +293,7 @@ const uiHTML = `<!DOCTYPE html>
     margin-bottom: 24px;
   }
   @media (max-width: 768px) { .panels { grid-template-columns: 1fr; } }
+  .panel-wide { grid-column: 1 / -1; }
   .panel {
     background: #27272a;
     border: 1px solid #3f3f46;
@@ -263,6 +353,17 @@ const uiHTML = `<!DOCTYPE html>
     font-family: 'SF Mono', 'Fira Code', monospace;
   }
   .btn-sync:hover { border-color: #fbbf24; background: rgba(251, 191, 36, 0.1); }
+  .btn-delete {
+    background: none;
+    border: 1px solid #dc2626;
+    color: #f87171;
+    padding: 2px 8px;
+    border-radius: 4px;
+    font-size: 11px;
+    cursor: pointer;
+    font-family: 'SF Mono', 'Fira Code', monospace;
+  }
+  .btn-delete:hover { border-color: #f87171; background: rgba(248, 113, 113, 0.1); }
   .btn-export {
     background: none;
     border: 1px solid #0891b2;
@@ -282,6 +383,16 @@ const uiHTML = `<!DOCTYPE html>
   .btn-back:hover { border-color: #a1a1aa; color: #fff; }
   .panel-nav-link { cursor: pointer; transition: color 0.15s; }
   .panel-nav-link:hover { color: #FB326E; }
+  .history-panel { margin: 0 24px 24px; }
+  .history-table { width: 100%; border-collapse: collapse; font-size: 13px; }
+  .history-table th { text-align: left; padding: 10px 20px; font-size: 11px; font-weight: 600; color: #71717a; text-transform: uppercase; letter-spacing: 0.05em; border-bottom: 1px solid #3f3f46; }
+  .history-table td { padding: 10px 20px; border-bottom: 1px solid #1b1b1d; vertical-align: top; color: #a1a1aa; }
+  .history-table tr:last-child td { border-bottom: none; }
+  .history-sha { font-family: 'SF Mono', 'Fira Code', monospace; color: #e4e4e7; }
+  .history-msg { color: #e4e4e7; }
+  .history-resource { display: inline-block; font-family: 'SF Mono', 'Fira Code', monospace; font-size: 11px; color: #a1a1aa; border: 1px solid #3f3f46; border-radius: 4px; padding: 1px 6px; margin: 0 4px 4px 0; cursor: pointer; }
+  .history-resource:hover { border-color: #FB326E; color: #FB326E; }
+  .history-filter { background: #18181b; border: 1px solid #3f3f46; color: #a1a1aa; border-radius: 4px; font-size: 11px; padding: 2px 6px; }
   .cluster-grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(320px, 1fr)); gap: 16px; padding: 24px; align-items: start; }
   .cluster-card { background: #27272a; border: 1px solid #3f3f46; border-radius: 12px; overflow: hidden; }
   .cluster-card-header { padding: 14px 18px; border-bottom: 1px solid #3f3f46; display: flex; justify-content: space-between; align-items: center; }
@@ -600,9 +711,15 @@ const uiHTML = `<!DOCTYPE html>
   var machineClassSortAZ = true;
   var clusterPage = 1;
   var clusterSortAZ = true;
+  var configPatchPage = 1;
+  var configPatchSortAZ = true;
+  var accessPage = 1;
+  var machinePage = 1;
   var pageSize = 5;
   var logsModal = false;
   var viewClusters = window.location.pathname === '/clusters';
+  var viewHistory = window.location.pathname === '/history';
+  var historyFilter = '';
   var ws = null;
   var wsReconnectDelay = 1000;
   var wsReconnectTimer = null;
@@ -660,6 +777,54 @@ const uiHTML = `<!DOCTYPE html>
     render();
   }
 
+  function showConfigPatchModal(id) {
+    if (!state || !state.configPatches) return;
+    var p = state.configPatches.find(function(cp) { return cp.id === id; });
+    if (!p) return;
+    currentModal = {
+      id: id,
+      fileContent: p.fileContent || '',
+      liveContent: p.liveContent || '',
+      diff: p.diff || '',
+      error: p.error || '',
+      activeTab: p.error ? 'error' : 'live',
+      type: 'configpatch'
+    };
+    render();
+  }
+
+  function showMachineModal(id) {
+    if (!state || !state.machines) return;
+    var m = state.machines.find(function(r) { return r.id === id; });
+    if (!m) return;
+    currentModal = {
+      id: id,
+      fileContent: m.fileContent || '',
+      liveContent: m.liveContent || '',
+      diff: m.diff || '',
+      error: m.error || '',
+      activeTab: m.error ? 'error' : 'live',
+      type: 'machine'
+    };
+    render();
+  }
+
+  function showAccessModal(type, id) {
+    if (!state || !state.access) return;
+    var a = state.access.find(function(r) { return r.type === type && r.id === id; });
+    if (!a) return;
+    currentModal = {
+      id: id,
+      fileContent: a.fileContent || '',
+      liveContent: a.liveContent || '',
+      diff: a.diff || '',
+      error: a.error || '',
+      activeTab: a.error ? 'error' : 'live',
+      type: 'access'
+    };
+    render();
+  }
+
   function setModalTab(tab) {
     if (!currentModal) return;
     currentModal.activeTab = tab;
@@ -722,6 +887,11 @@ const uiHTML = `<!DOCTYPE html>
     if (st === 'unmanaged') return 'badge-unmanaged';
     if (st === 'syncing') return 'badge-syncing';
     if (st === 'deleting') return 'badge-deleting';
+    if (st === 'blocked') return 'badge-blocked';
+    if (st === 'pendingdelete') return 'badge-pendingdelete';
+    if (st === 'ignored') return 'badge-ignored';
+    if (st === 'timedout') return 'badge-timedout';
+    if (st === 'cancelled') return 'badge-ignored';
     return 'badge-idle';
   }
 
@@ -735,6 +905,11 @@ const uiHTML = `<!DOCTYPE html>
   function getGitHealth(s) {
     if (!s || !s.git) return { status: 'unknown', label: 'Unknown' };
 
+    // A rejected commit takes precedence over everything else
+    if (s.git.signature === 'untrusted') {
+      return { status: 'untrusted', label: 'Untrusted commit' };
+    }
+
     // Check if we have valid git data
     if (!s.git.sha || !s.git.lastSync) {
       return { status: 'disconnected', label: 'Disconnected' };
@@ -763,6 +938,7 @@ const uiHTML = `<!DOCTYPE html>
     if (status === 'degraded') return 'badge-outofsync';
     if (status === 'stale') return 'badge-outofsync';
     if (status === 'disconnected') return 'badge-failed';
+    if (status === 'untrusted') return 'badge-failed';
     return 'badge-idle';
   }
 
@@ -785,9 +961,7 @@ const uiHTML = `<!DOCTYPE html>
 
   async function checkGit() {
     try {
-      var r = await fetch('/api/check', { method: 'POST' });
-      var d = await r.json();
-      if (d.status === 'already running') alert('Reconcile already in progress');
+      await fetch('/api/check', { method: 'POST' });
       fetchState();
     } catch(e) {
       alert('Failed to trigger git check');
@@ -798,13 +972,33 @@ const uiHTML = `<!DOCTYPE html>
     try {
       var r = await fetch('/api/reconcile', { method: 'POST' });
       var d = await r.json();
-      if (d.status === 'already running') alert('Reconcile already in progress');
+      if (d.status === 'blocked') alert('Sync blocked: ' + d.reason);
       fetchState();
     } catch(e) {
       alert('Failed to trigger reconcile');
     }
   }
 
+  function cancelReconcile() {
+    confirmModal = {
+      title: 'Cancel Reconcile',
+      message: 'Abort the running reconcile?\n\nomnictl calls in flight are stopped and nothing is pruned. Resources that were not reached keep their last state until the next sync.',
+      onConfirm: async function() {
+        confirmModal = null;
+        render();
+        try {
+          var r = await fetch('/api/reconcile/cancel', { method: 'POST' });
+          var d = await r.json();
+          if (d.status === 'not running') alert('No reconcile is running');
+          fetchState();
+        } catch(e) {
+          alert('Failed to cancel reconcile');
+        }
+      }
+    };
+    render();
+  }
+
   async function toggleClusters() {
     try {
       var r = await fetch('/api/clusters-toggle', { method: 'POST' });
@@ -819,41 +1013,180 @@ const uiHTML = `<!DOCTYPE html>
     // Prevent event bubbling
     event.stopPropagation();
 
+    // Outside its sync window, a force sync has to override the window
+    var cluster = (state && state.clusters || []).find(function(c) { return c.id === clusterId; });
+    var overrideWindow = !!(cluster && cluster.waitingForWindow);
+
     // Show confirmation modal
     confirmModal = {
       clusterId: clusterId,
-      title: 'Force Sync Cluster',
-      message: 'Are you sure you want to force sync cluster "' + clusterId + '"?\n\nThis will immediately sync the cluster with the configuration from Git.',
+      title: overrideWindow ? 'Force Sync Outside Sync Window' : 'Force Sync Cluster',
+      message: overrideWindow
+        ? 'Cluster "' + clusterId + '" is outside its sync window.\n\nForce syncing overrides the window and applies the changes from Git right now.'
+        : 'Are you sure you want to force sync cluster "' + clusterId + '"?\n\nThis will immediately sync the cluster with the configuration from Git.',
       onConfirm: function() {
         confirmModal = null;
         render();
-        doForceSync(clusterId);
+        doForceSync(clusterId, overrideWindow);
       }
     };
     render();
   }
 
-  async function doForceSync(clusterId) {
+  async function doForceSync(clusterId, overrideWindow) {
     try {
-      // First, set the cluster ID to force sync
-      await fetch('/api/force-cluster', {
+      var r = await fetch('/api/force-cluster', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ id: clusterId })
+        body: JSON.stringify({ id: clusterId, overrideWindow: overrideWindow })
       });
-
-      // Then trigger reconcile
-      var r = await fetch('/api/reconcile', { method: 'POST' });
       var d = await r.json();
       if (d.status === 'blocked') {
         alert('Sync blocked: ' + d.reason);
-      } else if (d.status === 'already running') {
-        alert('Reconcile already in progress');
       } else {
         fetchState();
       }
     } catch(e) {
-      alert('Failed to trigger sync');
+      alert('Failed to queue sync');
+    }
+  }
+
+  function forceSyncMachineClass(id, event) {
+    event.stopPropagation();
+
+    confirmModal = {
+      title: 'Force Sync Machine Class',
+      message: 'Are you sure you want to force sync machine class "' + id + '"?\n\nIt is applied from Git despite its manual sync policy.',
+      onConfirm: async function() {
+        confirmModal = null;
+        render();
+        try {
+          var r = await fetch('/api/queue', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ kind: 'targeted', machineClasses: [id] })
+          });
+          var d = await r.json();
+          if (d.status === 'blocked') {
+            alert('Sync blocked: ' + d.reason);
+          } else {
+            fetchState();
+          }
+        } catch(e) {
+          alert('Failed to queue sync');
+        }
+      }
+    };
+    render();
+  }
+
+  // describeRequest summarises a queued reconcile request for the queue tooltip.
+  function describeRequest(q) {
+    var what = q.kind;
+    var targets = (q.clusters || []).concat(q.machineClasses || [], q.clusterSelectors || [], q.machineClassSelectors || []);
+    if (targets.length > 0) what += ' ' + targets.join(', ');
+    if (q.overrideWindow) what += ' (overrides sync windows)';
+    return '#' + q.id + ' ' + what + ' \u2013 ' + (q.triggers || []).join(', ');
+  }
+
+  function renderQueueBadge(s) {
+    var queue = s.queue || {};
+    var pending = queue.pending || [];
+    if (pending.length === 0) return '';
+    var lines = [];
+    if (queue.running) lines.push('Running: ' + describeRequest(queue.running));
+    pending.forEach(function(q) { lines.push('Queued: ' + describeRequest(q)); });
+    return '<span class="queue-badge" title="' + escHtml(lines.join('\n')).replace(/"/g, '&quot;') + '">' +
+      pending.length + ' queued</span>';
+  }
+
+  var deleteTypeNames = {
+    Cluster: 'cluster',
+    MachineClass: 'machine class',
+    MachineLabels: 'machine labels',
+    MachineRequestSet: 'machine request set',
+    ConfigPatch: 'config patch'
+  };
+
+  function confirmDelete(type, id, event) {
+    event.stopPropagation();
+
+    confirmModal = {
+      title: 'Confirm Deletion',
+      message: 'Delete ' + (deleteTypeNames[type] || type) + ' "' + id + '" from Omni?\n\nIt was removed from Git and the prune guard is holding the deletion back. This cannot be undone.',
+      onConfirm: function() {
+        confirmModal = null;
+        render();
+        doConfirmDelete(type, id);
+      }
+    };
+    render();
+  }
+
+  async function doConfirmDelete(type, id) {
+    try {
+      var r = await fetch('/api/confirm-delete', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ type: type, id: id })
+      });
+      if (!r.ok) {
+        alert('Failed to confirm deletion: ' + (await r.text()));
+        return;
+      }
+      fetchState();
+    } catch(e) {
+      alert('Failed to confirm deletion');
+    }
+  }
+
+  function pinCommit(sha) {
+    confirmModal = {
+      title: 'Pin Deployment',
+      message: 'Pin Omni CD to commit ' + sha.substring(0, 7) + '?\n\nNew commits on the tracked branch are ignored until the pin is removed. The pinned commit is synced immediately.',
+      onConfirm: function() {
+        confirmModal = null;
+        render();
+        doPinCommit(sha);
+      }
+    };
+    render();
+  }
+
+  function promptPinCommit() {
+    var sha = window.prompt('Commit SHA to pin to:', state && state.git ? (state.git.sha || '') : '');
+    if (!sha) return;
+    sha = sha.trim();
+    if (!/^[0-9a-fA-F]{4,40}$/.test(sha)) {
+      alert('Invalid commit SHA');
+      return;
+    }
+    pinCommit(sha);
+  }
+
+  async function doPinCommit(sha) {
+    try {
+      var r = await fetch('/api/pin', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ sha: sha })
+      });
+      if (!r.ok) {
+        alert('Failed to pin commit: ' + (await r.text()));
+        return;
+      }
+      await triggerReconcile();
+    } catch(e) {
+      alert('Failed to pin commit');
+    }
+  }
+
+  async function unpinCommit() {
+    try {
+      await fetch('/api/unpin', { method: 'POST' });
+      await triggerReconcile();
+    } catch(e) {
+      alert('Failed to unpin commit');
     }
   }
 
@@ -918,6 +1251,27 @@ const uiHTML = `<!DOCTYPE html>
     render();
   }
 
+  function changeConfigPatchPage(page) {
+    configPatchPage = page;
+    render();
+  }
+
+  function toggleConfigPatchSort() {
+    configPatchSortAZ = !configPatchSortAZ;
+    configPatchPage = 1;
+    render();
+  }
+
+  function changeMachinePage(page) {
+    machinePage = page;
+    render();
+  }
+
+  function changeAccessPage(page) {
+    accessPage = page;
+    render();
+  }
+
   function toggleClusterSort() {
     clusterSortAZ = !clusterSortAZ;
     clusterPage = 1;
@@ -932,10 +1286,71 @@ const uiHTML = `<!DOCTYPE html>
     window.location.href = '/';
   }
 
+  function showHistoryView() {
+    window.location.href = '/history';
+  }
+
+  function setHistoryFilter(resource) {
+    historyFilter = resource;
+    render();
+  }
+
+  function renderHistoryView(s) {
+    var history = s.history || [];
+    var resources = {};
+    history.forEach(function(h) {
+      (h.resources || []).forEach(function(r) { resources[r] = true; });
+    });
+    var entries = historyFilter
+      ? history.filter(function(h) { return (h.resources || []).indexOf(historyFilter) !== -1; })
+      : history;
+
+    var rows = entries.map(function(h) {
+      var touched = h.fullSync ? '<span style="color:#52525b">full sync</span> ' : '';
+      touched += (h.resources || []).map(function(r) {
+        return '<span class="history-resource" onclick="window.__setHistoryFilter(\'' + escHtml(r) + '\')">' + escHtml(r) + '</span>';
+      }).join('');
+      if (!touched) touched = '<span style="color:#52525b">-</span>';
+      return '<tr>' +
+        '<td class="history-sha">' + escHtml(h.shortSha || '') + '</td>' +
+        '<td><div class="history-msg">' + escHtml(h.message || '') + '</div>' +
+          '<div style="font-size:11px">' + escHtml(h.author || '') + (h.commitTime ? ' &middot; ' + new Date(h.commitTime).toLocaleString() : '') + '</div></td>' +
+        '<td>' + touched + '</td>' +
+        '<td>' + ts(h.reconciledAt) +
+          (h.lastResync ? '<div style="font-size:11px;color:#52525b">resynced ' + ts(h.lastResync.reconciledAt) + '</div>' : '') + '</td>' +
+        '<td><span class="badge ' + badgeClass(h.outcome) + '">' + (h.outcome || 'unknown') + '</span>' +
+          (h.lastResync ? '<div style="font-size:11px"><span class="badge ' + badgeClass(h.lastResync.outcome) + '">' + escHtml(h.lastResync.outcome || 'unknown') + '</span></div>' : '') + '</td>' +
+      '</tr>';
+    }).join('');
+
+    var options = '<option value="">All resources</option>' +
+      Object.keys(resources).sort().map(function(r) {
+        return '<option value="' + escHtml(r) + '"' + (r === historyFilter ? ' selected' : '') + '>' + escHtml(r) + '</option>';
+      }).join('');
+
+    return renderHeader(s) +
+      '<div style="padding:0 24px 16px">' +
+        '<a class="btn-back" href="/">← Back</a>' +
+      '</div>' +
+      '<div class="panel history-panel">' +
+        '<div class="panel-header">Commit History ' +
+          '<div class="panel-header-right">' +
+            '<select class="history-filter" onchange="window.__setHistoryFilter(this.value)">' + options + '</select>' +
+            '<span class="count">' + entries.length + '</span>' +
+          '</div>' +
+        '</div>' +
+        (entries.length > 0
+          ? '<table class="history-table"><thead><tr>' +
+              '<th>Commit</th><th>Message</th><th>Resources</th><th>Reconciled</th><th>Outcome</th>' +
+            '</tr></thead><tbody>' + rows + '</tbody></table>'
+          : '<div class="resource-item" style="color:#52525b">No commits reconciled yet</div>') +
+      '</div>';
+  }
+
   function renderHeader(s) {
     var isRunning = s.lastReconcile && s.lastReconcile.status === 'running';
     var mismatch = s.versionMismatch;
-    var syncDisabled = isRunning || mismatch;
+    var syncDisabled = mismatch;
     return '<div class="header">' +
       '<h1>' +
         '<img class="logo" src="https://mintlify.s3.us-west-1.amazonaws.com/siderolabs-fe86397c/images/omni.svg" alt="Omni">' +
@@ -946,13 +1361,19 @@ const uiHTML = `<!DOCTYPE html>
             '<span class="warn-icon">&#9888;</span>' +
             'Omni ' + s.omniVersion + ' &gt; omnictl ' + s.omnictlVersion +
           '</div>' : '') +
+        (s.pinnedSha ?
+          '<div class="pin-banner">' +
+            '&#128204; Pinned to <code>' + s.pinnedSha.substring(0, 7) + '</code>' +
+            '<button class="btn-unpin" onclick="window.__unpinCommit()">unpin</button>' +
+          '</div>' : '') +
         (isRunning ? '<span class="spinner"></span>' : '') +
-        '<button class="btn-check" onclick="window.__checkGit()" ' +
-          (isRunning ? 'disabled' : '') + '>Refresh</button>' +
+        renderQueueBadge(s) +
+        '<button class="btn-check" onclick="window.__checkGit()">Refresh</button>' +
         '<button class="btn-reconcile" onclick="window.__triggerReconcile()" ' +
           (syncDisabled ? 'disabled' : '') + '>' +
           (isRunning ? 'Syncing...' : 'Sync') +
         '</button>' +
+        (isRunning ? '<button class="btn-cancel" onclick="window.__cancelReconcile()">Cancel</button>' : '') +
         '<button class="btn-logs" onclick="window.__showLogsModal()">Logs</button>' +
       '</div>' +
     '</div>';
@@ -1000,7 +1421,8 @@ const uiHTML = `<!DOCTYPE html>
           '<div style="display:flex;align-items:center;gap:6px">' +
             (c.clusterReady ? '<span class="badge ' + (c.clusterReady === 'ready' ? 'badge-ready' : c.clusterReady === 'not-ready' ? 'badge-notready' : 'badge-idle') + '">' + (c.clusterReady === 'ready' ? 'healthy' : c.clusterReady === 'not-ready' ? 'unhealthy' : 'unknown') + '</span>' : '') +
             (c.kubernetesApiReady ? '<span class="badge ' + (c.kubernetesApiReady === 'ready' ? 'badge-ready' : 'badge-notready') + '">apiserver</span>' : '') +
-            '<span class="badge ' + badgeClass(c.status) + '">' + (c.status === 'success' ? 'synced' : c.status) + '</span>' +
+            windowStatus(c) +
+            '<span class="badge ' + badgeClass(c.status) + '">' + (c.status === 'success' ? 'synced' : c.status === 'timedout' ? 'timed out' : c.waitingForWindow ? 'waiting for window' : c.status) + '</span>' +
           '</div>' +
         '</div>' +
         '<div class="cluster-cp-section">' +
@@ -1038,7 +1460,7 @@ const uiHTML = `<!DOCTYPE html>
           '<div class="modal-tabs">' +
             (currentModal.error ? '<button class="modal-tab ' + (currentModal.activeTab === 'error' ? 'active' : '') + '" onclick="window.__setModalTab(\'error\')">Error</button>' : '') +
             '<button class="modal-tab ' + (currentModal.activeTab === 'live' ? 'active' : '') + '" onclick="window.__setModalTab(\'live\')">Live</button>' +
-            (currentModal.type === 'cluster' ? '<button class="modal-tab ' + (currentModal.activeTab === 'diff' ? 'active' : '') + '" onclick="window.__setModalTab(\'diff\')">Diff</button>' : '') +
+            '<button class="modal-tab ' + (currentModal.activeTab === 'diff' ? 'active' : '') + '" onclick="window.__setModalTab(\'diff\')">Diff</button>' +
           '</div>' : '') +
         '<div class="modal-body">' +
           (currentModal ?
@@ -1115,6 +1537,8 @@ const uiHTML = `<!DOCTYPE html>
     var s = state;
     if (viewClusters) {
       app.innerHTML = renderClustersView(s);
+    } else if (viewHistory) {
+      app.innerHTML = renderHistoryView(s);
     } else {
       app.innerHTML =
         renderHeader(s) +
@@ -1135,7 +1559,8 @@ const uiHTML = `<!DOCTYPE html>
             '<div class="label">Resources</div>' +
             '<div class="value">' +
               (s.machineClasses ? s.machineClasses.length : 0) + ' MachineClasses &middot; ' +
-              (s.clusters ? s.clusters.length : 0) + ' Clusters</div>' +
+              (s.clusters ? s.clusters.length : 0) + ' Clusters' +
+              (s.configPatches && s.configPatches.length > 0 ? ' &middot; ' + s.configPatches.length + ' ConfigPatches' : '') + '</div>' +
             '<div class="sub">Managed by Omni CD</div>' +
           '</div>' +
         '</div>';
@@ -1162,6 +1587,121 @@ const uiHTML = `<!DOCTYPE html>
     return items.slice(start, end);
   }
 
+  function renderConfigPatchesPanel(s) {
+    var sorted = s.configPatches.slice().sort(function(a, b) { return configPatchSortAZ ? a.id.localeCompare(b.id) : b.id.localeCompare(a.id); });
+    return '<div class="panel panel-wide">' +
+      '<div class="panel-header">Config Patches ' +
+        '<div class="panel-header-right">' +
+          '<button class="btn-sort active" onclick="window.__toggleConfigPatchSort()">' + (configPatchSortAZ ? 'A→Z' : 'Z→A') + '</button>' +
+          '<span class="count">' + s.configPatches.length + '</span>' +
+        '</div>' +
+      '</div>' +
+      '<div class="resource-list">' +
+        paginateItems(sorted, configPatchPage).map(function(r) {
+          var displayStatus = r.status === 'success' ? 'synced' : r.status === 'pendingdelete' ? 'pending delete' : r.status;
+          var hasDetails = (r.diff && r.diff.length > 0) || (r.fileContent && r.fileContent.length > 0) || (r.error && r.error.length > 0);
+          var target = r.target ? '<span class="patch-target" title="' + escHtml(r.target) + '">' + escHtml(r.target) + '</span>' : '';
+          return '<div class="resource-item">' +
+            '<span class="resource-id' + (hasDetails ? ' clickable' : '') + '"' +
+              (hasDetails ? ' onclick="window.__showConfigPatchModal(\'' + r.id + '\')"' : '') + '>' + r.id +
+            '</span><div class="resource-right">' + target +
+            (r.status === 'pendingdelete' ? '<button class="btn-delete" onclick="window.__confirmDelete(\'ConfigPatch\', \'' + r.id + '\', event)">confirm delete</button>' : '') +
+            '<span class="badge ' + badgeClass(r.status) + '">' +
+            displayStatus + '</span></div></div>';
+        }).join('') +
+      '</div>' +
+      (s.configPatches.length > pageSize ? renderPagination(sorted, configPatchPage, 'window.__changeConfigPatchPage') : '') +
+    '</div>';
+  }
+
+  function blockedBy(r) {
+    if (!r.blockedBy || r.blockedBy.length === 0) return '';
+    var text = 'blocked by: ' + r.blockedBy.join(', ');
+    return '<span class="patch-target" title="' + escHtml(text) + '">' + escHtml(text) + '</span>';
+  }
+
+  function windowStatus(r) {
+    if (!r.waitingForWindow) return '';
+    return '<span class="next-window">next window ' +
+      (r.nextWindow ? escHtml(new Date(r.nextWindow).toLocaleString()) : 'none within a year') + '</span>';
+  }
+
+  function policyTags(r) {
+    if (!r.policy) return '';
+    return r.policy.filter(function(p) { return p !== 'ignored'; }).map(function(p) {
+      return '<span class="policy-tag">' + escHtml(p) + '</span>';
+    }).join('');
+  }
+
+  function capacityBadge(c) {
+    if (!c) return '';
+    var text;
+    if (c.status === 'ondemand') {
+      text = 'on demand';
+    } else if (c.status === 'unknown') {
+      text = 'capacity unknown';
+    } else {
+      text = c.available + '/' + c.required + ' machines';
+    }
+    var title = c.message || (c.available + ' machines available, clusters need ' + c.required);
+    if (c.clusters && c.clusters.length > 0) title += ' (used by ' + c.clusters.join(', ') + ')';
+    return '<span class="capacity ' + c.status + '" title="' + escHtml(title) + '">' + text + '</span>';
+  }
+
+  function renderMachinesPanel(s) {
+    var sorted = s.machines.slice().sort(function(a, b) { return a.type.localeCompare(b.type) || a.id.localeCompare(b.id); });
+    return '<div class="panel panel-wide">' +
+      '<div class="panel-header">Machine Labels &amp; Request Sets ' +
+        '<div class="panel-header-right">' +
+          '<span class="count">' + s.machines.length + '</span>' +
+        '</div>' +
+      '</div>' +
+      '<div class="resource-list">' +
+        paginateItems(sorted, machinePage).map(function(r) {
+          var displayStatus = r.status === 'success' ? 'synced' : r.status === 'pendingdelete' ? 'pending delete' : r.status;
+          var hasDetails = (r.diff && r.diff.length > 0) || (r.fileContent && r.fileContent.length > 0) || (r.error && r.error.length > 0);
+          var detail = r.type + (r.summary ? ' &middot; ' + escHtml(r.summary) : '');
+          return '<div class="resource-item">' +
+            '<span class="resource-id' + (hasDetails ? ' clickable' : '') + '"' +
+              (hasDetails ? ' onclick="window.__showMachineModal(\'' + escHtml(r.id) + '\')"' : '') + '>' + escHtml(r.id) +
+            '</span><div class="resource-right"><span class="patch-target" title="' + detail + '">' + detail + '</span>' + policyTags(r) +
+            (r.status === 'pendingdelete' ? '<button class="btn-delete" onclick="window.__confirmDelete(\'' + escHtml(r.type) + '\', \'' + escHtml(r.id) + '\', event)">confirm delete</button>' : '') +
+            '<span class="badge ' + badgeClass(r.status) + '">' +
+            displayStatus + '</span></div></div>';
+        }).join('') +
+      '</div>' +
+      (s.machines.length > pageSize ? renderPagination(sorted, machinePage, 'window.__changeMachinePage') : '') +
+    '</div>';
+  }
+
+  function renderAccessPanel(s) {
+    // Users first, then service accounts, then the access policy
+    var order = { User: 0, ServiceAccount: 1, AccessPolicy: 2 };
+    var sorted = s.access.slice().sort(function(a, b) {
+      return (order[a.type] - order[b.type]) || a.id.localeCompare(b.id);
+    });
+    return '<div class="panel panel-wide">' +
+      '<div class="panel-header">Access ' +
+        '<div class="panel-header-right">' +
+          '<span class="count">' + s.access.length + '</span>' +
+        '</div>' +
+      '</div>' +
+      '<div class="resource-list">' +
+        paginateItems(sorted, accessPage).map(function(r) {
+          var displayStatus = r.status === 'success' ? 'synced' : r.status;
+          var hasDetails = (r.diff && r.diff.length > 0) || (r.liveContent && r.liveContent.length > 0) || (r.error && r.error.length > 0);
+          var detail = r.type + (r.role ? ' &middot; ' + escHtml(r.role) : '');
+          return '<div class="resource-item">' +
+            '<span class="resource-id' + (hasDetails ? ' clickable' : '') + '"' +
+              (hasDetails ? ' onclick="window.__showAccessModal(\'' + r.type + '\', \'' + escHtml(r.id) + '\')"' : '') + '>' + escHtml(r.id) +
+            '</span><div class="resource-right"><span class="patch-target">' + detail + '</span><span class="badge ' + badgeClass(r.status) + '">' +
+            displayStatus + '</span></div></div>';
+        }).join('') +
+      '</div>' +
+      (s.access.length > pageSize ? renderPagination(sorted, accessPage, 'window.__changeAccessPage') : '') +
+    '</div>';
+  }
+
   function renderPagination(items, currentPage, onPageChange) {
     var totalPages = Math.ceil(items.length / pageSize);
     if (totalPages <= 1) return '';
@@ -1192,6 +1732,12 @@ const uiHTML = `<!DOCTYPE html>
       return;
     }
 
+    if (viewHistory) {
+      app.innerHTML = renderHistoryView(s);
+      modalsEl.innerHTML = renderModal();
+      return;
+    }
+
     app.innerHTML =
       renderHeader(s) +
 
@@ -1212,7 +1758,8 @@ const uiHTML = `<!DOCTYPE html>
           '<div class="label">Resources</div>' +
           '<div class="value">' +
             (s.machineClasses ? s.machineClasses.length : 0) + ' MachineClasses &middot; ' +
-            (s.clusters ? s.clusters.length : 0) + ' Clusters</div>' +
+            (s.clusters ? s.clusters.length : 0) + ' Clusters' +
+            (s.configPatches && s.configPatches.length > 0 ? ' &middot; ' + s.configPatches.length + ' ConfigPatches' : '') + '</div>' +
           '<div class="sub">Managed by Omni CD</div>' +
         '</div>' +
       '</div>';
@@ -1224,16 +1771,24 @@ const uiHTML = `<!DOCTYPE html>
           '<div class="status-card">' +
             '<div class="label" style="display:flex;justify-content:space-between;align-items:center">' +
               '<span>Git Status</span>' +
-              '<span class="badge ' + gitHealthBadgeClass(gitHealth.status) + '">' + gitHealth.label + '</span>' +
+              '<span style="display:flex;align-items:center;gap:6px">' +
+                (s.pinnedSha ? '' : '<button class="btn-unpin" onclick="window.__promptPinCommit()">pin</button>') +
+                '<span class="badge ' + gitHealthBadgeClass(gitHealth.status) + '">' + gitHealth.label + '</span>' +
+              '</span>' +
             '</div>' +
             '<div class="value">Repository: ' + (s.git.repo ? '<a href="' + s.git.repo + '" target="_blank" style="color:#FB326E;text-decoration:none">' + s.git.repo + '</a>' : '-') + '</div>' +
-            '<div class="sub">Branch: ' + (s.git.branch || '-') + '</div>' +
-            '<div class="sub">Commit: ' + (s.git.shortSha || '-') + (s.git.commitMessage ? ' - ' + s.git.commitMessage : '') + '</div>' +
+            (s.git.tag
+              ? '<div class="sub">Release: ' + s.git.tag + '</div>'
+              : '<div class="sub">Branch: ' + (s.git.branch || '-') + '</div>') +
+            '<div class="sub">Commit: ' + (s.git.shortSha || '-') + (s.git.commitMessage ? ' - ' + s.git.commitMessage : '') +
+              (s.git.signature === 'verified' ? ' <span style="color:#4ade80">&#10003; signed</span>' : '') + '</div>' +
+            (s.git.signature === 'untrusted' ?
+              '<div class="sub" style="color:#f87171">Rejected ' + s.git.untrustedSha.substring(0, 8) + ': ' + escHtml(s.git.signatureError || '') + '</div>' : '') +
             '<div class="sub">Last sync: ' + ago(s.git.lastSync) + '</div>' +
           '</div>' +
           '<div class="status-card">' +
             '<div class="label" style="display:flex;justify-content:space-between;align-items:center">' +
-              '<span>Last Reconciliation</span>' +
+              '<span class="panel-nav-link" onclick="window.__showHistoryView()">Last Reconciliation</span>' +
               '<span class="badge ' + badgeClass(s.lastReconcile.status) + '">' + (s.lastReconcile.status || 'idle') + '</span>' +
             '</div>' +
             '<div class="value">Type: ' + (s.lastReconcile.type === 'soft' ? 'Refresh' : s.lastReconcile.type === 'hard' ? 'Sync' : '-') + '</div>' +
@@ -1254,15 +1809,18 @@ const uiHTML = `<!DOCTYPE html>
           '<div class="resource-list">' +
             (s.machineClasses && s.machineClasses.length > 0
               ? paginateItems(s.machineClasses.slice().sort(function(a, b) { return machineClassSortAZ ? a.id.localeCompare(b.id) : b.id.localeCompare(a.id); }), machineClassPage).map(function(r) {
-                  var displayStatus = r.status === 'success' ? 'synced' : r.status;
+                  var displayStatus = r.status === 'success' ? 'synced' : r.status === 'pendingdelete' ? 'pending delete' : r.status;
                   var provisionBadge = r.provisionType ? '<span class="provision-type ' + r.provisionType + '">' + (r.provisionType === 'auto' ? 'auto provision' : r.provisionType) + '</span>' : '';
                   var hasDiff = r.diff && r.diff.length > 0;
                   var hasFile = r.fileContent && r.fileContent.length > 0;
-                  var hasDetails = hasDiff || hasFile;
+                  var hasDetails = hasDiff || hasFile || (r.error && r.error.length > 0);
                   return '<div class="resource-item">' +
                     '<span class="resource-id' + (hasDetails ? ' clickable' : '') + '"' +
                       (hasDetails ? ' onclick="window.__showMachineClassModal(\'' + r.id + '\')"' : '') + '>' + r.id +
-                    '</span><div class="resource-right">' + provisionBadge + '<span class="badge ' + badgeClass(r.status) + '">' +
+                    '</span><div class="resource-right">' + blockedBy(r) + policyTags(r) + capacityBadge(r.capacity) + provisionBadge +
+                    (r.status === 'outofsync' && hasFile ? '<button class="btn-sync" onclick="window.__forceSyncMachineClass(\'' + r.id + '\', event)">force sync</button>' : '') +
+                    (r.status === 'pendingdelete' ? '<button class="btn-delete" onclick="window.__confirmDelete(\'MachineClass\', \'' + r.id + '\', event)">confirm delete</button>' : '') +
+                    '<span class="badge ' + badgeClass(r.status) + '">' +
                     displayStatus + '</span></div></div>';
                 }).join('')
               : '<div class="resource-item" style="color:#52525b">No machine classes</div>') +
@@ -1297,6 +1855,8 @@ const uiHTML = `<!DOCTYPE html>
                     // Show both out of sync and failed
                     badges = '<span class="badge badge-outofsync">out of sync</span>' +
                              '<span class="badge badge-failed">failed</span>';
+                  } else if (r.status === 'outofsync' && r.waitingForWindow) {
+                    badges = windowStatus(r) + '<span class="badge badge-outofsync">out of sync &ndash; waiting for window</span>';
                   } else if (r.status === 'outofsync') {
                     badges = '<span class="badge badge-outofsync">out of sync</span>';
                   } else if (r.status === 'success') {
@@ -1309,6 +1869,14 @@ const uiHTML = `<!DOCTYPE html>
                     badges = '<span class="badge badge-deleting">deleting</span>';
                   } else if (r.status === 'syncing') {
                     badges = '<span class="badge badge-syncing">syncing</span>';
+                  } else if (r.status === 'blocked') {
+                    badges = blockedBy(r) + '<span class="badge badge-blocked">blocked</span>';
+                  } else if (r.status === 'pendingdelete') {
+                    badges = '<span class="badge badge-pendingdelete">pending delete</span>';
+                  } else if (r.status === 'ignored') {
+                    badges = '<span class="badge badge-ignored">ignored</span>';
+                  } else if (r.status === 'timedout') {
+                    badges = '<span class="badge badge-timedout">timed out</span>';
                   } else {
                     badges = '<span class="badge badge-idle">' + r.status + '</span>';
                   }
@@ -1319,8 +1887,10 @@ const uiHTML = `<!DOCTYPE html>
                       r.id +
                     '</span>' +
                     '<div class="resource-right">' +
+                      policyTags(r) +
                       (isUnmanaged ? '<button class="btn-export" onclick="window.__exportCluster(\'' + r.id + '\', event)">export</button>' : '') +
-                      (isOutOfSync && !isFailed ? '<button class="btn-sync" onclick="window.__forceSync(\'' + r.id + '\', event)">force sync</button>' : '') +
+                      ((isOutOfSync && !isFailed) || r.status === 'timedout' ? '<button class="btn-sync" onclick="window.__forceSync(\'' + r.id + '\', event)">force sync</button>' : '') +
+                      (r.status === 'pendingdelete' ? '<button class="btn-delete" onclick="window.__confirmDelete(\'Cluster\', \'' + r.id + '\', event)">confirm delete</button>' : '') +
                       (r.clusterReady ? '<span class="badge ' + (r.clusterReady === 'ready' ? 'badge-ready' : r.clusterReady === 'not-ready' ? 'badge-notready' : 'badge-idle') + '">' + (r.clusterReady === 'ready' ? 'healthy' : r.clusterReady === 'not-ready' ? 'unhealthy' : 'unknown') + '</span>' : '') +
                       (r.kubernetesApiReady ? '<span class="badge ' + (r.kubernetesApiReady === 'ready' ? 'badge-ready' : 'badge-notready') + '">apiserver</span>' : '') +
                       badges +
@@ -1331,6 +1901,9 @@ const uiHTML = `<!DOCTYPE html>
           '</div>' +
           (s.clusters && s.clusters.length > pageSize ? renderPagination(s.clusters.slice().sort(function(a, b) { return clusterSortAZ ? a.id.localeCompare(b.id) : b.id.localeCompare(a.id); }), clusterPage, 'window.__changeClusterPage') : '') +
         '</div>' +
+        (s.configPatches && s.configPatches.length > 0 ? renderConfigPatchesPanel(s) : '') +
+        (s.machines && s.machines.length > 0 ? renderMachinesPanel(s) : '') +
+        (s.access && s.access.length > 0 ? renderAccessPanel(s) : '') +
       '</div>' +
 
       '<div class="refresh-indicator">Omni CD ' + appVersion + ' · Real-time updates</div>';
@@ -1344,9 +1917,12 @@ const uiHTML = `<!DOCTYPE html>
   }
 
   window.__triggerReconcile = triggerReconcile;
+  window.__cancelReconcile = cancelReconcile;
   window.__checkGit = checkGit;
   window.__toggleClusters = toggleClusters;
   window.__forceSync = forceSync;
+  window.__forceSyncMachineClass = forceSyncMachineClass;
+  window.__confirmDelete = confirmDelete;
   window.__exportCluster = exportCluster;
   window.__closeConfirmModal = closeConfirmModal;
   window.__confirmAction = confirmAction;
@@ -1355,11 +1931,23 @@ const uiHTML = `<!DOCTYPE html>
   window.__toggleMachineClassSort = toggleMachineClassSort;
   window.__toggleClusterSort = toggleClusterSort;
   window.__showClustersView = showClustersView;
+  window.__showHistoryView = showHistoryView;
+  window.__setHistoryFilter = setHistoryFilter;
   window.__hideClustersView = hideClustersView;
   window.__showLogsModal = showLogsModal;
   window.__closeLogsModal = closeLogsModal;
   window.__downloadLogs = downloadLogs;
   window.__showMachineClassModal = showMachineClassModal;
+  window.__showConfigPatchModal = showConfigPatchModal;
+  window.__changeConfigPatchPage = changeConfigPatchPage;
+  window.__toggleConfigPatchSort = toggleConfigPatchSort;
+  window.__showMachineModal = showMachineModal;
+  window.__changeMachinePage = changeMachinePage;
+  window.__showAccessModal = showAccessModal;
+  window.__changeAccessPage = changeAccessPage;
+  window.__promptPinCommit = promptPinCommit;
+  window.__pinCommit = pinCommit;
+  window.__unpinCommit = unpinCommit;
 
   // WebSocket connection
   function connectWebSocket() {
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"omni-cd/internal/omni"
+	"omni-cd/internal/queue"
 	"omni-cd/internal/state"
 
 	"github.com/gorilla/websocket"
@@ -21,26 +24,33 @@ var upgrader = websocket.Upgrader{
 
 // Server serves the web UI and API endpoints.
 type Server struct {
-	appState    *state.AppState
-	triggerHard chan struct{}
-	triggerSoft chan struct{}
-	port        string
-	version     string
-	clients     map[*websocket.Conn]bool
-	clientsMu   sync.RWMutex
-	broadcast   chan []byte
+	appState   *state.AppState
+	omniClient omni.Client
+	queue      *queue.Queue // Reconcile requests
+	port       string
+	version    string
+	// Webhook settings
+	gitBranch     string
+	gitRefMode    string
+	webhookSecret string
+	clients       map[*websocket.Conn]bool
+	clientsMu     sync.RWMutex
+	broadcast     chan []byte
 }
 
 // New creates a new web server.
-func New(appState *state.AppState, triggerHard chan struct{}, triggerSoft chan struct{}, port string, version string) *Server {
+func New(appState *state.AppState, omniClient omni.Client, reconcileQueue *queue.Queue, port string, version string, gitBranch string, gitRefMode string, webhookSecret string) *Server {
 	s := &Server{
-		appState:    appState,
-		triggerHard: triggerHard,
-		triggerSoft: triggerSoft,
-		port:        port,
-		version:     version,
-		clients:     make(map[*websocket.Conn]bool),
-		broadcast:   make(chan []byte, 256),
+		appState:      appState,
+		omniClient:    omniClient,
+		queue:         reconcileQueue,
+		port:          port,
+		version:       version,
+		gitBranch:     gitBranch,
+		gitRefMode:    gitRefMode,
+		webhookSecret: webhookSecret,
+		clients:       make(map[*websocket.Conn]bool),
+		broadcast:     make(chan []byte, 256),
 	}
 
 	// Start broadcast handler
@@ -61,14 +71,23 @@ func (s *Server) Start() {
 
 	// API endpoints
 	mux.HandleFunc("/api/state", s.handleState)
+	mux.HandleFunc("/api/history", s.handleHistory)
 	mux.HandleFunc("/api/reconcile", s.handleReconcile)
+	mux.HandleFunc("/api/reconcile/cancel", s.handleCancelReconcile)
 	mux.HandleFunc("/api/check", s.handleCheck)
+	mux.HandleFunc("/api/queue", s.handleQueue)
+	mux.HandleFunc("/api/sync", s.handleSync)
 	mux.HandleFunc("/api/clusters-toggle", s.handleClustersToggle)
 	mux.HandleFunc("/api/force-cluster", s.handleForceCluster)
+	mux.HandleFunc("/api/confirm-delete", s.handleConfirmDelete)
 	mux.HandleFunc("/api/export-cluster", s.handleExportCluster)
+	mux.HandleFunc("/api/pin", s.handlePin)
+	mux.HandleFunc("/api/unpin", s.handleUnpin)
+	mux.HandleFunc("/api/webhook/{provider}", s.handleWebhook)
 
 	// Serve the UI
 	mux.HandleFunc("/clusters", s.handleUI)
+	mux.HandleFunc("/history", s.handleUI)
 	mux.HandleFunc("/", s.handleUI)
 
 	addr := fmt.Sprintf(":%s", s.port)
@@ -174,6 +193,9 @@ func (s *Server) hashState(snapshot state.SnapshotData) uint64 {
 	var hash uint64
 	hash = uint64(len(snapshot.MachineClasses))
 	hash = hash*31 + uint64(len(snapshot.Clusters))
+	hash = hash*31 + uint64(len(snapshot.ConfigPatches))
+	hash = hash*31 + uint64(len(snapshot.Machines))
+	hash = hash*31 + uint64(len(snapshot.Access))
 	hash = hash*31 + uint64(len(snapshot.Logs))
 	if snapshot.ClustersEnabled {
 		hash = hash * 31
@@ -185,9 +207,20 @@ func (s *Server) hashState(snapshot state.SnapshotData) uint64 {
 		hash = hash*31 + uint64(snapshot.LastReconcile.Status[0])
 	}
 	hash = hash*31 + uint64(len(snapshot.Git.SHA))
+	for _, b := range []byte(snapshot.Git.SHA + snapshot.Git.Tag + snapshot.Git.Signature + snapshot.PinnedSHA) {
+		hash = hash*31 + uint64(b)
+	}
+	if q := snapshot.Queue; q.Running != nil {
+		hash = hash*31 + uint64(q.Running.ID)
+	}
+	for _, req := range snapshot.Queue.Pending {
+		hash = hash*31 + uint64(req.ID)
+		hash = hash*31 + uint64(len(req.Triggers)+len(req.Clusters)+len(req.MachineClasses)+len(req.ClusterSelectors)+len(req.MachineClassSelectors))
+		hash = hash*31 + uint64(req.Kind[0])
+	}
 	// Include per-resource statuses so a status-only change is detected.
 	for _, c := range snapshot.Clusters {
-		for _, b := range []byte(c.Status) {
+		for _, b := range []byte(c.Status + strings.Join(c.Policy, ",")) {
 			hash = hash*31 + uint64(b)
 		}
 		if c.ClusterReady != "" {
@@ -200,12 +233,42 @@ func (s *Server) hashState(snapshot state.SnapshotData) uint64 {
 				hash = hash*31 + uint64(b)
 			}
 		}
+		if c.NextWindow != nil {
+			hash = hash*31 + uint64(c.NextWindow.Unix())
+		}
 	}
 	for _, m := range snapshot.MachineClasses {
+		for _, b := range []byte(m.Status + strings.Join(m.Policy, ",")) {
+			hash = hash*31 + uint64(b)
+		}
+		if m.Capacity != nil {
+			hash = hash*31 + uint64(m.Capacity.Available)
+			for _, b := range []byte(m.Capacity.Status) {
+				hash = hash*31 + uint64(b)
+			}
+		}
+	}
+	for _, m := range snapshot.Machines {
 		for _, b := range []byte(m.Status) {
 			hash = hash*31 + uint64(b)
 		}
 	}
+	for _, p := range snapshot.ConfigPatches {
+		for _, b := range []byte(p.Status) {
+			hash = hash*31 + uint64(b)
+		}
+	}
+	for _, a := range snapshot.Access {
+		for _, b := range []byte(a.Status + a.Role) {
+			hash = hash*31 + uint64(b)
+		}
+	}
+	hash = hash*31 + uint64(len(snapshot.History))
+	for _, h := range snapshot.History {
+		for _, b := range []byte(h.Outcome) {
+			hash = hash*31 + uint64(b)
+		}
+	}
 	return hash
 }
 
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"omni-cd/internal/queue"
+)
+
+// maxWebhookBody caps the size of a webhook payload we are willing to read.
+const maxWebhookBody = 10 << 20
+
+// pushEvent holds the fields we need from a push payload. GitHub, GitLab and
+// Gitea/Forgejo all report the pushed ref as "refs/heads/<branch>" or
+// "refs/tags/<tag>".
+type pushEvent struct {
+	Ref   string `json:"ref"`
+	After string `json:"after"`
+}
+
+// handleWebhook receives push notifications from a Git provider and triggers
+// a refresh when the tracked branch (or, in tag mode, any tag) was pushed.
+// The provider is taken from the path: /api/webhook/{github|gitlab|gitea|forgejo}.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider := r.PathValue("provider")
+
+	if s.webhookSecret == "" {
+		slog.Warn("Webhook received but WEBHOOK_SECRET is not set, rejecting", "provider", provider, "component", "Web")
+		http.Error(w, "Webhooks are not configured", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	var event string
+	var verified bool
+	switch provider {
+	case "github":
+		event = r.Header.Get("X-GitHub-Event")
+		verified = verifyHMAC(body, s.webhookSecret, strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="))
+	case "gitlab":
+		event = r.Header.Get("X-Gitlab-Event")
+		verified = subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(s.webhookSecret)) == 1
+	case "gitea", "forgejo":
+		event = r.Header.Get("X-Gitea-Event")
+		sig := r.Header.Get("X-Gitea-Signature")
+		if provider == "forgejo" && r.Header.Get("X-Forgejo-Signature") != "" {
+			event = r.Header.Get("X-Forgejo-Event")
+			sig = r.Header.Get("X-Forgejo-Signature")
+		}
+		verified = verifyHMAC(body, s.webhookSecret, sig)
+	default:
+		http.Error(w, "Unknown webhook provider", http.StatusNotFound)
+		return
+	}
+
+	if !verified {
+		slog.Warn("Webhook signature verification failed", "provider", provider, "component", "Web")
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	// GitHub sends a ping when a webhook is first created
+	if provider == "github" && event == "ping" {
+		writeWebhookStatus(w, "pong")
+		return
+	}
+
+	if event != "push" && event != "Push Hook" && event != "Tag Push Hook" {
+		slog.Debug("Webhook event ignored", "provider", provider, "event", event, "component", "Web")
+		writeWebhookStatus(w, "ignored")
+		return
+	}
+
+	var push pushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		http.Error(w, "Invalid push payload", http.StatusBadRequest)
+		return
+	}
+
+	// In tag mode any pushed tag may be a new release; the git client decides
+	// whether it matches the semver constraint.
+	tracked := push.Ref == "refs/heads/"+s.gitBranch
+	if s.gitRefMode == "tag" {
+		tracked = strings.HasPrefix(push.Ref, "refs/tags/")
+	}
+	if !tracked {
+		slog.Debug("Webhook push to untracked ref ignored", "provider", provider, "ref", push.Ref, "component", "Web")
+		writeWebhookStatus(w, "ignored")
+		return
+	}
+
+	slog.Info("Webhook push received", "provider", provider, "ref", push.Ref, "sha", push.After, "component", "Web")
+
+	// A refresh that is already pending picks up this push too
+	s.queue.Add(queue.Request{Kind: queue.Refresh}, "webhook "+provider)
+	writeWebhookStatus(w, "queued")
+}
+
+// verifyHMAC checks a hex-encoded HMAC-SHA256 signature of body.
+func verifyHMAC(body []byte, secret, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// writeWebhookStatus writes a JSON status response for a webhook request.
+// Providers only look at the status code, so every accepted delivery gets a 200.
+func writeWebhookStatus(w http.ResponseWriter, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}